@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// allowedUser tells whether the Telegram user may talk to the bot in private:
+// the users listed in ALLOWED_USERS and the people in the directory who have
+// a Telegram id. Anyone else who finds the bot is turned away.
+func allowedUser(cfg Config, people peopleDirectory, user *tgbotapi.User) bool {
+	return user != nil && allowedUserId(cfg, people, user.ID)
+}
+
+func allowedUserId(cfg Config, people peopleDirectory, userId int64) bool {
+	if userId == 0 {
+		return false
+	}
+
+	return slices.Contains(cfg.AllowedUsers, userId) || people.HasTelegramId(userId)
+}
+
+// allowedChat tells whether a command touching the calendars may be served:
+// anything sent in the family group chat, and whatever allowed users send
+// elsewhere.
+func allowedChat(cfg Config, people peopleDirectory, chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	if chat != nil && cfg.TelegramChatId != 0 && chat.ID == cfg.TelegramChatId {
+		return true
+	}
+
+	return allowedUser(cfg, people, user)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// minAdminAPITokenLength keeps the admin API from being guarded by a token
+// that's easy to guess.
+const minAdminAPITokenLength = 16
+
+// AdminAPI lets the bot's admins inspect its state and act on it over HTTP,
+// e.g. with curl. Every request must carry the token as a bearer token.
+type AdminAPI struct {
+	// config returns the current configuration, which changes on reload.
+	config        func() Config
+	clock         Clock
+	lastChkdDao   LastCheckedDao
+	outboxDao     OutboxDao
+	pollDao       PollDao
+	pausedDao     PausedCalendarsDao
+	notifications *recentNotifications
+	// triggers asks the daemon to run a cycle without waiting for the next
+	// tick.
+	triggers chan<- struct{}
+}
+
+// CalendarState is where the engine is at with a calendar.
+type CalendarState struct {
+	Id string `json:"id"`
+	// Paused calendars aren't fetched, and their changes are discarded
+	// rather than held until they're resumed.
+	Paused bool `json:"paused"`
+}
+
+// The kinds of scheduled reminders.
+const (
+	reminderKindDeferred   = "deferred"
+	reminderKindEmailRetry = "email_retry"
+	reminderKindPollResult = "poll_result"
+)
+
+// ScheduledReminder is a notification the bot is set to send later: one held
+// back during quiet hours, an email to retry, or the result of a poll.
+type ScheduledReminder struct {
+	Kind   string `json:"kind"`
+	ChatId int64  `json:"chatId,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Title  string `json:"title"`
+	// DueAt is when it's sent, at the first cycle after it, or null when
+	// it's sent on the next cycle.
+	DueAt *time.Time `json:"dueAt"`
+}
+
+// AdminState is the state of the bot, as shown by the admin API.
+type AdminState struct {
+	// LastChecked is when all the calendars were last checked, as they're
+	// checked together, or null before the first cycle.
+	LastChecked         *time.Time          `json:"lastChecked"`
+	Calendars           []CalendarState     `json:"calendars"`
+	Outbox              []OutboxEntry       `json:"outbox"`
+	Polls               []Poll              `json:"polls"`
+	Reminders           []ScheduledReminder `json:"reminders"`
+	RecentNotifications []SentNotification  `json:"recentNotifications"`
+}
+
+// ListenAndServe serves the API on AdminAPIListenAddr until the context is
+// done.
+func (a *AdminAPI) ListenAndServe(ctx context.Context) error {
+	return serveHTTP(ctx, a.config().AdminAPIListenAddr, a.Handler(), "admin API")
+}
+
+func (a *AdminAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/state", a.getState)
+	mux.HandleFunc("GET /api/outbox", a.getOutbox)
+	mux.HandleFunc("DELETE /api/outbox/{chatId}", a.clearOutbox)
+	mux.HandleFunc("DELETE /api/outbox/email/{email}", a.clearEmailOutbox)
+	mux.HandleFunc("GET /api/reminders", a.getReminders)
+	mux.HandleFunc("GET /api/notifications", a.getNotifications)
+	mux.HandleFunc("POST /api/notifications/{id}/resend", a.resendNotification)
+	mux.HandleFunc("POST /api/cycle", a.triggerCycle)
+	mux.HandleFunc("POST /api/calendars/{calendarId}/pause", a.pauseCalendar(true))
+	mux.HandleFunc("POST /api/calendars/{calendarId}/resume", a.pauseCalendar(false))
+
+	token := []byte(a.config().AdminAPIToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), token) != 1 {
+			log.WithField("remoteAddr", r.RemoteAddr).Warn("rejecting admin API request with a wrong token")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Info("admin API request")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (a *AdminAPI) getState(w http.ResponseWriter, r *http.Request) {
+	lastChecked, isExist, err := a.lastChkdDao.GetLastChecked()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	calendars, err := a.calendars()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	outbox, err := a.outboxDao.GetOutbox()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	polls, err := a.pollDao.GetPolls()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	reminders, err := a.reminders(outbox, polls)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state := AdminState{
+		Calendars:           calendars,
+		Outbox:              nonNil(outbox),
+		Polls:               nonNil(polls),
+		Reminders:           nonNil(reminders),
+		RecentNotifications: nonNil(a.notifications.Recent()),
+	}
+	if isExist {
+		state.LastChecked = &lastChecked
+	}
+	writeAPIResponse(w, http.StatusOK, state)
+}
+
+// calendars returns the state of each calendar.
+func (a *AdminAPI) calendars() ([]CalendarState, error) {
+	paused, err := a.pausedDao.GetPaused()
+	if err != nil {
+		return nil, err
+	}
+
+	var states []CalendarState
+	for _, calendarId := range a.config().Calendars() {
+		states = append(states, CalendarState{Id: calendarId, Paused: slices.Contains(paused, calendarId)})
+	}
+	return states, nil
+}
+
+func (a *AdminAPI) getOutbox(w http.ResponseWriter, r *http.Request) {
+	outbox, err := a.outboxDao.GetOutbox()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, nonNil(outbox))
+}
+
+// clearOutbox drops the notifications held back for a chat, e.g. when the
+// chat blocked the bot and they can't be delivered anymore.
+func (a *AdminAPI) clearOutbox(w http.ResponseWriter, r *http.Request) {
+	chatId, err := strconv.ParseInt(r.PathValue("chatId"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid chat id")
+		return
+	}
+
+	if err := a.outboxDao.RemoveChat(chatId); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.WithField("chatId", chatId).Info("cleared the outbox of the chat")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearEmailOutbox drops the emails waiting to be retried for a recipient,
+// e.g. when the address no longer exists.
+func (a *AdminAPI) clearEmailOutbox(w http.ResponseWriter, r *http.Request) {
+	email := r.PathValue("email")
+	if _, err := mail.ParseAddress(email); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	if err := a.outboxDao.RemoveEmail(email); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.WithField("email", email).Info("cleared the outbox of the email recipient")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminAPI) getReminders(w http.ResponseWriter, r *http.Request) {
+	outbox, err := a.outboxDao.GetOutbox()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	polls, err := a.pollDao.GetPolls()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	reminders, err := a.reminders(outbox, polls)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, nonNil(reminders))
+}
+
+// reminders lists what the bot is set to send later, in the order it's due.
+func (a *AdminAPI) reminders(outbox []OutboxEntry, polls []Poll) ([]ScheduledReminder, error) {
+	quiet, err := NewQuietHours(a.config())
+	if err != nil {
+		return nil, err
+	}
+	now := a.clock.Now()
+
+	var reminders []ScheduledReminder
+	for _, entry := range outbox {
+		reminder := ScheduledReminder{Kind: reminderKindDeferred, ChatId: entry.ChatId, Title: entry.Event.Title}
+		if entry.Email != "" {
+			reminder.Kind, reminder.Email = reminderKindEmailRetry, entry.Email
+		} else if until, ok := quiet.QuietUntil(entry.ChatId, now); ok {
+			reminder.DueAt = &until
+		}
+		reminders = append(reminders, reminder)
+	}
+	for _, poll := range polls {
+		deadline := poll.Deadline
+		reminders = append(reminders, ScheduledReminder{Kind: reminderKindPollResult, ChatId: poll.ChatId, Title: poll.Title, DueAt: &deadline})
+	}
+
+	slices.SortStableFunc(reminders, func(a, b ScheduledReminder) int {
+		switch {
+		case a.DueAt == nil && b.DueAt == nil:
+			return 0
+		case a.DueAt == nil:
+			return -1
+		case b.DueAt == nil:
+			return 1
+		default:
+			return a.DueAt.Compare(*b.DueAt)
+		}
+	})
+	return reminders, nil
+}
+
+func (a *AdminAPI) getNotifications(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, nonNil(a.notifications.Recent()))
+}
+
+func (a *AdminAPI) resendNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid notification id")
+		return
+	}
+
+	sent, ok, err := a.notifications.Resend(id)
+	switch {
+	case !ok:
+		writeAPIError(w, http.StatusNotFound, "no such recent notification")
+	case err != nil:
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+	default:
+		writeAPIResponse(w, http.StatusOK, sent)
+	}
+}
+
+func (a *AdminAPI) triggerCycle(w http.ResponseWriter, r *http.Request) {
+	// a cycle that's already pending covers this request too
+	select {
+	case a.triggers <- struct{}{}:
+	default:
+	}
+
+	writeAPIResponse(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+}
+
+// pauseCalendar stops or resumes announcing a calendar's changes. The changes
+// made while it's paused are never announced, even once it's resumed.
+func (a *AdminAPI) pauseCalendar(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calendarId := r.PathValue("calendarId")
+		if !slices.Contains(a.config().Calendars(), calendarId) {
+			writeAPIError(w, http.StatusNotFound, "no such calendar")
+			return
+		}
+
+		if err := a.pausedDao.SetPaused(calendarId, paused); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.WithFields(log.Fields{"calendarId": calendarId, "paused": paused}).Info("calendar paused state changed")
+
+		calendars, err := a.calendars()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		i := slices.IndexFunc(calendars, func(c CalendarState) bool { return c.Id == calendarId })
+		writeAPIResponse(w, http.StatusOK, calendars[i])
+	}
+}
+
+// nonNil makes empty lists show as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.WithError(err).Warn("error writing admin API response")
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIResponse(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const testAdminAPIToken = "0123456789abcdef"
+
+type AdminAPISuite struct {
+	suite.Suite
+	cfg        Config
+	clock      *FakeClock
+	telCliMock *TelegramClientMock
+	triggers   chan struct{}
+	api        *AdminAPI
+}
+
+func TestAdminAPISuite(t *testing.T) {
+	suite.Run(t, new(AdminAPISuite))
+}
+
+func (s *AdminAPISuite) SetupTest() {
+	dir := s.T().TempDir()
+	s.cfg = Config{
+		CalendarId:          "family",
+		ExtraCalendarIds:    []string{"school"},
+		AdminAPIToken:       testAdminAPIToken,
+		LastCheckedFile:     filepath.Join(dir, "last_checked.txt"),
+		OutboxFile:          filepath.Join(dir, "outbox.json"),
+		PollsFile:           filepath.Join(dir, "polls.json"),
+		PausedCalendarsFile: filepath.Join(dir, "paused_calendars.json"),
+	}
+	s.clock = NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	s.telCliMock = &TelegramClientMock{}
+	s.triggers = make(chan struct{}, 1)
+	s.api = &AdminAPI{
+		config:        func() Config { return s.cfg },
+		clock:         s.clock,
+		lastChkdDao:   NewLastCheckedDao(s.cfg),
+		outboxDao:     NewOutboxDao(s.cfg),
+		pollDao:       NewPollDao(s.cfg),
+		pausedDao:     NewPausedCalendarsDao(s.cfg),
+		notifications: newRecentNotifications(s.telCliMock, s.clock),
+		triggers:      s.triggers,
+	}
+}
+
+func (s *AdminAPISuite) request(method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminAPIToken)
+	rec := httptest.NewRecorder()
+	s.api.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *AdminAPISuite) decode(rec *httptest.ResponseRecorder, v any) {
+	s.Require().NoError(json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+}
+
+func (s *AdminAPISuite) TestRejectsWrongToken() {
+	for _, header := range []string{"", "Bearer wrong", "Basic " + testAdminAPIToken} {
+		req := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+
+		s.api.Handler().ServeHTTP(rec, req)
+
+		s.Assert().Equal(http.StatusUnauthorized, rec.Code, header)
+	}
+}
+
+func (s *AdminAPISuite) TestState() {
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.api.lastChkdDao.SetLastChecked(lastChecked))
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{ChatId: 42, Event: CalendarEvent{Id: "queued"}}))
+	s.Require().NoError(s.api.pausedDao.SetPaused("school", true))
+	s.telCliMock.On("NotifyEvent", int64(42), mock.Anything).Return(nil)
+	_, err := s.api.notifications.NotifyEvent(42, CalendarEvent{Id: "sent"})
+	s.Require().NoError(err)
+
+	rec := s.request(http.MethodGet, "/api/state")
+
+	s.Require().Equal(http.StatusOK, rec.Code)
+	var state AdminState
+	s.decode(rec, &state)
+	s.Require().Len(state.Calendars, 2)
+	s.Assert().Equal("family", state.Calendars[0].Id)
+	s.Assert().False(state.Calendars[0].Paused)
+	s.Assert().True(lastChecked.Equal(*state.LastChecked))
+	s.Assert().True(state.Calendars[1].Paused)
+	s.Require().Len(state.Outbox, 1)
+	s.Assert().Equal("queued", state.Outbox[0].Event.Id)
+	s.Assert().Empty(state.Polls)
+	s.Require().Len(state.RecentNotifications, 1)
+	s.Assert().Equal("sent", state.RecentNotifications[0].Events[0].Id)
+}
+
+func (s *AdminAPISuite) TestStateBeforeFirstCycle() {
+	rec := s.request(http.MethodGet, "/api/state")
+
+	s.Require().Equal(http.StatusOK, rec.Code)
+	s.Assert().Contains(rec.Body.String(), `"lastChecked": null`)
+	s.Assert().Contains(rec.Body.String(), `"outbox": []`)
+}
+
+func (s *AdminAPISuite) TestReminders() {
+	s.cfg.ChatQuietHours = map[int64]string{42: "09:00-12:00"}
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{ChatId: 42, Event: CalendarEvent{Title: "Dinner"}}))
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{Email: "grandma@example.com", Event: CalendarEvent{Title: "Dentist"}}))
+	deadline := s.clock.Now().Add(time.Hour)
+	s.Require().NoError(s.api.pollDao.SavePoll(Poll{Id: "poll", ChatId: -100, Title: "Trip", Deadline: deadline}))
+
+	rec := s.request(http.MethodGet, "/api/reminders")
+
+	s.Require().Equal(http.StatusOK, rec.Code)
+	var reminders []ScheduledReminder
+	s.decode(rec, &reminders)
+	s.Require().Len(reminders, 3)
+	s.Assert().Equal(ScheduledReminder{Kind: reminderKindEmailRetry, Email: "grandma@example.com", Title: "Dentist"}, reminders[0])
+	s.Assert().Equal(reminderKindPollResult, reminders[1].Kind)
+	s.Assert().True(deadline.Equal(*reminders[1].DueAt))
+	s.Assert().Equal(reminderKindDeferred, reminders[2].Kind)
+	s.Assert().Equal(int64(42), reminders[2].ChatId)
+	s.Assert().True(time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC).Equal(*reminders[2].DueAt))
+}
+
+func (s *AdminAPISuite) TestClearOutbox() {
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{ChatId: 42}))
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{ChatId: 7}))
+
+	rec := s.request(http.MethodDelete, "/api/outbox/42")
+
+	s.Assert().Equal(http.StatusNoContent, rec.Code)
+	outbox, err := s.api.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Require().Len(outbox, 1)
+	s.Assert().Equal(int64(7), outbox[0].ChatId)
+	s.Assert().Equal(http.StatusBadRequest, s.request(http.MethodDelete, "/api/outbox/everyone").Code)
+}
+
+func (s *AdminAPISuite) TestClearEmailOutbox() {
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{Email: "grandma@example.com"}))
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{Email: "grandpa@example.com"}))
+	s.Require().NoError(s.api.outboxDao.Enqueue(OutboxEntry{ChatId: 42}))
+
+	rec := s.request(http.MethodDelete, "/api/outbox/email/grandma@example.com")
+
+	s.Require().Equal(http.StatusNoContent, rec.Code, rec.Body.String())
+	outbox, err := s.api.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Require().Len(outbox, 2)
+	s.Assert().Equal("grandpa@example.com", outbox[0].Email)
+	s.Assert().Equal(int64(42), outbox[1].ChatId)
+	s.Assert().Equal(http.StatusBadRequest, s.request(http.MethodDelete, "/api/outbox/email/grandma").Code)
+}
+
+func (s *AdminAPISuite) TestResendNotification() {
+	event := CalendarEvent{Id: "dentist"}
+	s.telCliMock.On("NotifyEvent", int64(42), event).Return(nil).Once()
+	s.telCliMock.On("NotifyEvent", int64(42), event).Return(errors.New("blocked")).Once()
+	s.telCliMock.On("NotifyEvent", int64(42), event).Return(nil).Once()
+	_, err := s.api.notifications.NotifyEvent(42, event)
+	s.Require().NoError(err)
+
+	rec := s.request(http.MethodPost, "/api/notifications/1/resend")
+	s.Assert().Equal(http.StatusBadGateway, rec.Code)
+	s.Assert().Contains(rec.Body.String(), "blocked")
+
+	rec = s.request(http.MethodPost, "/api/notifications/1/resend")
+	s.Require().Equal(http.StatusOK, rec.Code)
+	var sent SentNotification
+	s.decode(rec, &sent)
+	s.Assert().Equal(2, sent.Id)
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 3)
+
+	s.Assert().Equal(http.StatusNotFound, s.request(http.MethodPost, "/api/notifications/9/resend").Code)
+}
+
+func (s *AdminAPISuite) TestTriggerCycle() {
+	s.Assert().Equal(http.StatusAccepted, s.request(http.MethodPost, "/api/cycle").Code)
+	// doesn't block while a cycle is pending
+	s.Assert().Equal(http.StatusAccepted, s.request(http.MethodPost, "/api/cycle").Code)
+
+	s.Assert().Len(s.triggers, 1)
+}
+
+func (s *AdminAPISuite) TestPauseAndResumeCalendar() {
+	rec := s.request(http.MethodPost, "/api/calendars/school/pause")
+	s.Require().Equal(http.StatusOK, rec.Code)
+	var state CalendarState
+	s.decode(rec, &state)
+	s.Assert().True(state.Paused)
+	paused, err := s.api.pausedDao.GetPaused()
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"school"}, paused)
+
+	rec = s.request(http.MethodPost, "/api/calendars/school/resume")
+	s.Require().Equal(http.StatusOK, rec.Code)
+	s.decode(rec, &state)
+	s.Assert().False(state.Paused)
+
+	s.Assert().Equal(http.StatusNotFound, s.request(http.MethodPost, "/api/calendars/work/pause").Code)
+	s.Assert().Equal(http.StatusMethodNotAllowed, s.request(http.MethodGet, "/api/calendars/school/pause").Code)
+}
+
+func TestRecentNotificationsAreCapped(t *testing.T) {
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("NotifyDigest", int64(42), mock.Anything).Return(nil)
+	notifications := newRecentNotifications(telCliMock, NewSystemClock())
+
+	for range maxRecentNotifications + 5 {
+		_, err := notifications.NotifyDigest(42, []CalendarEvent{{Id: "a"}, {Id: "b"}})
+		require.NoError(t, err)
+	}
+
+	recent := notifications.Recent()
+	require.Len(t, recent, maxRecentNotifications)
+	assert.Equal(t, maxRecentNotifications+5, recent[0].Id, "latest first")
+	assert.Equal(t, notificationDigest, recent[0].Kind)
+	_, ok, err := notifications.Resend(1)
+	assert.NoError(t, err)
+	assert.False(t, ok, "dropped")
+}
+
+func TestEngineSkipsPausedCalendars(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		CalendarId:          "family",
+		ExtraCalendarIds:    []string{"school"},
+		FirstRunLookBack:    time.Hour,
+		PausedCalendarsFile: filepath.Join(dir, "paused_calendars.json"),
+	}
+	pausedDao := NewPausedCalendarsDao(cfg)
+	require.NoError(t, pausedDao.SetPaused("school", true))
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetRecentEvents", mock.Anything, "family", mock.Anything).Return([]CalendarEvent{}, nil)
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		lastChkdDao: NewLastCheckedDao(Config{LastCheckedFile: filepath.Join(dir, "last_checked.txt")}),
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   NewOutboxDao(Config{OutboxFile: filepath.Join(dir, "outbox.json")}),
+		pausedDao:   pausedDao,
+		clock:       NewSystemClock(),
+	}
+
+	require.NoError(t, engine.Work(context.Background()))
+
+	calSvcMock.AssertNumberOfCalls(t, "GetRecentEvents", 1)
+	calSvcMock.AssertNotCalled(t, "GetRecentEvents", mock.Anything, "school", mock.Anything)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	auditSourceCalendar = "calendar"
+	auditSourceOutbox   = "outbox"
+)
+
+// AuditRecord is what the engine decided about an event it saw, and what came
+// of it.
+type AuditRecord struct {
+	CycleId    string      `json:"cycleId"`
+	At         time.Time   `json:"at"`
+	CalendarId string      `json:"calendarId"`
+	EventId    string      `json:"eventId"`
+	Title      string      `json:"title"`
+	Start      time.Time   `json:"start"`
+	Status     EventStatus `json:"status"`
+	// Source is where the event came from: the calendar, or the outbox of
+	// the notifications deferred during quiet hours.
+	Source string `json:"source"`
+	// Filters are the checks the event went through, in order, up to the
+	// first one it failed.
+	Filters    []AuditFilter   `json:"filters,omitempty"`
+	Deliveries []AuditDelivery `json:"deliveries,omitempty"`
+}
+
+type AuditFilter struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+}
+
+// AuditDelivery is the notification of a chat, or of an email recipient,
+// about the event.
+type AuditDelivery struct {
+	ChatId    int64  `json:"chatId,omitempty"`
+	Email     string `json:"email,omitempty"`
+	MessageId int    `json:"messageId,omitempty"`
+	// Deferred tells the notification was queued for after the chat's quiet
+	// hours, or the email for a retry on the next cycle.
+	Deferred bool `json:"deferred,omitempty"`
+	// Summarized tells the event was announced along with others, in a
+	// single message.
+	Summarized bool   `json:"summarized,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Outcome sums up the record: "filtered by" the failed filter, "failed",
+// "deferred", "notified", or "pending" if nothing was sent yet.
+func (r AuditRecord) Outcome() string {
+	for _, filter := range r.Filters {
+		if !filter.Passed {
+			return "filtered by " + filter.Name
+		}
+	}
+
+	outcome := "pending"
+	for _, delivery := range r.Deliveries {
+		switch {
+		case delivery.Error != "":
+			return "failed"
+		case delivery.Deferred && outcome == "pending":
+			outcome = "deferred"
+		case !delivery.Deferred:
+			outcome = "notified"
+		}
+	}
+	return outcome
+}
+
+// AuditQuery selects audit records. Zero fields match everything.
+type AuditQuery struct {
+	From       time.Time
+	To         time.Time
+	CalendarId string
+	// Title matches the records whose title contains it, regardless of case.
+	Title string
+}
+
+func (q AuditQuery) Matches(r AuditRecord) bool {
+	if !q.From.IsZero() && r.At.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.At.Before(q.To) {
+		return false
+	}
+	if q.CalendarId != "" && r.CalendarId != q.CalendarId {
+		return false
+	}
+	return q.Title == "" || strings.Contains(strings.ToLower(r.Title), strings.ToLower(q.Title))
+}
+
+// AuditDao keeps the audit log. Records are only ever appended.
+type AuditDao interface {
+	Append(records []AuditRecord) error
+	Query(q AuditQuery) ([]AuditRecord, error)
+}
+
+func NewAuditDao(cfg Config) AuditDao {
+	return &auditDao{
+		cfg: cfg,
+	}
+}
+
+// auditDao stores the records as JSON lines, so that appending doesn't
+// rewrite the whole log.
+type auditDao struct {
+	cfg Config
+	mu  sync.Mutex
+}
+
+func (d *auditDao) Append(records []AuditRecord) error {
+	if d.cfg.AuditLogFile == "" || len(records) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	for _, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return errors.Wrap(err, "error encoding audit record")
+		}
+		sb.Write(b)
+		sb.WriteByte('\n')
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.cfg.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error opening audit log")
+	}
+	if _, err := f.WriteString(sb.String()); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "error writing audit log")
+	}
+	return errors.Wrap(f.Close(), "error writing audit log")
+}
+
+// maxAuditLineSize bounds the size of a record, which grows with the number
+// of chats notified.
+const maxAuditLineSize = 1 << 20
+
+func (d *auditDao) Query(q AuditQuery) ([]AuditRecord, error) {
+	if d.cfg.AuditLogFile == "" {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.Open(d.cfg.AuditLogFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening audit log")
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrapf(err, "error reading audit log, line %d", line)
+		}
+		if q.Matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	return records, errors.Wrap(scanner.Err(), "error reading audit log")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuditDaoSuite struct {
+	suite.Suite
+	filename string
+	dao      AuditDao
+}
+
+func TestAuditDaoSuite(t *testing.T) {
+	suite.Run(t, new(AuditDaoSuite))
+}
+
+func (s *AuditDaoSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.jsonl", "test_audit_log", time.Now().Unix())
+	s.dao = NewAuditDao(Config{AuditLogFile: s.filename})
+}
+
+func (s *AuditDaoSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *AuditDaoSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *AuditDaoSuite) TestQueryBeforeAppend() {
+	records, err := s.dao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Assert().Empty(records)
+}
+
+func (s *AuditDaoSuite) TestAppendQuery() {
+	at := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	first := []AuditRecord{
+		{CycleId: "a", At: at, CalendarId: "family", EventId: "1", Title: "Dentist", Source: auditSourceCalendar,
+			Filters:    []AuditFilter{{Name: filterReasonOwner, Passed: true}},
+			Deliveries: []AuditDelivery{{ChatId: 42, MessageId: 17}}},
+		{CycleId: "a", At: at, CalendarId: "school", EventId: "2", Title: "Parents meeting", Source: auditSourceCalendar},
+	}
+	second := []AuditRecord{
+		{CycleId: "b", At: at.Add(time.Hour), CalendarId: "family", EventId: "3", Title: "Football practice", Source: auditSourceCalendar},
+	}
+	s.Require().NoError(s.dao.Append(first))
+	s.Require().NoError(s.dao.Append(nil))
+	s.Require().NoError(s.dao.Append(second))
+
+	tests := []struct {
+		name     string
+		q        AuditQuery
+		expected []string
+	}{
+		{"everything", AuditQuery{}, []string{"1", "2", "3"}},
+		{"from", AuditQuery{From: at.Add(time.Minute)}, []string{"3"}},
+		{"to is exclusive", AuditQuery{To: at.Add(time.Hour)}, []string{"1", "2"}},
+		{"calendar", AuditQuery{CalendarId: "family"}, []string{"1", "3"}},
+		{"title regardless of case", AuditQuery{Title: "MEET"}, []string{"2"}},
+		{"nothing", AuditQuery{CalendarId: "family", Title: "meeting"}, nil},
+	}
+	for _, test := range tests {
+		s.Run(test.name, func() {
+			records, err := s.dao.Query(test.q)
+			s.Require().NoError(err)
+			var ids []string
+			for _, record := range records {
+				ids = append(ids, record.EventId)
+			}
+			s.Assert().Equal(test.expected, ids)
+		})
+	}
+
+	records, err := s.dao.Query(AuditQuery{Title: "dentist"})
+	s.Require().NoError(err)
+	s.Assert().Equal(first[:1], records)
+}
+
+func (s *AuditDaoSuite) TestDisabled() {
+	dao := NewAuditDao(Config{})
+
+	s.Require().NoError(dao.Append([]AuditRecord{{EventId: "1"}}))
+	records, err := dao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Assert().Empty(records)
+}
+
+func TestAuditRecordOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   AuditRecord
+		expected string
+	}{
+		{"filtered", AuditRecord{Filters: []AuditFilter{
+			{Name: filterReasonOwner, Passed: true},
+			{Name: filterReasonOutdated, Passed: false},
+		}}, "filtered by outdated"},
+		{"nothing sent", AuditRecord{}, "pending"},
+		{"notified", AuditRecord{Deliveries: []AuditDelivery{{ChatId: 1, MessageId: 2}}}, "notified"},
+		{"deferred", AuditRecord{Deliveries: []AuditDelivery{{ChatId: 1, Deferred: true}}}, "deferred"},
+		{"notified some, deferred others", AuditRecord{Deliveries: []AuditDelivery{
+			{ChatId: 1, Deferred: true},
+			{ChatId: 2, MessageId: 3},
+		}}, "notified"},
+		{"failed", AuditRecord{Deliveries: []AuditDelivery{
+			{ChatId: 1, MessageId: 2},
+			{ChatId: 3, Error: "forbidden"},
+		}}, "failed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.record.Outcome())
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var sinceLayouts = []string{time.RFC3339, "2006-01-02 15:04", time.DateOnly}
+
+// parseSince parses either a point in time, in the given location unless it
+// has its own offset, or a duration back from now.
+func parseSince(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid duration: %q", s)
+		}
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			if t.After(now) {
+				return time.Time{}, fmt.Errorf("time is in the future: %q", s)
+			}
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %q", s)
+}
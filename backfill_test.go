@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSince(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	now := time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"72h", now.Add(-72 * time.Hour)},
+		{"2024-06-03", time.Date(2024, time.June, 3, 0, 0, 0, 0, tz)},
+		{"2024-06-03 18:00", time.Date(2024, time.June, 3, 18, 0, 0, 0, tz)},
+		{"2024-06-03T18:00:00Z", time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			actual, err := parseSince(test.input, now, tz)
+			require.NoError(t, err)
+			assert.True(t, test.expected.Equal(actual), "expected %s, got %s", test.expected, actual)
+		})
+	}
+
+	for _, input := range []string{"", "yesterday", "-1h", "2024-07-01"} {
+		t.Run("invalid "+input, func(t *testing.T) {
+			_, err := parseSince(input, now, tz)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"google.golang.org/api/calendar/v3"
@@ -13,7 +16,18 @@ import (
 
 type CalendarService interface {
 	Init(ctx context.Context) error
-	GetRecentEvents(ctx context.Context, since time.Time) ([]CalendarEvent, error)
+	GetRecentEvents(ctx context.Context, calendarId string, since time.Time) ([]CalendarEvent, error)
+	// GetEvents returns the events of the calendar that take place, even in
+	// part, between from and to.
+	GetEvents(ctx context.Context, calendarId string, from, to time.Time) ([]CalendarEvent, error)
+	// GetBusy returns the periods between from and to in which any of the
+	// calendars is busy.
+	GetBusy(ctx context.Context, calendarIds []string, from, to time.Time) ([]TimeRange, error)
+	CreateEvent(ctx context.Context, calendarId string, event CalendarEvent) (CalendarEvent, error)
+	DeleteEvent(ctx context.Context, calendarId string, eventId string) error
+	// DeclineEvent declines the invitation to the event on behalf of the
+	// calendar, leaving the event itself intact for everyone else.
+	DeclineEvent(ctx context.Context, calendarId string, eventId string) error
 }
 
 type EventStatus int
@@ -25,12 +39,67 @@ const (
 	StatusUnknown
 )
 
+var statusNames = map[EventStatus]string{
+	StatusCreated:  "created",
+	StatusUpdated:  "updated",
+	StatusCanceled: "canceled",
+	StatusUnknown:  "unknown",
+}
+
+func (s EventStatus) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("EventStatus(%d)", int(s))
+}
+
+func (s EventStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *EventStatus) UnmarshalText(text []byte) error {
+	status, err := ParseEventStatus(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = status
+	return nil
+}
+
+// ParseEventStatus parses the name of a status, as returned by String.
+func ParseEventStatus(name string) (EventStatus, error) {
+	for status, statusName := range statusNames {
+		if strings.EqualFold(name, statusName) {
+			return status, nil
+		}
+	}
+
+	return StatusUnknown, fmt.Errorf("unknown event status: %q", name)
+}
+
+// CalendarEvent is a change made to a calendar. Transparent events don't
+// block time, i.e. their attendees are shown as free. Tentative events are
+// yet to be confirmed. Conflicts lists the events overlapping this one, when
+// checked. ICalUID and Sequence identify the event and its revision in other
+// calendar apps.
 type CalendarEvent struct {
-	Title   string
-	Start   time.Time
-	End     time.Time
-	Creator string
-	Status  EventStatus
+	Id          string          `json:"id,omitempty"`
+	CalendarId  string          `json:"calendarId"`
+	Title       string          `json:"title"`
+	Location    string          `json:"location,omitempty"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	Creator     string          `json:"creator"`
+	Attendees   []string        `json:"attendees,omitempty"`
+	Status      EventStatus     `json:"status"`
+	AllDay      bool            `json:"allDay,omitempty"`
+	Transparent bool            `json:"transparent,omitempty"`
+	Tentative   bool            `json:"tentative,omitempty"`
+	Conflicts   []CalendarEvent `json:"conflicts,omitempty"`
+	ICalUID     string          `json:"iCalUID,omitempty"`
+	Sequence    int64           `json:"sequence,omitempty"`
 }
 
 func NewCalendarService(cfg Config) CalendarService {
@@ -59,36 +128,156 @@ func (c *calendarClient) Init(ctx context.Context) error {
 	return nil
 }
 
-func (c *calendarClient) GetRecentEvents(ctx context.Context, since time.Time) ([]CalendarEvent, error) {
-	events, err := c.svc.Events.
-		List(c.cfg.CalendarId).
+func (c *calendarClient) GetRecentEvents(ctx context.Context, calendarId string, since time.Time) ([]CalendarEvent, error) {
+	var resp []CalendarEvent
+	err := c.svc.Events.
+		List(calendarId).
 		ShowDeleted(true).
 		SingleEvents(true).
 		UpdatedMin(since.Format(time.RFC3339)).
-		MaxResults(10).
 		OrderBy("updated").
-		Context(ctx).
-		Do()
+		Pages(ctx, func(events *calendar.Events) error {
+			resp = append(resp, toCalendarEvents(calendarId, events.Items)...)
+			return nil
+		})
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]CalendarEvent, 0, len(events.Items))
-	for _, e := range events.Items {
-		resp = append(resp, CalendarEvent{
-			Title:   e.Summary,
-			Start:   parseEventStart(e),
-			End:     parseEventEnd(e),
-			Creator: getEventCreator(e),
-			Status:  parseEventStatus(e),
+	return resp, nil
+}
+
+func (c *calendarClient) GetEvents(ctx context.Context, calendarId string, from, to time.Time) ([]CalendarEvent, error) {
+	var resp []CalendarEvent
+	err := c.svc.Events.
+		List(calendarId).
+		SingleEvents(true).
+		TimeMin(from.Format(time.RFC3339)).
+		TimeMax(to.Format(time.RFC3339)).
+		OrderBy("startTime").
+		Pages(ctx, func(events *calendar.Events) error {
+			resp = append(resp, toCalendarEvents(calendarId, events.Items)...)
+			return nil
 		})
+	if err != nil {
+		return nil, err
 	}
+
 	return resp, nil
 }
 
+func (c *calendarClient) GetBusy(ctx context.Context, calendarIds []string, from, to time.Time) ([]TimeRange, error) {
+	req := &calendar.FreeBusyRequest{
+		TimeMin: from.Format(time.RFC3339),
+		TimeMax: to.Format(time.RFC3339),
+	}
+	for _, calendarId := range calendarIds {
+		req.Items = append(req.Items, &calendar.FreeBusyRequestItem{Id: calendarId})
+	}
+
+	resp, err := c.svc.Freebusy.Query(req).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	var busy []TimeRange
+	for calendarId, cal := range resp.Calendars {
+		if len(cal.Errors) > 0 {
+			return nil, fmt.Errorf("error getting free/busy of %s: %s", calendarId, cal.Errors[0].Reason)
+		}
+		for _, period := range cal.Busy {
+			start, err := time.Parse(time.RFC3339, period.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := time.Parse(time.RFC3339, period.End)
+			if err != nil {
+				return nil, err
+			}
+			busy = append(busy, TimeRange{Start: start, End: end})
+		}
+	}
+
+	return busy, nil
+}
+
+func (c *calendarClient) CreateEvent(ctx context.Context, calendarId string, event CalendarEvent) (CalendarEvent, error) {
+	e := &calendar.Event{
+		Summary: event.Title,
+		Start:   &calendar.EventDateTime{DateTime: event.Start.Format(time.RFC3339)},
+		End:     &calendar.EventDateTime{DateTime: event.End.Format(time.RFC3339)},
+		Status:  googleStatusConfirmed,
+	}
+	if event.Tentative {
+		e.Status = googleStatusTentative
+	}
+	for _, email := range event.Attendees {
+		e.Attendees = append(e.Attendees, &calendar.EventAttendee{Email: email})
+	}
+
+	created, err := c.svc.Events.Insert(calendarId, e).Context(ctx).Do()
+	if err != nil {
+		return CalendarEvent{}, err
+	}
+
+	return toCalendarEvents(calendarId, []*calendar.Event{created})[0], nil
+}
+
+func (c *calendarClient) DeleteEvent(ctx context.Context, calendarId string, eventId string) error {
+	return c.svc.Events.Delete(calendarId, eventId).Context(ctx).Do()
+}
+
+func (c *calendarClient) DeclineEvent(ctx context.Context, calendarId string, eventId string) error {
+	event, err := c.svc.Events.Get(calendarId, eventId).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(event.Attendees, func(a *calendar.EventAttendee) bool { return a.Self })
+	if i < 0 {
+		return fmt.Errorf("calendar %s isn't invited to event %s", calendarId, eventId)
+	}
+
+	// patching replaces the whole list of attendees, so it's sent back with
+	// only the calendar's own response changed
+	event.Attendees[i].ResponseStatus = googleResponseDeclined
+	patch := &calendar.Event{Attendees: event.Attendees}
+	_, err = c.svc.Events.Patch(calendarId, eventId, patch).Context(ctx).Do()
+	return err
+}
+
+func toCalendarEvents(calendarId string, items []*calendar.Event) []CalendarEvent {
+	resp := make([]CalendarEvent, 0, len(items))
+	for _, e := range items {
+		resp = append(resp, CalendarEvent{
+			Id:          e.Id,
+			CalendarId:  calendarId,
+			Title:       e.Summary,
+			Location:    e.Location,
+			Start:       parseEventStart(e),
+			End:         parseEventEnd(e),
+			Creator:     getEventCreator(e),
+			Attendees:   getEventAttendees(e),
+			Status:      parseEventStatus(e),
+			AllDay:      e.Start != nil && e.Start.Date != "",
+			Transparent: e.Transparency == googleTransparencyTransparent,
+			Tentative:   e.Status == googleStatusTentative,
+			ICalUID:     e.ICalUID,
+			Sequence:    e.Sequence,
+		})
+	}
+
+	return resp
+}
+
 const (
 	googleStatusConfirmed = "confirmed"
+	googleStatusTentative = "tentative"
 	googleStatusCancelled = "cancelled"
+
+	googleTransparencyTransparent = "transparent"
+
+	googleResponseDeclined = "declined"
 )
 
 func getEventCreator(event *calendar.Event) string {
@@ -99,9 +288,23 @@ func getEventCreator(event *calendar.Event) string {
 	return event.Creator.Email
 }
 
+// getEventAttendees returns the emails of the people invited to the event,
+// skipping resources such as meeting rooms.
+func getEventAttendees(event *calendar.Event) []string {
+	var attendees []string
+	for _, a := range event.Attendees {
+		if a.Resource || a.Email == "" {
+			continue
+		}
+		attendees = append(attendees, a.Email)
+	}
+
+	return attendees
+}
+
 func parseEventStatus(event *calendar.Event) EventStatus {
 	switch event.Status {
-	case googleStatusConfirmed:
+	case googleStatusConfirmed, googleStatusTentative:
 		if isEventUpdated(event) {
 			return StatusUpdated
 		} else {
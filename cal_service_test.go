@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
 )
 
 func TestParseStatus(t *testing.T) {
@@ -43,6 +52,15 @@ func TestParseStatus(t *testing.T) {
 			},
 			expected: StatusUpdated,
 		},
+		{
+			name: "tentative event",
+			input: &calendar.Event{
+				Status:  googleStatusTentative,
+				Created: baseTime.Format(time.RFC3339),
+				Updated: baseTime.Format(time.RFC3339),
+			},
+			expected: StatusCreated,
+		},
 		{
 			name: "cancelled event",
 			input: &calendar.Event{
@@ -123,3 +141,126 @@ func parseDateNoError(dateString string) time.Time {
 	t, _ := time.Parse(time.DateOnly, dateString)
 	return t
 }
+
+func TestEventStatusText(t *testing.T) {
+	for _, status := range []EventStatus{StatusCreated, StatusUpdated, StatusCanceled, StatusUnknown} {
+		text, err := status.MarshalText()
+		assert.NoError(t, err)
+
+		var actual EventStatus
+		assert.NoError(t, actual.UnmarshalText(text))
+		assert.Equal(t, status, actual)
+	}
+
+	_, err := ParseEventStatus("moved")
+	assert.Error(t, err)
+}
+
+func TestGetEventAttendees(t *testing.T) {
+	event := &calendar.Event{
+		Attendees: []*calendar.EventAttendee{
+			{Email: "mom@example.com"},
+			{Email: "room@resource.calendar.google.com", Resource: true},
+			{Email: "dad@example.com", Self: true},
+		},
+	}
+
+	assert.Equal(t, []string{"mom@example.com", "dad@example.com"}, getEventAttendees(event))
+}
+
+func TestToCalendarEvents(t *testing.T) {
+	items := []*calendar.Event{
+		{
+			Id:           "busy",
+			Summary:      "Dentist",
+			Status:       googleStatusConfirmed,
+			Start:        &calendar.EventDateTime{DateTime: "2024-06-03T16:00:00+03:00"},
+			End:          &calendar.EventDateTime{DateTime: "2024-06-03T17:00:00+03:00"},
+			Transparency: "opaque",
+		},
+		{
+			Id:           "free",
+			Summary:      "Reminder",
+			Status:       googleStatusConfirmed,
+			Start:        &calendar.EventDateTime{Date: "2024-06-03"},
+			End:          &calendar.EventDateTime{Date: "2024-06-04"},
+			Transparency: googleTransparencyTransparent,
+		},
+	}
+
+	events := toCalendarEvents("family", items)
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, "busy", events[0].Id)
+	assert.Equal(t, "family", events[0].CalendarId)
+	assert.False(t, events[0].Transparent)
+	assert.False(t, events[0].AllDay)
+	assert.Equal(t, "free", events[1].Id)
+	assert.True(t, events[1].Transparent)
+	assert.True(t, events[1].AllDay)
+}
+
+// newTestCalendarClient serves the changed events of a calendar from a fake
+// Calendar API, a page at a time.
+func newTestCalendarClient(t *testing.T, pages ...[]*calendar.Event) *calendarClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			_, _ = fmt.Sscan(token, &page)
+		}
+		resp := calendar.Events{Items: pages[page]}
+		if page+1 < len(pages) {
+			resp.NextPageToken = fmt.Sprint(page + 1)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	svc, err := calendar.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	require.NoError(t, err)
+	return &calendarClient{svc: svc}
+}
+
+func TestGetRecentEventsAllPages(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	var pages [][]*calendar.Event
+	for page := 0; page < 2; page++ {
+		var items []*calendar.Event
+		for i := 0; i < 10-4*page; i++ {
+			items = append(items, &calendar.Event{
+				Id:      fmt.Sprintf("event-%d-%d", page, i),
+				Summary: "Dinner",
+				Status:  googleStatusConfirmed,
+				Start:   &calendar.EventDateTime{DateTime: "2024-06-04T19:00:00Z"},
+				End:     &calendar.EventDateTime{DateTime: "2024-06-04T21:00:00Z"},
+				Creator: &calendar.EventCreator{Email: "someone@example.com"},
+			})
+		}
+		pages = append(pages, items)
+	}
+	calSvc := newTestCalendarClient(t, pages...)
+
+	events, err := calSvc.GetRecentEvents(ctx, "family", clock.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, events, 16)
+
+	// more changes than the default catch-up threshold are summarized
+	dir := t.TempDir()
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("NotifyCatchUp", int64(42), mock.Anything, events).Return(nil)
+	engine := Engine{
+		cfg:         Config{CalendarId: "family", TelegramChatId: 42, FirstRunLookBack: time.Hour, CatchUpThreshold: 10},
+		calSvc:      calSvc,
+		telcli:      telCliMock,
+		lastChkdDao: NewLastCheckedDao(Config{LastCheckedFile: filepath.Join(dir, "last_checked.txt")}),
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   NewOutboxDao(Config{OutboxFile: filepath.Join(dir, "outbox.json")}),
+		clock:       clock,
+	}
+
+	require.NoError(t, engine.Work(ctx))
+
+	telCliMock.AssertNumberOfCalls(t, "NotifyCatchUp", 1)
+	telCliMock.AssertNotCalled(t, "NotifyEvent", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-envconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes of the command line, for scripting.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitConfig  = 3
+)
+
+// version is set when building, with -ldflags "-X main.version=...".
+var version = "dev"
+
+const usage = `Usage: calendarbot <command> [flags]
+
+Commands:
+  run            a daemon if DAEMON_INTERVAL is set, a single check otherwise (default)
+  once           check the calendars once and notify about the changes
+  daemon         keep checking the calendars and answer users' commands
+  backfill       replay the changes made since a given time
+  notify-test    send a sample notification to verify the Telegram setup
+  list-events    list the events between two times
+  history        show what was decided about the events seen, and what was sent
+  state show     show the state kept between runs
+  state reset    forget when the calendars were last checked
+  config check   print the resolved configuration and validate it
+  version        print the version
+
+Run 'calendarbot <command> -h' for the flags of a command.
+
+Exit codes: 0 success, 1 failure, 2 invalid usage, 3 invalid configuration.
+`
+
+// usageError is returned for invalid command lines.
+type usageError struct {
+	error
+}
+
+// configError is returned when the configuration can't be read or is
+// invalid.
+type configError struct {
+	error
+}
+
+func exitCode(err error) int {
+	var usageErr usageError
+	var configErr configError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &configErr):
+		return exitConfig
+	default:
+		return exitFailure
+	}
+}
+
+// cli runs a command line, resolving the configuration from the environment
+// and the flags of the command.
+type cli struct {
+	lookuper envconfig.Lookuper
+	clock    Clock
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// Run runs the command line, without the program name, and returns the exit
+// code.
+func (c *cli) Run(ctx context.Context, args []string) int {
+	name := "run"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	// the state and config commands have subcommands of their own
+	if (name == "state" || name == "config") && len(args) > 0 {
+		name, args = name+" "+args[0], args[1:]
+	}
+
+	commands := map[string]func(ctx context.Context, args []string) error{
+		"run":          c.run,
+		"once":         c.once,
+		"daemon":       c.daemon,
+		"backfill":     c.backfill,
+		"notify-test":  c.notifyTest,
+		"list-events":  c.listEvents,
+		"history":      c.history,
+		"state show":   c.stateShow,
+		"state reset":  c.stateReset,
+		"config check": c.configCheck,
+		"version":      c.version,
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Fprint(c.stdout, usage)
+		return exitOK
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(c.stderr, "unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+
+	err := command(ctx, args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(c.stderr, "calendarbot %s: %v\n", name, err)
+		return exitCode(err)
+	}
+
+	return exitOK
+}
+
+// commandFlags are the flags of a command. Flags registered with Setting
+// override the setting of the same environment variable.
+type commandFlags struct {
+	*flag.FlagSet
+	settings map[string]*string
+}
+
+func (c *cli) newFlags(name string) commandFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	f := commandFlags{FlagSet: fs, settings: make(map[string]*string)}
+	f.Setting("config", "CONFIG_FILE", "path of the config file")
+	return f
+}
+
+func (f commandFlags) Setting(name, env, usage string) {
+	f.settings[env] = f.String(name, "", fmt.Sprintf("%s (overrides %s)", usage, env))
+}
+
+// DryRun registers --dry-run, and --output for where the messages go.
+func (f commandFlags) DryRun() {
+	dryRun := new(string)
+	f.settings["DRY_RUN"] = dryRun
+	f.BoolFunc("dry-run", "print the notifications instead of sending them, without saving any state (overrides DRY_RUN)", func(s string) error {
+		if _, err := strconv.ParseBool(s); err != nil {
+			return err
+		}
+		*dryRun = s
+		return nil
+	})
+	f.Setting("output", "DRY_RUN_OUTPUT", "file to write the notifications of a dry run to, stdout if not set")
+}
+
+// parse parses the arguments, none of which may be left over.
+func (f commandFlags) parse(args []string) error {
+	if err := f.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err}
+	}
+	if f.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", f.Args())}
+	}
+
+	return nil
+}
+
+// lookuper layers the settings given by flags over the environment.
+func (f commandFlags) lookuper(base envconfig.Lookuper) envconfig.Lookuper {
+	overrides := make(map[string]string)
+	for env, value := range f.settings {
+		if *value != "" {
+			overrides[env] = *value
+		}
+	}
+
+	return envconfig.MultiLookuper(envconfig.MapLookuper(overrides), base)
+}
+
+// loadConfig resolves and validates the configuration of the command.
+func (c *cli) loadConfig(ctx context.Context, flags commandFlags) (Config, error) {
+	cfg, sources, err := ReadConfig(ctx, flags.lookuper(c.lookuper))
+	if err != nil {
+		return cfg, configError{err}
+	}
+	if err := ValidateConfig(cfg, sources); err != nil {
+		return cfg, configError{err}
+	}
+	if err := ConfigureLogging(cfg); err != nil {
+		return cfg, configError{err}
+	}
+
+	return cfg, nil
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	flags := c.newFlags("run")
+	flags.DryRun()
+	flags.Setting("interval", "DAEMON_INTERVAL", "how often to check the calendars, runs once if not set")
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to notify")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+
+	// a dry run checks the calendars once, as nothing it does is kept
+	if cfg.DaemonInterval > 0 && !cfg.DryRun {
+		return c.runDaemon(ctx, cfg, flags)
+	}
+	return c.runOnce(ctx, cfg)
+}
+
+func (c *cli) once(ctx context.Context, args []string) error {
+	flags := c.newFlags("once")
+	flags.DryRun()
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to notify")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+
+	return c.runOnce(ctx, cfg)
+}
+
+func (c *cli) daemon(ctx context.Context, args []string) error {
+	flags := c.newFlags("daemon")
+	flags.Setting("interval", "DAEMON_INTERVAL", "how often to check the calendars")
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to notify")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	if cfg.DaemonInterval <= 0 {
+		return usageError{errors.New("missing --interval or DAEMON_INTERVAL")}
+	}
+	if cfg.DryRun {
+		return usageError{errors.New("DRY_RUN isn't supported by the daemon, use once instead")}
+	}
+
+	return c.runDaemon(ctx, cfg, flags)
+}
+
+func (c *cli) backfill(ctx context.Context, args []string) error {
+	flags := c.newFlags("backfill")
+	sinceFlag := flags.String("since", "", "replay changes made since this time, e.g. 2024-06-03, \"2024-06-03 18:00\" or 72h")
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to notify")
+	flags.DryRun()
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	if *sinceFlag == "" {
+		return usageError{errors.New("missing --since")}
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	out, closeOut, err := c.dryRunOutput(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	a, err := newApp(ctx, cfg, c.clock, out)
+	if err != nil {
+		return err
+	}
+
+	since, err := parseSince(*sinceFlag, a.clock.Now(), a.loc)
+	if err != nil {
+		return usageError{err}
+	}
+
+	return a.engine.Backfill(ctx, since)
+}
+
+func (c *cli) notifyTest(ctx context.Context, args []string) error {
+	flags := c.newFlags("notify-test")
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to send the sample notification to")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return configError{err}
+	}
+
+	telcli := NewTelegram(cfg, peopleDirectory{}, addressBook{}, c.clock)
+	if err := telcli.Init(); err != nil {
+		return errors.Wrap(err, "error initializing telegram client")
+	}
+	if _, err := telcli.NotifyEvent(cfg.TelegramChatId, sampleEvent(c.clock.Now(), loc)); err != nil {
+		return errors.Wrap(err, "error sending the sample notification")
+	}
+
+	fmt.Fprintf(c.stdout, "sent a sample notification to chat %d\n", cfg.TelegramChatId)
+	return nil
+}
+
+// sampleEvent is the event notify-test notifies about, starting on the next
+// round hour.
+func sampleEvent(now time.Time, loc *time.Location) CalendarEvent {
+	start := now.In(loc).Truncate(time.Hour).Add(time.Hour)
+	return CalendarEvent{
+		Id:     "notify-test",
+		Title:  "הודעת בדיקה",
+		Start:  start,
+		End:    start.Add(time.Hour),
+		Status: StatusCreated,
+	}
+}
+
+func (c *cli) listEvents(ctx context.Context, args []string) error {
+	flags := c.newFlags("list-events")
+	fromFlag := flags.String("from", "", "list events from this time, e.g. 2024-06-03, \"2024-06-03 18:00\" or -24h (default today)")
+	toFlag := flags.String("to", "", "list events until this time, in the same formats as --from (default a week after --from)")
+	calendarFlag := flags.String("calendar", "", "list the events of this calendar only")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return configError{err}
+	}
+
+	now := c.clock.Now().In(loc)
+	from := dateOf(now)
+	if *fromFlag != "" {
+		if from, err = parseTimeFlag(*fromFlag, now, loc); err != nil {
+			return usageError{errors.Wrap(err, "invalid --from")}
+		}
+	}
+	to := from.AddDate(0, 0, 7)
+	if *toFlag != "" {
+		if to, err = parseTimeFlag(*toFlag, now, loc); err != nil {
+			return usageError{errors.Wrap(err, "invalid --to")}
+		}
+	}
+	if !to.After(from) {
+		return usageError{errors.New("--to must be after --from")}
+	}
+
+	calendars := cfg.Calendars()
+	if *calendarFlag != "" {
+		if !slices.Contains(calendars, *calendarFlag) {
+			return usageError{fmt.Errorf("unknown calendar %q", *calendarFlag)}
+		}
+		calendars = []string{*calendarFlag}
+	}
+
+	calSvc := NewCalendarService(cfg)
+	if err := calSvc.Init(ctx); err != nil {
+		return errors.Wrap(err, "error initializing calendar service")
+	}
+
+	return printEvents(ctx, c.stdout, calSvc, calendars, from, to, loc)
+}
+
+// parseTimeFlag parses either a point in time, in the given location unless
+// it has its own offset, or a duration from now, which may be negative.
+func parseTimeFlag(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %q", s)
+}
+
+// printEvents lists the events of the calendars between from and to, by their
+// start time.
+func printEvents(ctx context.Context, w io.Writer, calSvc CalendarService, calendars []string, from, to time.Time, loc *time.Location) error {
+	var events []CalendarEvent
+	for _, calendarId := range calendars {
+		calendarEvents, err := calSvc.GetEvents(ctx, calendarId, from, to)
+		if err != nil {
+			return errors.Wrapf(err, "error getting events of %s", calendarId)
+		}
+		events = append(events, calendarEvents...)
+	}
+	slices.SortStableFunc(events, func(a, b CalendarEvent) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, event := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", eventSpan(event, loc), event.CalendarId, eventTitle(event))
+	}
+	return tw.Flush()
+}
+
+// eventSpan writes when the event takes place, in a sortable format.
+func eventSpan(event CalendarEvent, loc *time.Location) string {
+	if event.AllDay {
+		if calendarDaysBetween(event.Start, event.End) == 0 {
+			return event.Start.Format(time.DateOnly) + " all day"
+		}
+		return event.Start.Format(time.DateOnly) + "–" + event.End.Format(time.DateOnly)
+	}
+
+	start, end := event.Start.In(loc), event.End.In(loc)
+	if calendarDaysBetween(start, end) == 0 {
+		return start.Format("2006-01-02 15:04") + "–" + end.Format("15:04")
+	}
+	return start.Format("2006-01-02 15:04") + "–" + end.Format("2006-01-02 15:04")
+}
+
+func (c *cli) history(ctx context.Context, args []string) error {
+	flags := c.newFlags("history")
+	sinceFlag := flags.String("since", "", "show the events seen since this time, e.g. 2024-06-03, \"2024-06-03 18:00\" or -24h")
+	untilFlag := flags.String("until", "", "show the events seen until this time, in the same formats as --since")
+	calendarFlag := flags.String("calendar", "", "show the events of this calendar only")
+	titleFlag := flags.String("title", "", "show the events whose title contains this text")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return configError{err}
+	}
+	if cfg.AuditLogFile == "" {
+		return configError{errors.New("the audit log is disabled, set AUDIT_LOG_FILE")}
+	}
+
+	now := c.clock.Now().In(loc)
+	q := AuditQuery{CalendarId: *calendarFlag, Title: *titleFlag}
+	if *sinceFlag != "" {
+		if q.From, err = parseTimeFlag(*sinceFlag, now, loc); err != nil {
+			return usageError{errors.Wrap(err, "invalid --since")}
+		}
+	}
+	if *untilFlag != "" {
+		if q.To, err = parseTimeFlag(*untilFlag, now, loc); err != nil {
+			return usageError{errors.Wrap(err, "invalid --until")}
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.To.After(q.From) {
+		return usageError{errors.New("--until must be after --since")}
+	}
+
+	records, err := NewAuditDao(cfg).Query(q)
+	if err != nil {
+		return errors.Wrap(err, "error reading audit log")
+	}
+
+	return printHistory(c.stdout, records, loc)
+}
+
+// printHistory lists the audit records in the order they were made.
+func printHistory(w io.Writer, records []AuditRecord, loc *time.Location) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.At.In(loc).Format("2006-01-02 15:04:05"),
+			record.CalendarId,
+			record.Title,
+			record.Status,
+			record.Outcome(),
+			deliveriesSummary(record.Deliveries))
+	}
+	return tw.Flush()
+}
+
+// deliveriesSummary writes the chats and emails notified about an event, with
+// the ids of the messages sent to the chats, e.g. "42#17, 43 deferred, 44:
+// forbidden, grandma@example.com".
+func deliveriesSummary(deliveries []AuditDelivery) string {
+	if len(deliveries) == 0 {
+		return "-"
+	}
+
+	var parts []string
+	for _, delivery := range deliveries {
+		part := strconv.FormatInt(delivery.ChatId, 10)
+		if delivery.Email != "" {
+			part = delivery.Email
+		}
+		switch {
+		case delivery.Error != "":
+			part += ": " + delivery.Error
+		case delivery.Deferred:
+			part += " deferred"
+		case delivery.MessageId != 0:
+			part += "#" + strconv.Itoa(delivery.MessageId)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (c *cli) stateShow(ctx context.Context, args []string) error {
+	flags := c.newFlags("state show")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+
+	lastChecked, isExist, err := NewLastCheckedDao(cfg).GetLastChecked()
+	if err != nil {
+		return errors.Wrap(err, "error reading last checked")
+	}
+	outbox, err := NewOutboxDao(cfg).GetOutbox()
+	if err != nil {
+		return errors.Wrap(err, "error reading outbox")
+	}
+	subs, err := NewSubscriptionDao(cfg).GetSubscriptions()
+	if err != nil {
+		return errors.Wrap(err, "error reading subscriptions")
+	}
+	polls, err := NewPollDao(cfg).GetPolls()
+	if err != nil {
+		return errors.Wrap(err, "error reading polls")
+	}
+
+	checked := "never"
+	if isExist {
+		checked = lastChecked.Format(time.RFC3339)
+	}
+	chats := make(map[int64]bool)
+	recipients := make(map[string]bool)
+	emails := 0
+	for _, entry := range outbox {
+		if entry.Email != "" {
+			recipients[entry.Email] = true
+			emails++
+		} else {
+			chats[entry.ChatId] = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "last checked:\t%s\n", checked)
+	fmt.Fprintf(tw, "outbox:\t%d messages to %d chats\n", len(outbox)-emails, len(chats))
+	if emails > 0 {
+		fmt.Fprintf(tw, "email retries:\t%d emails to %d recipients\n", emails, len(recipients))
+	}
+	fmt.Fprintf(tw, "subscriptions:\t%d\n", len(subs))
+	fmt.Fprintf(tw, "open polls:\t%d\n", len(polls))
+	return tw.Flush()
+}
+
+func (c *cli) stateReset(ctx context.Context, args []string) error {
+	flags := c.newFlags("state reset")
+	toFlag := flags.String("to", "", "mark the calendars as checked at this time instead, e.g. 2024-06-03 or -1h")
+	outboxFlag := flags.Bool("outbox", false, "also drop the messages held back during quiet hours")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig(ctx, flags)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return configError{err}
+	}
+
+	lastChkdDao := NewLastCheckedDao(cfg)
+	if *toFlag == "" {
+		if err := lastChkdDao.ClearLastChecked(); err != nil {
+			return errors.Wrap(err, "error clearing last checked")
+		}
+		fmt.Fprintln(c.stdout, "cleared the last check, the next run looks back FIRST_RUN_LOOK_BACK")
+	} else {
+		to, err := parseTimeFlag(*toFlag, c.clock.Now(), loc)
+		if err != nil {
+			return usageError{errors.Wrap(err, "invalid --to")}
+		}
+		if err := lastChkdDao.SetLastChecked(to); err != nil {
+			return errors.Wrap(err, "error setting last checked")
+		}
+		fmt.Fprintf(c.stdout, "marked the calendars as checked at %s\n", to.Format(time.RFC3339))
+	}
+
+	if *outboxFlag {
+		outboxDao := NewOutboxDao(cfg)
+		outbox, err := outboxDao.GetOutbox()
+		if err != nil {
+			return errors.Wrap(err, "error reading outbox")
+		}
+		for _, entry := range outbox {
+			remove := func() error { return outboxDao.RemoveChat(entry.ChatId) }
+			if entry.Email != "" {
+				remove = func() error { return outboxDao.RemoveEmail(entry.Email) }
+			}
+			if err := remove(); err != nil {
+				return errors.Wrap(err, "error clearing outbox")
+			}
+		}
+		fmt.Fprintf(c.stdout, "dropped %d held back messages\n", len(outbox))
+	}
+
+	return nil
+}
+
+func (c *cli) configCheck(ctx context.Context, args []string) error {
+	flags := c.newFlags("config check")
+	if err := flags.parse(args); err != nil {
+		return err
+	}
+
+	if code := RunConfigCheck(ctx, flags.lookuper(c.lookuper), c.stdout, c.stderr); code != exitOK {
+		return configError{errors.New("invalid config")}
+	}
+	return nil
+}
+
+func (c *cli) version(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	if err := (commandFlags{FlagSet: flags}).parse(args); err != nil {
+		return err
+	}
+
+	revision := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+				revision = setting.Value[:7]
+			}
+		}
+	}
+
+	fmt.Fprintf(c.stdout, "calendarbot %s (revision %s, %s)\n", version, revision, runtime.Version())
+	return nil
+}
+
+// app holds the bot's services, as wired for checking the calendars.
+type app struct {
+	cfg           Config
+	loc           *time.Location
+	clock         Clock
+	people        peopleDirectory
+	calSvc        CalendarService
+	telcli        Telegram
+	lastChkdDao   LastCheckedDao
+	outboxDao     OutboxDao
+	subsDao       SubscriptionDao
+	modDao        ModerationDao
+	pausedDao     PausedCalendarsDao
+	auditDao      AuditDao
+	notifications *recentNotifications
+	metrics       *Metrics
+	health        *Health
+	engine        *Engine
+}
+
+// newApp wires the services for cfg. A dry run writes its messages to out and
+// keeps the state in memory.
+func newApp(ctx context.Context, cfg Config, clock Clock, out io.Writer) (*app, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "error loading timezone")}
+	}
+
+	people, err := LoadPeople(cfg.PeopleFile)
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "error loading people")}
+	}
+
+	places, err := LoadAddressBook(cfg.AddressBookFile)
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "error loading address book")}
+	}
+
+	emailRecipients, err := LoadEmailRecipients(cfg.EmailRecipientsFile)
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "error loading email recipients")}
+	}
+
+	quiet, err := NewQuietHours(cfg)
+	if err != nil {
+		return nil, configError{errors.Wrap(err, "error reading quiet hours")}
+	}
+
+	calSvc := NewCalendarService(cfg)
+	if err := calSvc.Init(ctx); err != nil {
+		return nil, errors.Wrap(err, "error initializing calendar service")
+	}
+
+	telcli := NewTelegram(cfg, people, places, clock)
+	var mailer Mailer
+	if len(emailRecipients) > 0 {
+		mailer = NewMailer(cfg, people, clock)
+	}
+	lastChkdDao := NewLastCheckedDao(cfg)
+	outboxDao := NewOutboxDao(cfg)
+	modDao := NewModerationDao(cfg, clock)
+	auditDao := NewAuditDao(cfg)
+	if cfg.DryRun {
+		log.Info("dry run, notifications won't be sent and state won't be saved")
+		telcli = NewDryRunTelegram(cfg, people, places, clock, out)
+		if mailer != nil {
+			mailer = NewDryRunMailer(cfg, people, clock, out)
+		}
+		lastChkdDao = &dryRunLastCheckedDao{dao: lastChkdDao}
+		outboxDao = &dryRunOutboxDao{dao: outboxDao}
+		modDao = &dryRunModerationDao{dao: modDao, clock: clock}
+		auditDao = &dryRunAuditDao{dao: auditDao}
+	}
+	if err := telcli.Init(); err != nil {
+		return nil, errors.Wrap(err, "error initializing telegram client")
+	}
+	if mailer != nil {
+		if err := mailer.Init(); err != nil {
+			return nil, errors.Wrap(err, "error initializing mailer")
+		}
+	}
+
+	metrics := NewMetrics(outboxDao)
+	calSvc = instrumentedCalendarService{CalendarService: calSvc, metrics: metrics, clock: clock}
+	notifications := newRecentNotifications(instrumentedTelegram{Telegram: telcli, metrics: metrics}, clock)
+	telcli = notifications
+
+	a := &app{
+		cfg:           cfg,
+		loc:           loc,
+		clock:         clock,
+		people:        people,
+		calSvc:        calSvc,
+		telcli:        telcli,
+		lastChkdDao:   lastChkdDao,
+		outboxDao:     outboxDao,
+		subsDao:       NewSubscriptionDao(cfg),
+		modDao:        modDao,
+		pausedDao:     NewPausedCalendarsDao(cfg),
+		auditDao:      auditDao,
+		notifications: notifications,
+		metrics:       metrics,
+		health:        NewHealth(cfg, telcli, clock),
+	}
+	a.engine = &Engine{
+		cfg:             cfg,
+		calSvc:          calSvc,
+		telcli:          telcli,
+		mailer:          mailer,
+		emailRecipients: emailRecipients,
+		lastChkdDao:     lastChkdDao,
+		subsDao:         a.subsDao,
+		outboxDao:       outboxDao,
+		modDao:          a.modDao,
+		pausedDao:       a.pausedDao,
+		auditDao:        a.auditDao,
+		people:          people,
+		quiet:           quiet,
+		conflicts:       newConflictDetector(cfg, calSvc),
+		clock:           clock,
+		metrics:         metrics,
+		health:          a.health,
+	}
+
+	return a, nil
+}
+
+func (c *cli) runOnce(ctx context.Context, cfg Config) error {
+	out, closeOut, err := c.dryRunOutput(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	a, err := newApp(ctx, cfg, c.clock, out)
+	if err != nil {
+		return err
+	}
+
+	return a.engine.Work(ctx)
+}
+
+// dryRunOutput opens where a dry run writes its messages.
+func (c *cli) dryRunOutput(cfg Config) (io.Writer, func(), error) {
+	if !cfg.DryRun || cfg.DryRunOutput == "" {
+		return c.stdout, func() {}, nil
+	}
+
+	f, err := os.Create(cfg.DryRunOutput)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating dry run output")
+	}
+	return f, func() {
+		if err := f.Close(); err != nil {
+			log.WithError(err).Error("error closing dry run output")
+		}
+	}, nil
+}
+
+func (c *cli) runDaemon(ctx context.Context, cfg Config, flags commandFlags) error {
+	a, err := newApp(ctx, cfg, c.clock, c.stdout)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	reloader := newConfigReloader(cfg, flags.lookuper(c.lookuper), a.telcli, signals)
+
+	// the command handlers read the configuration through the reloader, to
+	// pick up reloaded settings
+	dispatcher := NewDispatcher()
+	subsCmds := subscriptionCommands{config: reloader.current, subsDao: a.subsDao, telcli: a.telcli, people: a.people}
+	subsCmds.Register(dispatcher)
+	freeCmds := freeCommands{config: reloader.current, calSvc: a.calSvc, telcli: a.telcli, clock: a.clock, loc: a.loc, people: a.people}
+	freeCmds.Register(dispatcher)
+	pollDao := NewPollDao(cfg)
+	pollCmds := pollCommands{config: reloader.current, calSvc: a.calSvc, telcli: a.telcli, pollDao: pollDao, clock: a.clock, loc: a.loc, people: a.people}
+	pollCmds.Register(dispatcher)
+	modCmds := moderationCommands{config: reloader.current, calSvc: a.calSvc, telcli: a.telcli, modDao: a.modDao, people: a.people, clock: a.clock}
+	modCmds.Register(dispatcher)
+
+	var updates UpdateListener = a.telcli
+	if cfg.WebhookURL != "" {
+		updates = NewWebhookServer(cfg, a.telcli)
+	}
+
+	triggers := make(chan struct{}, 1)
+	adminAPI := &AdminAPI{
+		config:        reloader.current,
+		clock:         a.clock,
+		lastChkdDao:   a.lastChkdDao,
+		outboxDao:     a.outboxDao,
+		pollDao:       pollDao,
+		pausedDao:     a.pausedDao,
+		notifications: a.notifications,
+		triggers:      triggers,
+	}
+
+	daemon := Daemon{
+		cfg:        cfg,
+		engine:     a.engine,
+		updates:    updates,
+		dispatcher: dispatcher,
+		reloader:   reloader,
+		jobs:       []func(ctx context.Context) error{pollCmds.CloseExpired},
+		metrics:    a.metrics,
+		health:     a.health,
+		adminAPI:   adminAPI,
+		triggers:   triggers,
+	}
+	log.WithField("interval", cfg.DaemonInterval).Info("running as a daemon")
+	return daemon.Run(ctx)
+}
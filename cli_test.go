@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCLI returns a command line whose state files are in a temporary
+// directory, with a valid config file.
+func newTestCLI(t *testing.T) (*cli, *bytes.Buffer, *bytes.Buffer, string) {
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials.json")
+	require.NoError(t, os.WriteFile(credentials, []byte("{}"), 0644))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`calendar_id: family
+telegram_token: token
+telegram_chat_id: 42
+google_service_account_file: `+credentials+`
+last_checked_file: `+filepath.Join(dir, "last_checked.txt")+`
+outbox_file: `+filepath.Join(dir, "outbox.json")+`
+subscriptions_file: `+filepath.Join(dir, "subscriptions.json")+`
+polls_file: `+filepath.Join(dir, "polls.json")+`
+audit_log_file: `+filepath.Join(dir, "audit_log.jsonl")+`
+`), 0644))
+
+	var stdout, stderr bytes.Buffer
+	c := &cli{
+		lookuper: envconfig.MapLookuper(map[string]string{"CONFIG_FILE": path}),
+		clock:    NewFakeClock(time.Date(2024, time.June, 3, 21, 0, 0, 0, time.UTC)),
+		stdout:   &stdout,
+		stderr:   &stderr,
+	}
+
+	return c, &stdout, &stderr, dir
+}
+
+func TestCLIUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{"help", []string{"help"}, exitOK},
+		{"help of a command", []string{"state", "show", "-h"}, exitOK},
+		{"unknown command", []string{"start"}, exitUsage},
+		{"unknown subcommand", []string{"state", "drop"}, exitUsage},
+		{"unknown flag", []string{"once", "--verbose"}, exitUsage},
+		{"extra arguments", []string{"once", "now"}, exitUsage},
+		{"backfill without since", []string{"backfill"}, exitUsage},
+		{"daemon without interval", []string{"daemon"}, exitUsage},
+		{"invalid dry run", []string{"once", "--dry-run=maybe"}, exitUsage},
+		{"dry run of the daemon", []string{"daemon", "--interval", "1m", "--dry-run"}, exitUsage},
+		{"invalid time", []string{"state", "reset", "--to", "yesterday"}, exitUsage},
+		{"history until before since", []string{"history", "--since", "2024-06-03", "--until", "2024-06-02"}, exitUsage},
+		{"invalid config override", []string{"state", "show", "--config", "/nonexistent/config.yaml"}, exitConfig},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, stderr, _ := newTestCLI(t)
+
+			code := c.Run(context.Background(), test.args)
+
+			assert.Equal(t, test.expected, code, stderr.String())
+		})
+	}
+}
+
+func TestCLIInvalidConfig(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{
+		lookuper: envconfig.MapLookuper(map[string]string{"CONFIG_FILE": writeConfigFile(t, "calendar_id: family\n")}),
+		clock:    NewSystemClock(),
+		stdout:   &stdout,
+		stderr:   &stderr,
+	}
+
+	code := c.Run(context.Background(), []string{"state", "show"})
+
+	assert.Equal(t, exitConfig, code)
+	assert.Contains(t, stderr.String(), "telegram_token: required")
+}
+
+func TestCLIVersion(t *testing.T) {
+	c, stdout, _, _ := newTestCLI(t)
+
+	assert.Equal(t, exitOK, c.Run(context.Background(), []string{"version"}))
+	assert.Contains(t, stdout.String(), "calendarbot dev (revision ")
+}
+
+func TestCLIState(t *testing.T) {
+	c, stdout, stderr, dir := newTestCLI(t)
+	cfg := Config{
+		LastCheckedFile: filepath.Join(dir, "last_checked.txt"),
+		OutboxFile:      filepath.Join(dir, "outbox.json"),
+	}
+	lastChecked := time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)
+	require.NoError(t, NewLastCheckedDao(cfg).SetLastChecked(lastChecked))
+	outboxDao := NewOutboxDao(cfg)
+	require.NoError(t, outboxDao.Enqueue(OutboxEntry{ChatId: 1, Event: CalendarEvent{Title: "Dinner"}}))
+	require.NoError(t, outboxDao.Enqueue(OutboxEntry{ChatId: 1, Event: CalendarEvent{Title: "Lunch"}}))
+	require.NoError(t, outboxDao.Enqueue(OutboxEntry{ChatId: 2, Event: CalendarEvent{Title: "Dinner"}}))
+
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"state", "show"}), stderr.String())
+	assert.Equal(t, `last checked:   2024-06-03T18:00:00Z
+outbox:         3 messages to 2 chats
+subscriptions:  0
+open polls:     0
+`, stdout.String())
+
+	stdout.Reset()
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"state", "reset", "--outbox"}), stderr.String())
+	_, isExist, err := NewLastCheckedDao(cfg).GetLastChecked()
+	require.NoError(t, err)
+	assert.False(t, isExist)
+	outbox, err := outboxDao.GetOutbox()
+	require.NoError(t, err)
+	assert.Empty(t, outbox)
+	assert.Contains(t, stdout.String(), "dropped 3 held back messages")
+
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"state", "reset", "--to", "2024-06-03T20:00:00Z"}), stderr.String())
+	actual, isExist, err := NewLastCheckedDao(cfg).GetLastChecked()
+	require.NoError(t, err)
+	assert.True(t, isExist)
+	assert.True(t, actual.Equal(time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC)))
+
+	// relative to the clock
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"state", "reset", "--to", "-3h"}), stderr.String())
+	actual, _, err = NewLastCheckedDao(cfg).GetLastChecked()
+	require.NoError(t, err)
+	assert.True(t, actual.Equal(time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)))
+}
+
+func TestCLIHistory(t *testing.T) {
+	c, stdout, stderr, dir := newTestCLI(t)
+	at := time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)
+	require.NoError(t, NewAuditDao(Config{AuditLogFile: filepath.Join(dir, "audit_log.jsonl")}).Append([]AuditRecord{
+		{At: at, CalendarId: "family", Title: "Dinner", Status: StatusCreated,
+			Filters:    []AuditFilter{{Name: filterReasonOwner, Passed: true}},
+			Deliveries: []AuditDelivery{{ChatId: 42, MessageId: 17}, {ChatId: 11, Error: "forbidden"}}},
+		{At: at, CalendarId: "kids", Title: "Camp", Status: StatusUpdated,
+			Filters: []AuditFilter{{Name: filterReasonOutdated, Passed: false}}},
+		{At: at.Add(time.Hour), CalendarId: "family", Title: "Dentist", Status: StatusCanceled,
+			Deliveries: []AuditDelivery{{ChatId: 42, Deferred: true}}},
+	}))
+
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"history"}), stderr.String())
+	assert.Equal(t, `2024-06-03 21:00:00  family  Dinner   created   failed                42#17, 11: forbidden
+2024-06-03 21:00:00  kids    Camp     updated   filtered by outdated  -
+2024-06-03 22:00:00  family  Dentist  canceled  deferred              42 deferred
+`, stdout.String())
+
+	stdout.Reset()
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"history", "--calendar", "family", "--title", "DIN"}), stderr.String())
+	assert.Contains(t, stdout.String(), "Dinner")
+	assert.NotContains(t, stdout.String(), "Dentist")
+
+	stdout.Reset()
+	require.Equal(t, exitOK, c.Run(context.Background(), []string{"history", "--since", "2024-06-03T18:30:00Z"}), stderr.String())
+	assert.Contains(t, stdout.String(), "Dentist")
+	assert.NotContains(t, stdout.String(), "Dinner")
+}
+
+func TestCommandFlagsOverrideConfig(t *testing.T) {
+	c, _, _, _ := newTestCLI(t)
+	flags := c.newFlags("once")
+	flags.Setting("chat", "TELEGRAM_CHAT_ID", "the chat to notify")
+	require.NoError(t, flags.parse([]string{"--chat", "-100"}))
+
+	cfg, err := c.loadConfig(context.Background(), flags)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(-100), cfg.TelegramChatId)
+	assert.Equal(t, "family", cfg.CalendarId)
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitOK, exitCode(nil))
+	assert.Equal(t, exitUsage, exitCode(usageError{errors.New("missing --since")}))
+	assert.Equal(t, exitConfig, exitCode(configError{ConfigErrors{"telegram_token: required"}}))
+	assert.Equal(t, exitFailure, exitCode(errors.New("quota exceeded")))
+}
+
+func TestPrintEvents(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	ctx := context.Background()
+	from := time.Date(2024, time.June, 3, 0, 0, 0, 0, tz)
+	to := from.AddDate(0, 0, 7)
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetEvents", ctx, "family", from, to).Return([]CalendarEvent{
+		{CalendarId: "family", Title: "Dinner", Start: time.Date(2024, time.June, 4, 17, 0, 0, 0, time.UTC), End: time.Date(2024, time.June, 4, 18, 30, 0, 0, time.UTC)},
+		{CalendarId: "family", Title: "Trip", Start: time.Date(2024, time.June, 5, 0, 0, 0, 0, time.UTC), End: time.Date(2024, time.June, 6, 0, 0, 0, 0, time.UTC), AllDay: true},
+	}, nil)
+	calSvcMock.On("GetEvents", ctx, "kids", from, to).Return([]CalendarEvent{
+		{CalendarId: "kids", Start: time.Date(2024, time.June, 3, 14, 0, 0, 0, time.UTC), End: time.Date(2024, time.June, 3, 15, 0, 0, 0, time.UTC)},
+		{CalendarId: "kids", Title: "Camp", Start: time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), End: time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), AllDay: true},
+	}, nil)
+	var out bytes.Buffer
+
+	require.NoError(t, printEvents(ctx, &out, calSvcMock, []string{"family", "kids"}, from, to, tz))
+
+	assert.Equal(t, `2024-06-03 17:00–18:00  kids    (ללא כותרת)
+2024-06-04 all day      kids    Camp
+2024-06-04 20:00–21:30  family  Dinner
+2024-06-05–2024-06-06   family  Trip
+`, out.String())
+}
+
+func TestSampleEvent(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+
+	event := sampleEvent(time.Date(2024, time.June, 3, 17, 25, 0, 0, tz), tz)
+
+	assert.Equal(t, time.Date(2024, time.June, 3, 18, 0, 0, 0, tz), event.Start)
+	assert.Equal(t, time.Hour, event.End.Sub(event.Start))
+	assert.Equal(t, StatusCreated, event.Status)
+}
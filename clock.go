@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// Clock tells the current time. It lets tests control the time the engine
+// and the messages it renders see.
+type Clock interface {
+	Now() time.Time
+}
+
+func NewSystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
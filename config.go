@@ -1,30 +1,81 @@
 package main
 
 import (
-	"context"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
-	"github.com/sethvargo/go-envconfig"
 	log "github.com/sirupsen/logrus"
 	"io/fs"
+	"time"
 )
 
+// Config holds the bot's settings. Settings tagged with reload can change
+// while the daemon runs, the others take a restart.
 type Config struct {
-	CalendarId               string `env:"CALENDAR_ID"`
-	TelegramToken            string `env:"TELEGRAM_TOKEN"`
-	TelegramChatId           int64  `env:"TELEGRAM_CHAT_ID"`
-	LastCheckedFile          string `env:"LAST_CHECKED_FILE, default=last_checked.txt"`
-	GoogleServiceAccountFile string `env:"GOOGLE_SERVICE_ACCOUNT_FILE, default=google_service_account.json"`
+	CalendarId               string           `env:"CALENDAR_ID"`
+	ExtraCalendarIds         []string         `env:"EXTRA_CALENDAR_IDS"`
+	TelegramToken            string           `env:"TELEGRAM_TOKEN" secret:"true"`
+	TelegramChatId           int64            `env:"TELEGRAM_CHAT_ID" reload:"true"`
+	TelegramParseMode        string           `env:"TELEGRAM_PARSE_MODE, default=MarkdownV2"`
+	TelegramAPIEndpoint      string           `env:"TELEGRAM_API_ENDPOINT"`
+	WebhookURL               string           `env:"WEBHOOK_URL"`
+	WebhookSecret            string           `env:"WEBHOOK_SECRET" secret:"true"`
+	WebhookListenAddr        string           `env:"WEBHOOK_LISTEN_ADDR, default=:8080"`
+	MetricsListenAddr        string           `env:"METRICS_LISTEN_ADDR"`
+	AdminAPIListenAddr       string           `env:"ADMIN_API_LISTEN_ADDR"`
+	AdminAPIToken            string           `env:"ADMIN_API_TOKEN" secret:"true"`
+	SMTPHost                 string           `env:"SMTP_HOST"`
+	SMTPPort                 int              `env:"SMTP_PORT, default=587"`
+	SMTPUsername             string           `env:"SMTP_USERNAME"`
+	SMTPPassword             string           `env:"SMTP_PASSWORD" secret:"true"`
+	SMTPFrom                 string           `env:"SMTP_FROM"`
+	LastCheckedFile          string           `env:"LAST_CHECKED_FILE, default=last_checked.txt"`
+	GoogleServiceAccountFile string           `env:"GOOGLE_SERVICE_ACCOUNT_FILE, default=google_service_account.json"`
+	PeopleFile               string           `env:"PEOPLE_FILE"`
+	AllowedUsers             []int64          `env:"ALLOWED_USERS" reload:"true"`
+	AddressBookFile          string           `env:"ADDRESS_BOOK_FILE"`
+	EmailRecipientsFile      string           `env:"EMAIL_RECIPIENTS_FILE"`
+	NotifyAttendeesOnly      bool             `env:"NOTIFY_ATTENDEES_ONLY" reload:"true"`
+	SubscriptionsFile        string           `env:"SUBSCRIPTIONS_FILE, default=subscriptions.json"`
+	DaemonInterval           time.Duration    `env:"DAEMON_INTERVAL"`
+	OutboxFile               string           `env:"OUTBOX_FILE, default=outbox.json"`
+	PausedCalendarsFile      string           `env:"PAUSED_CALENDARS_FILE, default=paused_calendars.json"`
+	AuditLogFile             string           `env:"AUDIT_LOG_FILE"`
+	Timezone                 string           `env:"TIMEZONE, default=Asia/Jerusalem"`
+	QuietHours               string           `env:"QUIET_HOURS" reload:"true"`
+	ChatQuietHours           map[int64]string `env:"CHAT_QUIET_HOURS, delimiter=;, separator==" reload:"true"`
+	ShabbatLatitude          float64          `env:"SHABBAT_LATITUDE, default=31.778" reload:"true"`
+	ShabbatLongitude         float64          `env:"SHABBAT_LONGITUDE, default=35.235" reload:"true"`
+	CandleLightingOffset     time.Duration    `env:"CANDLE_LIGHTING_OFFSET, default=18m" reload:"true"`
+	HavdalahOffset           time.Duration    `env:"HAVDALAH_OFFSET, default=42m" reload:"true"`
+	HolidaysDiaspora         bool             `env:"HOLIDAYS_DIASPORA"`
+	ShowHebrewDate           bool             `env:"SHOW_HEBREW_DATE"`
+	ShowHolidays             bool             `env:"SHOW_HOLIDAYS"`
+	RelativeDates            bool             `env:"RELATIVE_DATES"`
+	CalendarLinks            bool             `env:"CALENDAR_LINKS"`
+	CalendarAttachments      bool             `env:"CALENDAR_ATTACHMENTS"`
+	FirstRunLookBack         time.Duration    `env:"FIRST_RUN_LOOK_BACK, default=1h" reload:"true"`
+	MaxLookBack              time.Duration    `env:"MAX_LOOK_BACK" reload:"true"`
+	CatchUpThreshold         int              `env:"CATCH_UP_THRESHOLD, default=10" reload:"true"`
+	DetectConflicts          bool             `env:"DETECT_CONFLICTS" reload:"true"`
+	PollsFile                string           `env:"POLLS_FILE, default=polls.json"`
+	PollQuorum               int              `env:"POLL_QUORUM, default=3"`
+	PollDuration             time.Duration    `env:"POLL_DURATION, default=24h"`
+	ModerationChatId         int64            `env:"MODERATION_CHAT_ID"`
+	ModerationRejectAction   string           `env:"MODERATION_REJECT_ACTION, default=delete"`
+	ModerationFile           string           `env:"MODERATION_FILE, default=moderation.json"`
+	AdminChatId              int64            `env:"ADMIN_CHAT_ID" reload:"true"`
+	ConfigWatchInterval      time.Duration    `env:"CONFIG_WATCH_INTERVAL, default=5s"`
+	WatchdogThreshold        time.Duration    `env:"WATCHDOG_THRESHOLD, default=30m" reload:"true"`
+	LogFormat                string           `env:"LOG_FORMAT, default=text"`
+	LogLevel                 string           `env:"LOG_LEVEL, default=info"`
+	DryRun                   bool             `env:"DRY_RUN"`
+	DryRunOutput             string           `env:"DRY_RUN_OUTPUT"`
 }
 
-func ReadConfigFromEnv(ctx context.Context) (Config, error) {
-	var cfg Config
-
-	if err := envconfig.Process(ctx, &cfg); err != nil {
-		return cfg, errors.Wrap(err, "error processing config")
-	}
-
-	return cfg, nil
+// Calendars returns the ids of all the calendars the bot watches, starting
+// with the primary one.
+func (c Config) Calendars() []string {
+	return append([]string{c.CalendarId}, c.ExtraCalendarIds...)
 }
 
 func LoadDotEnv() error {
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-envconfig"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read when CONFIG_FILE isn't set, if it exists.
+const defaultConfigFile = "config.yaml"
+
+// configField is a Config field, set by its environment variable or by the
+// same name in lower case in the config file. Secret fields are redacted when
+// printed, and reloadable ones can change without a restart.
+type configField struct {
+	index      int
+	env        string
+	key        string
+	secret     bool
+	reloadable bool
+}
+
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("env"), ",")
+		if name == "" {
+			continue
+		}
+		fields = append(fields, configField{
+			index:      i,
+			env:        name,
+			key:        strings.ToLower(name),
+			secret:     t.Field(i).Tag.Get("secret") == "true",
+			reloadable: t.Field(i).Tag.Get("reload") == "true",
+		})
+	}
+
+	return fields
+}
+
+// configSources tells where the value of each field came from, by its
+// environment variable: a line of the config file, or the environment.
+// Fields left with their defaults are missing.
+type configSources map[string]string
+
+// describe prefixes a problem with the field's key and where it was set.
+func (s configSources) describe(field configField, problem string) string {
+	switch source := s[field.env]; source {
+	case "":
+		return fmt.Sprintf("%s: %s", field.key, problem)
+	case "env":
+		return fmt.Sprintf("$%s: %s", field.env, problem)
+	default:
+		return fmt.Sprintf("%s: %s: %s", source, field.key, problem)
+	}
+}
+
+// ConfigErrors lists all the problems found in the configuration.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// ReadConfig resolves the configuration from, by increasing precedence, the
+// defaults, the config file and the environment, which LoadDotEnv extends
+// with the .env file. The config file is CONFIG_FILE, or config.yaml if it
+// exists.
+func ReadConfig(ctx context.Context, lookuper envconfig.Lookuper) (Config, configSources, error) {
+	var cfg Config
+	sources := configSources{}
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{Target: &cfg, Lookuper: envconfig.MapLookuper(nil)}); err != nil {
+		return cfg, nil, errors.Wrap(err, "error applying config defaults")
+	}
+
+	path, explicit := configFilePath(lookuper)
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decodeConfigFile(path, b, &cfg, sources); err != nil {
+			return cfg, nil, err
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return cfg, nil, errors.Wrap(err, "error reading config file")
+	}
+
+	if err := applyConfigEnv(ctx, lookuper, &cfg, sources); err != nil {
+		return cfg, nil, err
+	}
+
+	return cfg, sources, nil
+}
+
+// configFilePath returns the path of the config file, and whether it was set
+// explicitly.
+func configFilePath(lookuper envconfig.Lookuper) (string, bool) {
+	if path, ok := lookuper.Lookup("CONFIG_FILE"); ok {
+		return path, true
+	}
+	return defaultConfigFile, false
+}
+
+var yamlLinePattern = regexp.MustCompile(`^line \d+: `)
+
+// decodeConfigFile sets the fields found in the YAML document, rejecting keys
+// it doesn't know rather than silently ignoring typos.
+func decodeConfigFile(path string, b []byte, cfg *Config, sources configSources) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return errors.Wrapf(err, "error parsing config file %s", path)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return ConfigErrors{fmt.Sprintf("%s:%d: expected a mapping of settings", path, root.Line)}
+	}
+
+	byKey := make(map[string]configField)
+	for _, field := range configFields() {
+		byKey[field.key] = field
+	}
+
+	var problems ConfigErrors
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		where := fmt.Sprintf("%s:%d", path, key.Line)
+		field, ok := byKey[key.Value]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown key %q", where, key.Value))
+			continue
+		}
+		if _, dup := sources[field.env]; dup {
+			problems = append(problems, fmt.Sprintf("%s: duplicate key %q", where, key.Value))
+			continue
+		}
+
+		target := reflect.New(v.Field(field.index).Type())
+		if err := value.Decode(target.Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s: %s", path, value.Line, field.key, yamlErrorMessage(err)))
+			continue
+		}
+		v.Field(field.index).Set(target.Elem())
+		sources[field.env] = where
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// yamlErrorMessage drops the line numbers yaml adds, which are relative to
+// the decoded value.
+func yamlErrorMessage(err error) string {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err.Error()
+	}
+
+	messages := make([]string, 0, len(typeErr.Errors))
+	for _, message := range typeErr.Errors {
+		messages = append(messages, yamlLinePattern.ReplaceAllString(message, ""))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// applyConfigEnv overrides the fields whose environment variables are set.
+// The environment is decoded on its own, so that its defaults don't override
+// the config file.
+func applyConfigEnv(ctx context.Context, lookuper envconfig.Lookuper, cfg *Config, sources configSources) error {
+	var fromEnv Config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{Target: &fromEnv, Lookuper: lookuper}); err != nil {
+		return errors.Wrap(err, "error processing config")
+	}
+
+	dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(fromEnv)
+	for _, field := range configFields() {
+		if _, ok := lookuper.Lookup(field.env); ok {
+			dst.Field(field.index).Set(src.Field(field.index))
+			sources[field.env] = "env"
+		}
+	}
+
+	return nil
+}
+
+// ValidateConfig checks the configuration as a whole, reporting every problem
+// along with where the offending value was set.
+func ValidateConfig(cfg Config, sources configSources) error {
+	fields := make(map[string]configField)
+	for _, field := range configFields() {
+		fields[field.env] = field
+	}
+
+	var problems ConfigErrors
+	check := func(env string, ok bool, problem string, args ...any) {
+		if !ok {
+			problems = append(problems, sources.describe(fields[env], fmt.Sprintf(problem, args...)))
+		}
+	}
+	fileExists := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && !info.IsDir()
+	}
+
+	check("CALENDAR_ID", cfg.CalendarId != "", "required")
+	check("EXTRA_CALENDAR_IDS", !slices.Contains(cfg.ExtraCalendarIds, ""), "empty calendar id")
+	check("TELEGRAM_TOKEN", cfg.TelegramToken != "", "required")
+	// TELEGRAM_CHAT_ID is optional, when notifying only subscribers or
+	// email recipients
+	check("TELEGRAM_PARSE_MODE", cfg.TelegramParseMode == tgbotapi.ModeMarkdownV2 || cfg.TelegramParseMode == tgbotapi.ModeHTML,
+		"expected %s or %s, got %q", tgbotapi.ModeMarkdownV2, tgbotapi.ModeHTML, cfg.TelegramParseMode)
+	check("GOOGLE_SERVICE_ACCOUNT_FILE", fileExists(cfg.GoogleServiceAccountFile), "file %q not found", cfg.GoogleServiceAccountFile)
+	check("PEOPLE_FILE", cfg.PeopleFile == "" || fileExists(cfg.PeopleFile), "file %q not found", cfg.PeopleFile)
+	check("ADDRESS_BOOK_FILE", cfg.AddressBookFile == "" || fileExists(cfg.AddressBookFile), "file %q not found", cfg.AddressBookFile)
+	check("EMAIL_RECIPIENTS_FILE", cfg.EmailRecipientsFile == "" || fileExists(cfg.EmailRecipientsFile), "file %q not found", cfg.EmailRecipientsFile)
+	for chatId := range cfg.ChatQuietHours {
+		check("CHAT_QUIET_HOURS", chatId != 0, "invalid chat id %d", chatId)
+	}
+
+	_, err := time.LoadLocation(cfg.Timezone)
+	check("TIMEZONE", err == nil, "unknown timezone %q", cfg.Timezone)
+	check("DAEMON_INTERVAL", cfg.DaemonInterval >= 0, "must not be negative")
+	check("FIRST_RUN_LOOK_BACK", cfg.FirstRunLookBack >= 0, "must not be negative")
+	check("MAX_LOOK_BACK", cfg.MaxLookBack >= 0, "must not be negative")
+	check("WATCHDOG_THRESHOLD", cfg.WatchdogThreshold >= 0, "must not be negative")
+	check("LOG_FORMAT", cfg.LogFormat == logFormatText || cfg.LogFormat == logFormatJSON,
+		"expected %s or %s, got %q", logFormatText, logFormatJSON, cfg.LogFormat)
+	_, err = log.ParseLevel(cfg.LogLevel)
+	check("LOG_LEVEL", err == nil, "unknown log level %q", cfg.LogLevel)
+	check("POLL_QUORUM", cfg.PollQuorum > 0, "must be positive")
+	check("POLL_DURATION", cfg.PollDuration > 0, "must be positive")
+	check("MODERATION_REJECT_ACTION", cfg.ModerationRejectAction == rejectActionDelete || cfg.ModerationRejectAction == rejectActionDecline,
+		"expected %s or %s, got %q", rejectActionDelete, rejectActionDecline, cfg.ModerationRejectAction)
+
+	if cfg.WebhookURL != "" {
+		check("WEBHOOK_SECRET", webhookSecretPattern.MatchString(cfg.WebhookSecret), "must be 1-256 letters, digits, '_' or '-' when using a webhook")
+		check("WEBHOOK_URL", strings.HasPrefix(cfg.WebhookURL, "https://"), "must be an https url")
+		check("METRICS_LISTEN_ADDR", cfg.MetricsListenAddr != cfg.WebhookListenAddr, "must differ from WEBHOOK_LISTEN_ADDR")
+	}
+
+	if cfg.AdminAPIListenAddr != "" {
+		check("ADMIN_API_TOKEN", len(cfg.AdminAPIToken) >= minAdminAPITokenLength, "must be at least %d characters when using the admin API", minAdminAPITokenLength)
+		check("ADMIN_API_LISTEN_ADDR", cfg.AdminAPIListenAddr != cfg.MetricsListenAddr, "must differ from METRICS_LISTEN_ADDR")
+		check("ADMIN_API_LISTEN_ADDR", cfg.WebhookURL == "" || cfg.AdminAPIListenAddr != cfg.WebhookListenAddr, "must differ from WEBHOOK_LISTEN_ADDR")
+	}
+
+	if cfg.EmailRecipientsFile != "" {
+		check("SMTP_HOST", cfg.SMTPHost != "", "required when emailing recipients")
+		check("SMTP_PORT", cfg.SMTPPort > 0 && cfg.SMTPPort <= 65535, "invalid port %d", cfg.SMTPPort)
+		_, err := mail.ParseAddress(cfg.SMTPFrom)
+		check("SMTP_FROM", err == nil, "invalid address %q", cfg.SMTPFrom)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// RunConfigCheck prints the resolved configuration and the problems found in
+// it, returning the exit code: 0 if it's valid and 1 otherwise.
+func RunConfigCheck(ctx context.Context, lookuper envconfig.Lookuper, stdout, stderr io.Writer) int {
+	cfg, sources, err := ReadConfig(ctx, lookuper)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := PrintConfig(stdout, cfg, sources); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := ValidateConfig(cfg, sources); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintln(stderr, "config is valid")
+	return 0
+}
+
+// redacted replaces the values of secret fields when printing the config.
+const redacted = "[redacted]"
+
+// PrintConfig writes the resolved configuration in the config file format,
+// commenting where each value came from.
+func PrintConfig(w io.Writer, cfg Config, sources configSources) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	v := reflect.ValueOf(cfg)
+	for _, field := range configFields() {
+		var value any = v.Field(field.index).Interface()
+		switch typed := value.(type) {
+		case time.Duration:
+			value = typed.String()
+		case string:
+			if field.secret && typed != "" {
+				value = redacted
+			}
+		}
+
+		var valueNode yaml.Node
+		if err := valueNode.Encode(value); err != nil {
+			return errors.Wrapf(err, "error encoding %s", field.key)
+		}
+		switch source := sources[field.env]; source {
+		case "":
+			valueNode.LineComment = "default"
+		case "env":
+			valueNode.LineComment = "$" + field.env
+		default:
+			valueNode.LineComment = source
+		}
+
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.key}, &valueNode)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return errors.Wrap(err, "error printing config")
+	}
+	return enc.Close()
+}
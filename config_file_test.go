@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestReadConfigLayers(t *testing.T) {
+	path := writeConfigFile(t, `# family bot
+calendar_id: family
+extra_calendar_ids:
+  - kids
+  - work
+telegram_chat_id: -100123
+chat_quiet_hours:
+  42: "22:00-07:00"
+relative_dates: true
+daemon_interval: 5m
+`)
+	lookuper := envconfig.MapLookuper(map[string]string{
+		"CONFIG_FILE":      path,
+		"TELEGRAM_CHAT_ID": "-100456",
+		"TELEGRAM_TOKEN":   "token",
+	})
+
+	cfg, sources, err := ReadConfig(context.Background(), lookuper)
+
+	require.NoError(t, err)
+	assert.Equal(t, "family", cfg.CalendarId)
+	assert.Equal(t, []string{"kids", "work"}, cfg.ExtraCalendarIds)
+	assert.Equal(t, map[int64]string{42: "22:00-07:00"}, cfg.ChatQuietHours)
+	assert.Equal(t, 5*time.Minute, cfg.DaemonInterval)
+	assert.True(t, cfg.RelativeDates, "the file overrides defaults")
+	assert.Equal(t, int64(-100456), cfg.TelegramChatId, "the environment overrides the file")
+	assert.Equal(t, "token", cfg.TelegramToken)
+	assert.Equal(t, "outbox.json", cfg.OutboxFile)
+	assert.Empty(t, cfg.AuditLogFile, "the audit log is off by default")
+	assert.Equal(t, configSources{
+		"CALENDAR_ID":        path + ":2",
+		"EXTRA_CALENDAR_IDS": path + ":3",
+		"TELEGRAM_CHAT_ID":   "env",
+		"CHAT_QUIET_HOURS":   path + ":7",
+		"RELATIVE_DATES":     path + ":9",
+		"DAEMON_INTERVAL":    path + ":10",
+		"TELEGRAM_TOKEN":     "env",
+	}, sources)
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	path := writeConfigFile(t, `calendar_id: family
+calendar: kids
+telegram_chat_id: abc
+daemon_interval: soon
+calendar_id: work
+`)
+
+	_, _, err := ReadConfig(context.Background(), envconfig.MapLookuper(map[string]string{"CONFIG_FILE": path}))
+
+	var problems ConfigErrors
+	require.ErrorAs(t, err, &problems)
+	assert.Equal(t, ConfigErrors{
+		path + `:2: unknown key "calendar"`,
+		path + ":3: telegram_chat_id: cannot unmarshal !!str `abc` into int64",
+		path + ":4: daemon_interval: cannot unmarshal !!str `soon` into time.Duration",
+		path + `:5: duplicate key "calendar_id"`,
+	}, problems)
+}
+
+func TestReadConfigMissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "config.yaml")
+
+	_, _, err := ReadConfig(context.Background(), envconfig.MapLookuper(map[string]string{"CONFIG_FILE": missing}))
+	assert.Error(t, err, "a file given explicitly must exist")
+
+	cfg, _, err := ReadConfig(context.Background(), envconfig.MapLookuper(map[string]string{"CALENDAR_ID": "family"}))
+	require.NoError(t, err, "the default file is optional")
+	assert.Equal(t, "family", cfg.CalendarId)
+}
+
+func TestValidateConfig(t *testing.T) {
+	credentials := writeConfigFile(t, "{}")
+	emailRecipients := writeConfigFile(t, "[]")
+	path := writeConfigFile(t, `calendar_id: family
+telegram_chat_id: 42
+google_service_account_file: /nonexistent/credentials.json
+chat_quiet_hours:
+  0: "22:00-07:00"
+`)
+	lookuper := envconfig.MapLookuper(map[string]string{
+		"CONFIG_FILE":           path,
+		"TELEGRAM_PARSE_MODE":   "Markdown",
+		"LOG_LEVEL":             "loud",
+		"EMAIL_RECIPIENTS_FILE": emailRecipients,
+		"SMTP_FROM":             "bot",
+	})
+	cfg, sources, err := ReadConfig(context.Background(), lookuper)
+	require.NoError(t, err)
+
+	err = ValidateConfig(cfg, sources)
+
+	var problems ConfigErrors
+	require.ErrorAs(t, err, &problems)
+	assert.Equal(t, ConfigErrors{
+		"telegram_token: required",
+		`$TELEGRAM_PARSE_MODE: expected MarkdownV2 or HTML, got "Markdown"`,
+		path + `:3: google_service_account_file: file "/nonexistent/credentials.json" not found`,
+		path + ":4: chat_quiet_hours: invalid chat id 0",
+		`$LOG_LEVEL: unknown log level "loud"`,
+		"smtp_host: required when emailing recipients",
+		`$SMTP_FROM: invalid address "bot"`,
+	}, problems)
+
+	cfg.TelegramToken = "token"
+	cfg.TelegramParseMode = "HTML"
+	cfg.GoogleServiceAccountFile = credentials
+	cfg.ChatQuietHours = nil
+	cfg.LogLevel = "debug"
+	cfg.SMTPHost = "smtp.example.com"
+	cfg.SMTPFrom = "Calendar Bot <bot@example.com>"
+	assert.NoError(t, ValidateConfig(cfg, sources))
+
+	// without a group chat, only subscribers and email recipients are
+	// notified
+	cfg.TelegramChatId = 0
+	assert.NoError(t, ValidateConfig(cfg, sources))
+}
+
+func TestRunConfigCheck(t *testing.T) {
+	credentials := writeConfigFile(t, "{}")
+	path := writeConfigFile(t, `calendar_id: family
+telegram_chat_id: 42
+telegram_token: "123:very-secret"
+google_service_account_file: `+credentials+`
+daemon_interval: 5m
+`)
+	lookuper := envconfig.MapLookuper(map[string]string{
+		"CONFIG_FILE":    path,
+		"WEBHOOK_URL":    "https://bot.example.com/hook",
+		"WEBHOOK_SECRET": "also-secret",
+	})
+	var stdout, stderr bytes.Buffer
+
+	code := RunConfigCheck(context.Background(), lookuper, &stdout, &stderr)
+
+	assert.Equal(t, 0, code, stderr.String())
+	out := stdout.String()
+	assert.NotContains(t, out, "very-secret")
+	assert.NotContains(t, out, "also-secret")
+	assert.Contains(t, out, "telegram_token: '[redacted]' # "+path+":3\n")
+	assert.Contains(t, out, "webhook_secret: '[redacted]' # $WEBHOOK_SECRET\n")
+	assert.Contains(t, out, "daemon_interval: 5m0s # "+path+":5\n")
+	assert.Contains(t, out, "outbox_file: outbox.json # default\n")
+	assert.Equal(t, "config is valid\n", stderr.String())
+
+	stdout.Reset()
+	stderr.Reset()
+	code = RunConfigCheck(context.Background(), envconfig.MapLookuper(map[string]string{"CONFIG_FILE": path, "WEBHOOK_URL": "https://bot.example.com/hook"}), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "webhook_secret: must be 1-256 letters")
+}
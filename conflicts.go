@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// conflictDetector finds the events that overlap a given event on any of the
+// watched calendars. All day events and transparent events, which don't
+// block time, neither conflict nor are conflicted with.
+type conflictDetector struct {
+	calSvc    CalendarService
+	calendars []string
+}
+
+func newConflictDetector(cfg Config, calSvc CalendarService) conflictDetector {
+	return conflictDetector{calSvc: calSvc, calendars: cfg.Calendars()}
+}
+
+func (d conflictDetector) Detect(ctx context.Context, event CalendarEvent) ([]CalendarEvent, error) {
+	if !blocksTime(event) || event.Status == StatusCanceled {
+		return nil, nil
+	}
+
+	var conflicts []CalendarEvent
+	for _, calendarId := range d.calendars {
+		events, err := d.calSvc.GetEvents(ctx, calendarId, event.Start, event.End)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting events of %s", calendarId)
+		}
+
+		for _, other := range events {
+			// an event shared between the calendars shows on each of them
+			isSame := func(e CalendarEvent) bool { return e.Id != "" && e.Id == other.Id }
+			if isSame(event) || slices.ContainsFunc(conflicts, isSame) {
+				continue
+			}
+			if blocksTime(other) && other.Status != StatusCanceled && overlaps(event, other) {
+				conflicts = append(conflicts, other)
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+func blocksTime(event CalendarEvent) bool {
+	return !event.AllDay && !event.Transparent && event.End.After(event.Start)
+}
+
+// overlaps reports whether the events overlap. Back to back events don't.
+func overlaps(a, b CalendarEvent) bool {
+	return a.Start.Before(b.End) && b.Start.Before(a.End)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictDetector(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	event := CalendarEvent{Id: "new", CalendarId: "kids", Title: "Football", Start: start, End: end, Status: StatusCreated}
+
+	overlapping := CalendarEvent{Id: "swim", CalendarId: "kids", Title: "Swimming", Start: start.Add(30 * time.Minute), End: end.Add(30 * time.Minute)}
+	shared := CalendarEvent{Id: "dentist", CalendarId: "family", Title: "Dentist", Start: start.Add(-time.Hour), End: start.Add(15 * time.Minute)}
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetEvents", ctx, "family", start, end).Return([]CalendarEvent{
+		shared,
+		// back to back
+		{Id: "lunch", Title: "Lunch", Start: start.Add(-time.Hour), End: start},
+		{Id: "holiday", Title: "Holiday", Start: start, End: start, AllDay: true},
+		{Id: "reminder", Title: "Reminder", Start: start, End: end, Transparent: true},
+		{Id: "canceled", Title: "Canceled", Start: start, End: end, Status: StatusCanceled},
+	}, nil)
+	calSvcMock.On("GetEvents", ctx, "kids", start, end).Return([]CalendarEvent{
+		event,
+		overlapping,
+		// the same event, as seen on the other calendar
+		{Id: "dentist", CalendarId: "kids", Title: "Dentist", Start: shared.Start, End: shared.End},
+	}, nil)
+	detector := conflictDetector{calSvc: calSvcMock, calendars: []string{"family", "kids"}}
+
+	// SUT
+	conflicts, err := detector.Detect(ctx, event)
+	require.NoError(t, err)
+	assert.Equal(t, []CalendarEvent{shared, overlapping}, conflicts)
+
+	// events that don't block time aren't checked at all
+	for _, ignored := range []CalendarEvent{
+		{Id: "all-day", Start: start, End: start, AllDay: true, Status: StatusCreated},
+		{Id: "free", Start: start, End: end, Transparent: true, Status: StatusUpdated},
+		{Id: "canceled", Start: start, End: end, Status: StatusCanceled},
+	} {
+		conflicts, err := detector.Detect(ctx, ignored)
+		assert.NoError(t, err)
+		assert.Empty(t, conflicts, ignored.Id)
+	}
+	calSvcMock.AssertNumberOfCalls(t, "GetEvents", 2)
+}
+
+func TestConflictDetectorError(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetEvents", ctx, "family", start, start.Add(time.Hour)).Return([]CalendarEvent{}, errors.New("quota exceeded"))
+	detector := conflictDetector{calSvc: calSvcMock, calendars: []string{"family"}}
+
+	// SUT
+	_, err := detector.Detect(ctx, CalendarEvent{Start: start, End: start.Add(time.Hour)})
+
+	assert.ErrorContains(t, err, "quota exceeded")
+}
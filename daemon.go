@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpdateListener hands the updates sent to the bot to the handler until the
+// context is done.
+type UpdateListener interface {
+	ListenUpdates(ctx context.Context, handler UpdateHandler) error
+}
+
+// Daemon keeps the bot running: it runs the engine periodically and serves
+// the commands users send to the bot in between.
+type Daemon struct {
+	cfg    Config
+	engine *Engine
+	// updates delivers the updates sent to the bot, by long polling or
+	// through a webhook.
+	updates    UpdateListener
+	dispatcher *Dispatcher
+	// reloader, if set, hands over configuration changes to apply between
+	// cycles.
+	reloader *configReloader
+	// jobs run after the engine on every cycle, e.g. closing expired polls.
+	jobs []func(ctx context.Context) error
+	// metrics and health are served on MetricsListenAddr, if set.
+	metrics *Metrics
+	health  *Health
+	// adminAPI is served on AdminAPIListenAddr, if set.
+	adminAPI *AdminAPI
+	// triggers runs a cycle without waiting for the next tick.
+	triggers <-chan struct{}
+}
+
+func (d *Daemon) Run(ctx context.Context) error {
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- d.updates.ListenUpdates(ctx, d.dispatcher.HandleUpdate)
+	}()
+
+	serveErr := make(chan error, 2)
+	if d.cfg.MetricsListenAddr != "" {
+		go func() {
+			if err := ServeMonitoring(ctx, d.cfg.MetricsListenAddr, d.metrics, d.health); err != nil {
+				serveErr <- errors.Wrap(err, "error serving metrics and health")
+			}
+		}()
+	}
+	if d.cfg.AdminAPIListenAddr != "" {
+		go func() {
+			if err := d.adminAPI.ListenAndServe(ctx); err != nil {
+				serveErr <- errors.Wrap(err, "error serving admin API")
+			}
+		}()
+	}
+
+	var reloads <-chan Config
+	if d.reloader != nil {
+		reloads = d.reloader.reloads
+		go d.reloader.Watch(ctx)
+	}
+
+	ticker := time.NewTicker(d.cfg.DaemonInterval)
+	defer ticker.Stop()
+
+	for {
+		// A failing cycle is retried on the next tick rather than stopping
+		// the bot from answering users.
+		if err := d.engine.Work(ctx); err != nil {
+			log.WithError(err).Error("engine failed")
+		}
+		for _, job := range d.jobs {
+			if err := job(ctx); err != nil {
+				log.WithError(err).Error("job failed")
+			}
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return <-listenErr
+			case err := <-listenErr:
+				return errors.Wrap(err, "error listening to telegram updates")
+			case err := <-serveErr:
+				return err
+			case cfg := <-reloads:
+				d.reloader.Apply(d.engine, cfg)
+			case <-ticker.C:
+				break wait
+			case <-d.triggers:
+				log.Info("running a cycle on demand")
+				break wait
+			}
+		}
+	}
+}
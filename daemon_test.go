@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonRunsEngineUntilCanceled(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		CalendarId:        "family",
+		LastCheckedFile:   filepath.Join(dir, "last_checked.txt"),
+		SubscriptionsFile: filepath.Join(dir, "subscriptions.json"),
+		OutboxFile:        filepath.Join(dir, "outbox.json"),
+		DaemonInterval:    10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calSvcMock := &CalendarServiceMock{}
+	cycles := 0
+	calSvcMock.On("GetRecentEvents", mock.Anything, "family", mock.Anything).Return([]CalendarEvent{}, nil).Run(func(mock.Arguments) {
+		if cycles++; cycles == 3 {
+			cancel()
+		}
+	})
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("ListenUpdates", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	})
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		telcli:      telCliMock,
+		lastChkdDao: NewLastCheckedDao(cfg),
+		subsDao:     NewSubscriptionDao(cfg),
+		outboxDao:   NewOutboxDao(cfg),
+		clock:       NewSystemClock(),
+	}
+	jobRuns := 0
+	job := func(context.Context) error {
+		jobRuns++
+		return errors.New("a failing job doesn't stop the daemon")
+	}
+	daemon := Daemon{cfg: cfg, engine: &engine, updates: telCliMock, dispatcher: NewDispatcher(), jobs: []func(ctx context.Context) error{job}}
+
+	// SUT
+	require.NoError(t, daemon.Run(ctx))
+
+	assert.Equal(t, 3, cycles)
+	assert.Equal(t, 3, jobRuns)
+	telCliMock.AssertNumberOfCalls(t, "ListenUpdates", 1)
+}
+
+func TestDaemonRunsTriggeredCycle(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		CalendarId:        "family",
+		LastCheckedFile:   filepath.Join(dir, "last_checked.txt"),
+		SubscriptionsFile: filepath.Join(dir, "subscriptions.json"),
+		OutboxFile:        filepath.Join(dir, "outbox.json"),
+		DaemonInterval:    time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	triggers := make(chan struct{}, 1)
+	calSvcMock := &CalendarServiceMock{}
+	cycles := 0
+	calSvcMock.On("GetRecentEvents", mock.Anything, "family", mock.Anything).Return([]CalendarEvent{}, nil).Run(func(mock.Arguments) {
+		if cycles++; cycles == 1 {
+			triggers <- struct{}{}
+		} else {
+			cancel()
+		}
+	})
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("ListenUpdates", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	})
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		telcli:      telCliMock,
+		lastChkdDao: NewLastCheckedDao(cfg),
+		subsDao:     NewSubscriptionDao(cfg),
+		outboxDao:   NewOutboxDao(cfg),
+		clock:       NewSystemClock(),
+	}
+	daemon := Daemon{cfg: cfg, engine: &engine, updates: telCliMock, dispatcher: NewDispatcher(), triggers: triggers}
+
+	// SUT
+	require.NoError(t, daemon.Run(ctx))
+
+	assert.Equal(t, 2, cycles, "the trigger doesn't wait for the hourly tick")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// CommandHandler handles a bot command such as /start.
+type CommandHandler func(ctx context.Context, msg *tgbotapi.Message) error
+
+// CallbackHandler handles a pressed inline button.
+type CallbackHandler func(ctx context.Context, query *tgbotapi.CallbackQuery) error
+
+// PollAnswerHandler handles a user's vote in a poll sent by the bot.
+type PollAnswerHandler func(ctx context.Context, answer *tgbotapi.PollAnswer) error
+
+// Dispatcher routes Telegram updates to the handlers registered for them.
+// Handlers must all be registered before updates start flowing.
+type Dispatcher struct {
+	commands  map[string]CommandHandler
+	callbacks map[string]CallbackHandler
+	polls     PollAnswerHandler
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		commands:  make(map[string]CommandHandler),
+		callbacks: make(map[string]CallbackHandler),
+	}
+}
+
+func (d *Dispatcher) HandleCommand(command string, handler CommandHandler) {
+	d.commands[command] = handler
+}
+
+// HandleCallback registers a handler for the buttons whose data starts with
+// the prefix, followed by a colon, e.g. "free:...".
+func (d *Dispatcher) HandleCallback(prefix string, handler CallbackHandler) {
+	d.callbacks[prefix] = handler
+}
+
+// HandlePollAnswer registers the handler for votes in polls.
+func (d *Dispatcher) HandlePollAnswer(handler PollAnswerHandler) {
+	d.polls = handler
+}
+
+func (d *Dispatcher) HandleUpdate(ctx context.Context, update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		d.handleCallback(ctx, update.CallbackQuery)
+		return
+	}
+
+	if update.PollAnswer != nil {
+		if d.polls == nil {
+			return
+		}
+		if err := d.polls(ctx, update.PollAnswer); err != nil {
+			log.WithError(err).WithField("pollId", update.PollAnswer.PollID).Error("error handling poll answer")
+		}
+		return
+	}
+
+	msg := update.Message
+	if msg == nil || !msg.IsCommand() {
+		return
+	}
+
+	handler, ok := d.commands[msg.Command()]
+	if !ok {
+		log.WithField("command", msg.Command()).Info("ignoring unknown command")
+		return
+	}
+
+	if err := handler(ctx, msg); err != nil {
+		log.WithError(err).WithField("command", msg.Command()).Error("error handling command")
+	}
+}
+
+func (d *Dispatcher) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	prefix, _, _ := strings.Cut(query.Data, ":")
+	handler, ok := d.callbacks[prefix]
+	if !ok {
+		log.WithField("data", query.Data).Info("ignoring unknown callback")
+		return
+	}
+
+	if err := handler(ctx, query); err != nil {
+		log.WithError(err).WithField("data", query.Data).Error("error handling callback")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// dryRunTelegram renders messages exactly as they would be sent, but writes
+// them out instead, for trying settings against the real calendars without
+// notifying anyone.
+type dryRunTelegram struct {
+	tel *telegram
+	w   io.Writer
+
+	mu sync.Mutex
+	// messages numbers the messages, standing for the ids Telegram gives
+	// them.
+	messages int
+}
+
+func NewDryRunTelegram(cfg Config, people peopleDirectory, places addressBook, clock Clock, w io.Writer) Telegram {
+	return &dryRunTelegram{
+		tel: &telegram{cfg: cfg, people: people, places: places, clock: clock},
+		w:   w,
+	}
+}
+
+func (d *dryRunTelegram) Init() error {
+	return d.tel.initRendering()
+}
+
+func (d *dryRunTelegram) NotifyEvent(chatId int64, event CalendarEvent) (int, error) {
+	body, err := d.tel.renderer.prepareMessageBody(event)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, attachment := range d.tel.attachments(chatId, event) {
+		switch a := attachment.(type) {
+		case tgbotapi.DocumentConfig:
+			if file, ok := a.File.(tgbotapi.FileBytes); ok {
+				body += fmt.Sprintf("\n📎 %s (%d bytes)", file.Name, len(file.Bytes))
+			}
+		case tgbotapi.VenueConfig:
+			body += fmt.Sprintf("\n📍 %s, %s (%f, %f)", a.Title, a.Address, a.Latitude, a.Longitude)
+		}
+	}
+
+	return d.send(chatId, "event", body)
+}
+
+func (d *dryRunTelegram) NotifyDigest(chatId int64, events []CalendarEvent) (int, error) {
+	body, err := d.tel.renderer.prepareDigestBody(events)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.send(chatId, "digest", body)
+}
+
+func (d *dryRunTelegram) NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error) {
+	body, err := d.tel.renderer.prepareCatchUpBody(since, events)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.send(chatId, "catch-up", body)
+}
+
+func (d *dryRunTelegram) SendText(chatId int64, text string) error {
+	_, err := d.send(chatId, "text", text)
+	return err
+}
+
+func (d *dryRunTelegram) SendChoices(chatId int64, text string, choices []Choice) error {
+	for _, choice := range choices {
+		text += fmt.Sprintf("\n[%s]", choice.Text)
+	}
+
+	_, err := d.send(chatId, "choices", text)
+	return err
+}
+
+func (d *dryRunTelegram) RemoveChoices(chatId int64, messageId int) error {
+	return d.write(chatId, "remove choices", fmt.Sprintf("message %d", messageId))
+}
+
+func (d *dryRunTelegram) AnswerCallback(callbackId string, text string) error {
+	return d.write(0, "callback answer", text)
+}
+
+func (d *dryRunTelegram) SendPoll(chatId int64, question string, options []string) (string, int, error) {
+	body := question
+	for _, option := range options {
+		body += "\n☐ " + option
+	}
+
+	messageId, err := d.send(chatId, "poll", body)
+	return fmt.Sprintf("dry-run-%d", messageId), messageId, err
+}
+
+func (d *dryRunTelegram) StopPoll(chatId int64, messageId int) error {
+	return d.write(chatId, "stop poll", fmt.Sprintf("message %d", messageId))
+}
+
+// ListenUpdates receives nothing, as the bot isn't connected to Telegram.
+func (d *dryRunTelegram) ListenUpdates(ctx context.Context, _ UpdateHandler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (d *dryRunTelegram) SetWebhook(string, string) error {
+	return nil
+}
+
+func (d *dryRunTelegram) DeleteWebhook() error {
+	return nil
+}
+
+// send writes a message, and returns the number standing for its id.
+func (d *dryRunTelegram) send(chatId int64, kind string, body string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.messages++
+	return d.messages, d.writeLocked(chatId, kind, body)
+}
+
+func (d *dryRunTelegram) write(chatId int64, kind string, body string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.writeLocked(chatId, kind, body)
+}
+
+func (d *dryRunTelegram) writeLocked(chatId int64, kind string, body string) error {
+	_, err := fmt.Fprintf(d.w, "=== chat %d: %s (%s) ===\n%s\n\n", chatId, kind, d.tel.renderer.markup.ParseMode(), body)
+	return err
+}
+
+// dryRunMailer renders emails exactly as they would be sent, but writes their
+// plain text out instead.
+type dryRunMailer struct {
+	mailer *smtpMailer
+	w      io.Writer
+}
+
+func NewDryRunMailer(cfg Config, people peopleDirectory, clock Clock, w io.Writer) Mailer {
+	return &dryRunMailer{
+		mailer: &smtpMailer{cfg: cfg, people: people, clock: clock},
+		w:      w,
+	}
+}
+
+func (d *dryRunMailer) Init() error {
+	return d.mailer.Init()
+}
+
+func (d *dryRunMailer) NotifyEvent(to EmailRecipient, event CalendarEvent) error {
+	e, err := d.mailer.renderEvent(to, event)
+	if err != nil {
+		return err
+	}
+
+	return d.write("event", e)
+}
+
+func (d *dryRunMailer) NotifyDigest(to EmailRecipient, events []CalendarEvent) error {
+	e, err := d.mailer.renderDigest(to, events)
+	if err != nil {
+		return err
+	}
+
+	return d.write("digest", e)
+}
+
+func (d *dryRunMailer) write(kind string, e email) error {
+	body := e.text
+	if e.invitation != nil {
+		body += fmt.Sprintf("\n📎 invite.ics (%s)", e.method)
+	}
+
+	_, err := fmt.Fprintf(d.w, "=== email to %s: %s ===\nSubject: %s\n\n%s\n\n", e.to.address(), kind, e.subject, body)
+	return err
+}
+
+// dryRunLastCheckedDao reads the last check from the real state, but keeps
+// changes to it in memory.
+type dryRunLastCheckedDao struct {
+	dao LastCheckedDao
+
+	mu      sync.Mutex
+	changed bool
+	t       time.Time
+}
+
+func (d *dryRunLastCheckedDao) GetLastChecked() (time.Time, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changed {
+		return d.t, !d.t.IsZero(), nil
+	}
+
+	return d.dao.GetLastChecked()
+}
+
+func (d *dryRunLastCheckedDao) SetLastChecked(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.changed, d.t = true, t
+	return nil
+}
+
+func (d *dryRunLastCheckedDao) ClearLastChecked() error {
+	return d.SetLastChecked(time.Time{})
+}
+
+// dryRunOutboxDao starts from the real outbox, but keeps changes to it in
+// memory.
+type dryRunOutboxDao struct {
+	dao OutboxDao
+
+	mu      sync.Mutex
+	loaded  bool
+	entries []OutboxEntry
+}
+
+func (d *dryRunOutboxDao) load() error {
+	if d.loaded {
+		return nil
+	}
+
+	entries, err := d.dao.GetOutbox()
+	if err != nil {
+		return err
+	}
+	d.entries, d.loaded = entries, true
+	return nil
+}
+
+func (d *dryRunOutboxDao) GetOutbox() ([]OutboxEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	return append([]OutboxEntry(nil), d.entries...), nil
+}
+
+func (d *dryRunOutboxDao) Enqueue(entry OutboxEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	d.entries = append(d.entries, entry)
+	return nil
+}
+
+func (d *dryRunOutboxDao) RemoveChat(chatId int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	d.remove(func(entry OutboxEntry) bool { return entry.Email == "" && entry.ChatId == chatId })
+	return nil
+}
+
+func (d *dryRunOutboxDao) RemoveEmail(email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	d.remove(func(entry OutboxEntry) bool { return entry.Email == email })
+	return nil
+}
+
+func (d *dryRunOutboxDao) remove(match func(entry OutboxEntry) bool) {
+	kept := d.entries[:0:0]
+	for _, entry := range d.entries {
+		if !match(entry) {
+			kept = append(kept, entry)
+		}
+	}
+	d.entries = kept
+}
+
+// dryRunModerationDao reads the real moderation state, but keeps new requests
+// and decisions in memory.
+type dryRunModerationDao struct {
+	dao   ModerationDao
+	clock Clock
+
+	mu        sync.Mutex
+	nextId    int
+	requests  map[int]ModerationRequest
+	decided   map[int]bool
+	decisions []ModerationDecision
+}
+
+func (d *dryRunModerationDao) AddRequest(event CalendarEvent) (ModerationRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.requests == nil {
+		d.requests = make(map[int]ModerationRequest)
+	}
+
+	// ids are negative so they can't collide with the real ones
+	d.nextId--
+	req := ModerationRequest{Id: d.nextId, Event: event, RequestedAt: d.clock.Now()}
+	d.requests[req.Id] = req
+	return req, nil
+}
+
+func (d *dryRunModerationDao) GetRequest(id int) (ModerationRequest, bool, error) {
+	d.mu.Lock()
+	req, ok := d.requests[id]
+	decided := d.decided[id]
+	d.mu.Unlock()
+	if ok || decided {
+		return req, ok, nil
+	}
+
+	return d.dao.GetRequest(id)
+}
+
+func (d *dryRunModerationDao) IsPending(calendarId string, eventId string) (bool, error) {
+	d.mu.Lock()
+	for _, req := range d.requests {
+		if !d.decided[req.Id] && req.Event.CalendarId == calendarId && req.Event.Id == eventId {
+			d.mu.Unlock()
+			return true, nil
+		}
+	}
+	d.mu.Unlock()
+
+	return d.dao.IsPending(calendarId, eventId)
+}
+
+func (d *dryRunModerationDao) Decide(id int, approved bool, by string) (ModerationDecision, bool, error) {
+	req, ok, err := d.GetRequest(id)
+	if err != nil || !ok {
+		return ModerationDecision{}, false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.decided == nil {
+		d.decided = make(map[int]bool)
+	}
+	if d.decided[id] {
+		return ModerationDecision{}, false, nil
+	}
+	delete(d.requests, id)
+	d.decided[id] = true
+	decision := ModerationDecision{Request: req, Approved: approved, By: by, DecidedAt: d.clock.Now()}
+	d.decisions = append(d.decisions, decision)
+	return decision, true, nil
+}
+
+func (d *dryRunModerationDao) GetDecisions() ([]ModerationDecision, error) {
+	decisions, err := d.dao.GetDecisions()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append(decisions, d.decisions...), nil
+}
+
+// dryRunAuditDao reads the real audit log, but keeps the records of the dry
+// run in memory.
+type dryRunAuditDao struct {
+	dao AuditDao
+
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (d *dryRunAuditDao) Append(records []AuditRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records = append(d.records, records...)
+	return nil
+}
+
+func (d *dryRunAuditDao) Query(q AuditQuery) ([]AuditRecord, error) {
+	records, err := d.dao.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, record := range d.records {
+		if q.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDryRunTelegram(t *testing.T, cfg Config, places addressBook, clock Clock) (Telegram, *bytes.Buffer) {
+	cfg.TelegramParseMode = "HTML"
+	cfg.Timezone = "Asia/Jerusalem"
+	var out bytes.Buffer
+	telcli := NewDryRunTelegram(cfg, peopleDirectory{}, places, clock, &out)
+	require.NoError(t, telcli.Init())
+	return telcli, &out
+}
+
+func TestDryRunNotifyEvent(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	places := newAddressBook([]Place{{Name: "Grandma", Address: "Herzl 1", Latitude: 31.5, Longitude: 34.75}})
+	telcli, out := newTestDryRunTelegram(t, Config{CalendarAttachments: true}, places, clock)
+	event := CalendarEvent{
+		Id:       "abc",
+		Title:    "Dinner <at> grandma's",
+		Start:    clock.Now().Add(24 * time.Hour),
+		End:      clock.Now().Add(26 * time.Hour),
+		Location: "Grandma",
+	}
+
+	_, err := telcli.NotifyEvent(42, event)
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "=== chat 42: event (HTML) ===")
+	assert.Contains(t, out.String(), "Dinner &lt;at&gt; grandma's")
+	assert.Contains(t, out.String(), "📎 event.ics (")
+	assert.Contains(t, out.String(), "📍 Grandma, Herzl 1 (31.500000, 34.750000)")
+}
+
+func TestDryRunMailer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	var out bytes.Buffer
+	mailer := NewDryRunMailer(Config{SMTPFrom: "bot@example.com", Timezone: "Asia/Jerusalem"}, peopleDirectory{}, clock, &out)
+	require.NoError(t, mailer.Init())
+	event := CalendarEvent{
+		Id:     "abc",
+		Title:  "Dinner",
+		Start:  clock.Now().Add(24 * time.Hour),
+		End:    clock.Now().Add(26 * time.Hour),
+		Status: StatusCanceled,
+	}
+
+	require.NoError(t, mailer.NotifyEvent(EmailRecipient{Email: "grandma@example.com", Name: "Grandma"}, event))
+
+	assert.Contains(t, out.String(), "=== email to \"Grandma\" <grandma@example.com>: event ===\nSubject: ️🆇 בוטל: Dinner\n")
+	assert.Contains(t, out.String(), "📎 invite.ics (CANCEL)")
+}
+
+func TestDryRunSendPoll(t *testing.T) {
+	telcli, out := newTestDryRunTelegram(t, Config{}, addressBook{}, NewSystemClock())
+
+	pollId, messageId, err := telcli.SendPoll(42, "When?", []string{"Sunday", "Monday"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "dry-run-1", pollId)
+	assert.Equal(t, 1, messageId)
+	assert.Contains(t, out.String(), "When?\n☐ Sunday\n☐ Monday")
+}
+
+func TestDryRunKeepsState(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := Config{
+		CalendarId:       "family",
+		TelegramChatId:   42,
+		FirstRunLookBack: time.Hour,
+		LastCheckedFile:  filepath.Join(dir, "last_checked.txt"),
+		OutboxFile:       filepath.Join(dir, "outbox.json"),
+		ModerationFile:   filepath.Join(dir, "moderation.json"),
+	}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	lastChecked := clock.Now().Add(-time.Minute)
+	require.NoError(t, NewLastCheckedDao(cfg).SetLastChecked(lastChecked))
+	stored, err := os.ReadFile(cfg.LastCheckedFile)
+	require.NoError(t, err)
+
+	event := CalendarEvent{
+		Title:   "Dentist",
+		Start:   clock.Now().Add(24 * time.Hour),
+		End:     clock.Now().Add(25 * time.Hour),
+		Creator: "someone else",
+	}
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetRecentEvents", ctx, "family", lastChecked).Return([]CalendarEvent{event}, nil)
+	telcli, out := newTestDryRunTelegram(t, cfg, addressBook{}, clock)
+	lastChkdDao := &dryRunLastCheckedDao{dao: NewLastCheckedDao(cfg)}
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		telcli:      telcli,
+		lastChkdDao: lastChkdDao,
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   &dryRunOutboxDao{dao: NewOutboxDao(cfg)},
+		modDao:      &dryRunModerationDao{dao: NewModerationDao(cfg, clock), clock: clock},
+		clock:       clock,
+	}
+
+	require.NoError(t, engine.Work(ctx))
+
+	assert.Contains(t, out.String(), "=== chat 42: event (HTML) ===")
+	assert.Contains(t, out.String(), "Dentist")
+	got, isExist, err := lastChkdDao.GetLastChecked()
+	require.NoError(t, err)
+	assert.True(t, isExist)
+	assert.True(t, clock.Now().Equal(got))
+	current, err := os.ReadFile(cfg.LastCheckedFile)
+	require.NoError(t, err)
+	assert.Equal(t, stored, current)
+	assert.NoFileExists(t, cfg.OutboxFile)
+	assert.NoFileExists(t, cfg.ModerationFile)
+}
+
+func TestDryRunOutbox(t *testing.T) {
+	cfg := Config{OutboxFile: filepath.Join(t.TempDir(), "outbox.json")}
+	queued := OutboxEntry{ChatId: 1, Event: CalendarEvent{Title: "queued"}}
+	require.NoError(t, NewOutboxDao(cfg).Enqueue(queued))
+	stored, err := os.ReadFile(cfg.OutboxFile)
+	require.NoError(t, err)
+	dao := &dryRunOutboxDao{dao: NewOutboxDao(cfg)}
+
+	require.NoError(t, dao.Enqueue(OutboxEntry{ChatId: 2, Event: CalendarEvent{Title: "new"}}))
+	require.NoError(t, dao.RemoveChat(1))
+
+	entries, err := dao.GetOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(2), entries[0].ChatId)
+	current, err := os.ReadFile(cfg.OutboxFile)
+	require.NoError(t, err)
+	assert.Equal(t, stored, current)
+}
+
+func TestDryRunModeration(t *testing.T) {
+	cfg := Config{ModerationFile: filepath.Join(t.TempDir(), "moderation.json")}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	dao := &dryRunModerationDao{dao: NewModerationDao(cfg, clock), clock: clock}
+
+	req, err := dao.AddRequest(CalendarEvent{Title: "Party"})
+	require.NoError(t, err)
+	_, ok, err := dao.GetRequest(req.Id)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = dao.Decide(req.Id, true, "Dana")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = dao.Decide(req.Id, true, "Dana")
+	require.NoError(t, err)
+	assert.False(t, ok, "decided twice")
+
+	decisions, err := dao.GetDecisions()
+	require.NoError(t, err)
+	assert.Len(t, decisions, 1)
+	assert.NoFileExists(t, cfg.ModerationFile)
+}
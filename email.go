@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EmailRecipient is someone notified by email rather than on Telegram. Like a
+// subscription, the filters narrow down the events they get, and empty ones
+// match everything.
+type EmailRecipient struct {
+	Email     string        `json:"email"`
+	Name      string        `json:"name"`
+	Calendars []string      `json:"calendars,omitempty"`
+	Statuses  []EventStatus `json:"statuses,omitempty"`
+	Keywords  []string      `json:"keywords,omitempty"`
+	// Digest sends a single email listing the changes found by a check,
+	// instead of an email per change.
+	Digest bool `json:"digest,omitempty"`
+}
+
+func (r EmailRecipient) Matches(event CalendarEvent) bool {
+	return Subscription{Calendars: r.Calendars, Statuses: r.Statuses, Keywords: r.Keywords}.Matches(event)
+}
+
+func (r EmailRecipient) address() *mail.Address {
+	return &mail.Address{Name: r.Name, Address: r.Email}
+}
+
+// LoadEmailRecipients reads a JSON array of email recipients from the given
+// file. An empty path yields no recipients.
+func LoadEmailRecipients(path string) ([]EmailRecipient, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading email recipients file")
+	}
+
+	var recipients []EmailRecipient
+	if err := json.Unmarshal(b, &recipients); err != nil {
+		return nil, errors.Wrap(err, "error parsing email recipients file")
+	}
+
+	for _, r := range recipients {
+		if _, err := mail.ParseAddress(r.Email); err != nil {
+			return nil, errors.Errorf("invalid email address %q", r.Email)
+		}
+	}
+
+	return recipients, nil
+}
+
+// Mailer notifies email recipients about events.
+type Mailer interface {
+	Init() error
+	// NotifyEvent sends an email with an invitation to the event, which
+	// mail clients offer to add to the recipient's calendar.
+	NotifyEvent(to EmailRecipient, event CalendarEvent) error
+	// NotifyDigest sends a single email listing several events.
+	NotifyDigest(to EmailRecipient, events []CalendarEvent) error
+}
+
+func NewMailer(cfg Config, people peopleDirectory, clock Clock) Mailer {
+	return &smtpMailer{
+		cfg:    cfg,
+		people: people,
+		clock:  clock,
+	}
+}
+
+// smtpTimeout bounds a whole SMTP session, so that a stuck server doesn't
+// hold back the check.
+const smtpTimeout = 30 * time.Second
+
+// smtpUnavailableError is a failure to reach the SMTP server at all, as
+// opposed to a failure to send a particular email. The emails left in the
+// cycle aren't attempted after it.
+type smtpUnavailableError struct {
+	error
+}
+
+func (e smtpUnavailableError) Unwrap() error {
+	return e.error
+}
+
+type smtpMailer struct {
+	cfg    Config
+	people peopleDirectory
+	clock  Clock
+	from   *mail.Address
+	// html and plain render the two alternative bodies of the emails.
+	html  renderer
+	plain renderer
+	// loc is the timezone of the invitations.
+	loc *time.Location
+}
+
+func (m *smtpMailer) Init() error {
+	from, err := mail.ParseAddress(m.cfg.SMTPFrom)
+	if err != nil {
+		return errors.Wrap(err, "error parsing SMTP sender")
+	}
+	m.from = from
+
+	loc, err := time.LoadLocation(m.cfg.Timezone)
+	if err != nil {
+		return errors.Wrap(err, "error loading timezone")
+	}
+	m.loc = loc
+
+	m.html = newRenderer(m.cfg, emailHTMLMarkup{}, m.people, m.clock)
+	m.plain = newRenderer(m.cfg, plainMarkup{}, m.people, m.clock)
+	return nil
+}
+
+func (m *smtpMailer) NotifyEvent(to EmailRecipient, event CalendarEvent) error {
+	e, err := m.renderEvent(to, event)
+	if err != nil {
+		return err
+	}
+
+	return m.send(e)
+}
+
+func (m *smtpMailer) NotifyDigest(to EmailRecipient, events []CalendarEvent) error {
+	e, err := m.renderDigest(to, events)
+	if err != nil {
+		return err
+	}
+
+	return m.send(e)
+}
+
+func (m *smtpMailer) renderEvent(to EmailRecipient, event CalendarEvent) (email, error) {
+	subject, err := m.plain.heading(event)
+	if err != nil {
+		return email{}, err
+	}
+	text, err := m.plain.prepareMessageBody(event)
+	if err != nil {
+		return email{}, err
+	}
+	html, err := m.html.prepareMessageBody(event)
+	if err != nil {
+		return email{}, err
+	}
+
+	return email{
+		to:         to,
+		subject:    subject,
+		text:       text,
+		html:       html,
+		invitation: MarshalICS(event, m.loc, m.clock.Now()),
+		method:     icsMethod(event),
+	}, nil
+}
+
+func (m *smtpMailer) renderDigest(to EmailRecipient, events []CalendarEvent) (email, error) {
+	text, err := m.plain.prepareDigestBody(events)
+	if err != nil {
+		return email{}, err
+	}
+	html, err := m.html.prepareDigestBody(events)
+	if err != nil {
+		return email{}, err
+	}
+
+	return email{
+		to:      to,
+		subject: fmt.Sprintf("📬 %d עדכונים ביומן", len(events)),
+		text:    text,
+		html:    html,
+	}, nil
+}
+
+// send delivers the email through the SMTP server, upgrading the connection
+// to TLS when the server offers it.
+func (m *smtpMailer) send(e email) error {
+	msg, err := e.build(m.from, m.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	// the session is timed by the context, rather than by a deadline, which
+	// would have to be read off the wall clock
+	ctx, cancel := context.WithTimeout(context.Background(), smtpTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return smtpUnavailableError{errors.Wrap(err, "error connecting to SMTP server")}
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	c, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		_ = conn.Close()
+		return smtpUnavailableError{errors.Wrap(err, "error connecting to SMTP server")}
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.SMTPHost}); err != nil {
+			return smtpUnavailableError{errors.Wrap(err, "error starting TLS")}
+		}
+	}
+	if m.cfg.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)); err != nil {
+			return smtpUnavailableError{errors.Wrap(err, "error authenticating to SMTP server")}
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return errors.Wrap(err, "error sending email")
+	}
+	if err := c.Rcpt(e.to.Email); err != nil {
+		return errors.Wrapf(err, "error sending email to %s", e.to.Email)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "error sending email")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "error sending email")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "error sending email")
+	}
+
+	return errors.Wrap(c.Quit(), "error closing SMTP session")
+}
+
+// email is a rendered email, before it's encoded as a MIME message.
+type email struct {
+	to      EmailRecipient
+	subject string
+	// text and html are the alternative bodies: the same content in plain
+	// text and in HTML.
+	text string
+	html string
+	// invitation is the iCalendar of the event, with its METHOD. It's sent
+	// as another alternative, which mail clients show as an invitation.
+	invitation []byte
+	method     string
+}
+
+// build encodes the email as a multipart/alternative MIME message.
+func (e email) build(from *mail.Address, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	if err := writeQuotedPrintablePart(mw, "text/plain; charset=UTF-8", e.text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintablePart(mw, "text/html; charset=UTF-8", emailHTMLDocument(e.html)); err != nil {
+		return nil, err
+	}
+	if e.invitation != nil {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/calendar; charset=UTF-8; method=" + e.method},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "error encoding email")
+		}
+		if _, err := w.Write(wrapBase64(e.invitation)); err != nil {
+			return nil, errors.Wrap(err, "error encoding email")
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "error encoding email")
+	}
+
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", e.to.address().String())
+	header("Subject", mime.BEncoding.Encode("UTF-8", e.subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", newMessageId(from))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+func writeQuotedPrintablePart(mw *multipart.Writer, contentType string, content string) error {
+	w, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error encoding email")
+	}
+
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return errors.Wrap(err, "error encoding email")
+	}
+	return errors.Wrap(qp.Close(), "error encoding email")
+}
+
+// wrapBase64 encodes b in base64, in lines of 76 characters as MIME requires.
+func wrapBase64(b []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(b)
+	var wrapped bytes.Buffer
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+	return wrapped.Bytes()
+}
+
+// emailHTMLDocument wraps an HTML body, whose lines are separated like in
+// Telegram messages, in a right-to-left document.
+func emailHTMLDocument(body string) string {
+	return "<!DOCTYPE html>\n<html dir=\"rtl\" lang=\"he\">\n<body>\n" +
+		strings.ReplaceAll(body, "\n", "<br>\n") +
+		"\n</body>\n</html>\n"
+}
+
+// newMessageId returns a unique Message-ID in the domain of the sender.
+func newMessageId(from *mail.Address) string {
+	domain := "calendarbot"
+	if i := strings.LastIndex(from.Address, "@"); i >= 0 {
+		domain = from.Address[i+1:]
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+// emailHTMLMarkup renders the HTML bodies of emails, in which Telegram
+// mentions mean nothing.
+type emailHTMLMarkup struct {
+	htmlMarkup
+}
+
+func (h emailHTMLMarkup) Mention(name string, _ int64) string {
+	return h.Escape(name)
+}
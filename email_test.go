@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSMTPServer is an in-process SMTP server, recording the messages it
+// receives.
+type testSMTPServer struct {
+	listener net.Listener
+	// rejectRcpt makes the server refuse every recipient.
+	rejectRcpt bool
+
+	mu       sync.Mutex
+	messages []testSMTPMessage
+}
+
+type testSMTPMessage struct {
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &testSMTPServer{listener: l}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSMTPServer) handle(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+
+	var msg testSMTPMessage
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			msg.auth = arg
+			_ = tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			msg.from = arg
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT":
+			if s.rejectRcpt {
+				_ = tp.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+			msg.to = append(msg.to, arg)
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if msg.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *testSMTPServer) received() []testSMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testSMTPMessage(nil), s.messages...)
+}
+
+type testEmailPart struct {
+	contentType string
+	params      map[string]string
+	body        string
+}
+
+// readTestEmail parses a received message, returning its subject and its
+// alternative parts, decoded.
+func readTestEmail(t *testing.T, data []byte) (*mail.Message, string, []testEmailPart) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	var parts []testEmailPart
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(p)
+		require.NoError(t, err)
+		// quoted-printable parts are decoded by the reader
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+			require.NoError(t, err)
+		}
+		contentType, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		parts = append(parts, testEmailPart{contentType: contentType, params: params, body: string(body)})
+	}
+
+	return msg, subject, parts
+}
+
+func newTestMailer(t *testing.T, server *testSMTPServer) Mailer {
+	mailer := NewMailer(Config{
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     server.port(),
+		SMTPUsername: "bot",
+		SMTPPassword: "secret",
+		SMTPFrom:     "Calendar Bot <bot@example.com>",
+		Timezone:     "Asia/Jerusalem",
+	}, peopleDirectory{}, NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)))
+	require.NoError(t, mailer.Init())
+	return mailer
+}
+
+var testEmailRecipient = EmailRecipient{Email: "grandma@example.com", Name: "Grandma"}
+
+func TestMailerNotifyEvent(t *testing.T) {
+	server := newTestSMTPServer(t)
+	mailer := newTestMailer(t, server)
+	event := CalendarEvent{
+		Id:       "abc",
+		Title:    "Dinner <at> grandma's",
+		Start:    time.Date(2024, time.June, 4, 17, 0, 0, 0, time.UTC),
+		End:      time.Date(2024, time.June, 4, 19, 0, 0, 0, time.UTC),
+		Location: "Herzl 1",
+		Status:   StatusCreated,
+	}
+
+	require.NoError(t, mailer.NotifyEvent(testEmailRecipient, event))
+
+	received := server.received()
+	require.Len(t, received, 1)
+	assert.Equal(t, "PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00bot\x00secret")), received[0].auth)
+	assert.Equal(t, "FROM:<bot@example.com>", received[0].from)
+	assert.Equal(t, []string{"TO:<grandma@example.com>"}, received[0].to)
+
+	msg, subject, parts := readTestEmail(t, received[0].data)
+	assert.Equal(t, "🗓️ Dinner <at> grandma's", subject)
+	to, err := msg.Header.AddressList("To")
+	require.NoError(t, err)
+	assert.Equal(t, []*mail.Address{{Name: "Grandma", Address: "grandma@example.com"}}, to)
+	assert.NotEmpty(t, msg.Header.Get("Message-ID"))
+
+	require.Len(t, parts, 3)
+	assert.Equal(t, "text/plain", parts[0].contentType)
+	assert.Contains(t, parts[0].body, "🗓️ Dinner <at> grandma's\n")
+	assert.Contains(t, parts[0].body, "מיקום: Herzl 1")
+	assert.Equal(t, "text/html", parts[1].contentType)
+	assert.Contains(t, parts[1].body, `<html dir="rtl" lang="he">`)
+	assert.Contains(t, parts[1].body, "🗓️ <b>Dinner &lt;at&gt; grandma's</b><br>")
+	assert.Equal(t, "text/calendar", parts[2].contentType)
+	assert.Equal(t, "REQUEST", parts[2].params["method"])
+	assert.Contains(t, parts[2].body, "METHOD:REQUEST\r\n")
+	assert.Contains(t, parts[2].body, "SUMMARY:Dinner <at> grandma's\r\n")
+}
+
+func TestMailerNotifyCanceledEvent(t *testing.T) {
+	server := newTestSMTPServer(t)
+	mailer := newTestMailer(t, server)
+	event := CalendarEvent{
+		Id:     "abc",
+		Title:  "Dinner",
+		Start:  time.Date(2024, time.June, 4, 17, 0, 0, 0, time.UTC),
+		End:    time.Date(2024, time.June, 4, 19, 0, 0, 0, time.UTC),
+		Status: StatusCanceled,
+	}
+
+	require.NoError(t, mailer.NotifyEvent(testEmailRecipient, event))
+
+	received := server.received()
+	require.Len(t, received, 1)
+	_, subject, parts := readTestEmail(t, received[0].data)
+	assert.Equal(t, "️🆇 בוטל: Dinner", subject)
+	require.Len(t, parts, 3)
+	assert.Equal(t, "CANCEL", parts[2].params["method"])
+	assert.Contains(t, parts[2].body, "STATUS:CANCELLED\r\n")
+}
+
+func TestMailerNotifyDigest(t *testing.T) {
+	server := newTestSMTPServer(t)
+	mailer := newTestMailer(t, server)
+	start := time.Date(2024, time.June, 4, 17, 0, 0, 0, time.UTC)
+	events := []CalendarEvent{
+		{Title: "Dinner", Start: start, End: start.Add(time.Hour), Status: StatusCreated},
+		{Title: "Dentist", Start: start, End: start.Add(time.Hour), Status: StatusUpdated},
+	}
+
+	require.NoError(t, mailer.NotifyDigest(testEmailRecipient, events))
+
+	received := server.received()
+	require.Len(t, received, 1)
+	_, subject, parts := readTestEmail(t, received[0].data)
+	assert.Equal(t, "📬 2 עדכונים ביומן", subject)
+	require.Len(t, parts, 2)
+	assert.Contains(t, parts[0].body, "Dinner")
+	assert.Contains(t, parts[0].body, "עדכון: Dentist")
+	assert.Contains(t, parts[1].body, "<b>עדכון: Dentist</b>")
+}
+
+func TestMailerRejectedRecipient(t *testing.T) {
+	server := newTestSMTPServer(t)
+	server.rejectRcpt = true
+	mailer := newTestMailer(t, server)
+
+	err := mailer.NotifyDigest(testEmailRecipient, []CalendarEvent{{Title: "Dinner", Status: StatusCreated}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "grandma@example.com")
+	assert.Empty(t, server.received())
+}
+
+func TestMailerServerUnavailable(t *testing.T) {
+	server := newTestSMTPServer(t)
+	mailer := newTestMailer(t, server)
+	require.NoError(t, server.listener.Close())
+
+	err := mailer.NotifyEvent(testEmailRecipient, CalendarEvent{Title: "Dinner", Status: StatusCreated})
+
+	var smtpErr smtpUnavailableError
+	assert.ErrorAs(t, err, &smtpErr)
+
+	// a rejected recipient says nothing about the server
+	server = newTestSMTPServer(t)
+	server.rejectRcpt = true
+	err = newTestMailer(t, server).NotifyEvent(testEmailRecipient, CalendarEvent{Title: "Dinner", Status: StatusCreated})
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &smtpErr))
+}
+
+func TestLoadEmailRecipients(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []EmailRecipient
+		wantErr  bool
+	}{
+		{"recipients", `[{"email": "grandma@example.com", "name": "Grandma", "calendars": ["family"], "digest": true}]`,
+			[]EmailRecipient{{Email: "grandma@example.com", Name: "Grandma", Calendars: []string{"family"}, Digest: true}}, false},
+		{"invalid address", `[{"email": "grandma"}]`, nil, true},
+		{"invalid json", `{`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "email_recipients.json")
+			require.NoError(t, os.WriteFile(path, []byte(test.content), 0644))
+
+			recipients, err := LoadEmailRecipients(path)
+
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, recipients)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"slices"
 	"time"
 
 	"github.com/pkg/errors"
@@ -9,46 +10,574 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// The reasons for not announcing a changed event, as logged and counted.
+const (
+	filterReasonOwner        = "owner"
+	filterReasonOutdated     = "outdated"
+	filterReasonNoRecipients = "no_recipients"
+)
+
 type Engine struct {
-	cfg         Config
-	calSvc      CalendarService
-	telcli      Telegram
-	lastChkdDao LastCheckedDao
+	cfg             Config
+	calSvc          CalendarService
+	telcli          Telegram
+	mailer          Mailer
+	emailRecipients []EmailRecipient
+	lastChkdDao     LastCheckedDao
+	subsDao         SubscriptionDao
+	outboxDao       OutboxDao
+	modDao          ModerationDao
+	pausedDao       PausedCalendarsDao
+	people          peopleDirectory
+	quiet           quietHours
+	conflicts       conflictDetector
+	clock           Clock
+	auditDao        AuditDao
+	metrics         *Metrics
+	health          *Health
+}
+
+// cycle is a run of the engine over the changes made to the calendars, logged
+// with its id and audited.
+type cycle struct {
+	id      string
+	now     time.Time
+	logger  *log.Entry
+	records []AuditRecord
+}
+
+func newCycle(now time.Time) *cycle {
+	id := newCycleId()
+	return &cycle{id: id, now: now, logger: log.WithField("cycleId", id)}
+}
+
+// record starts the audit record of an event, and returns its index.
+func (c *cycle) record(source string, calendarId string, event CalendarEvent) int {
+	c.records = append(c.records, AuditRecord{
+		CycleId:    c.id,
+		At:         c.now,
+		CalendarId: calendarId,
+		EventId:    event.Id,
+		Title:      event.Title,
+		Start:      event.Start,
+		Status:     event.Status,
+		Source:     source,
+	})
+	return len(c.records) - 1
+}
+
+// check records whether the event passed the filter, and returns it.
+func (c *cycle) check(record int, filter string, passed bool) bool {
+	c.records[record].Filters = append(c.records[record].Filters, AuditFilter{Name: filter, Passed: passed})
+	return passed
 }
 
 func (e *Engine) Work(ctx context.Context) error {
-	t, isExist, err := e.lastChkdDao.GetLastChecked()
+	c := newCycle(e.clock.Now())
+	err := e.work(ctx, c)
+	e.health.observe(e.cfg, c.now, err)
+	if err != nil {
+		return err
+	}
+
+	e.metrics.cycleSucceeded(c.now)
+	return nil
+}
+
+func (e *Engine) work(ctx context.Context, c *cycle) error {
+	now := c.now
+	since, isExist, err := e.lastChkdDao.GetLastChecked()
 	if !isExist {
-		t = time.Now().Add(-time.Hour)
+		since = now.Add(-e.cfg.FirstRunLookBack)
 	} else if err != nil {
 		return errors.Wrap(err, "error reading last checked from file")
 	}
 
-	timeCheck := time.Now()
-	events, err := e.calSvc.GetRecentEvents(ctx, t)
+	if maxLookBack := e.cfg.MaxLookBack; maxLookBack > 0 && since.Before(now.Add(-maxLookBack)) {
+		c.logger.WithField("lastChecked", since).Warn("last check is too far back, skipping older changes")
+		since = now.Add(-maxLookBack)
+	}
+
+	if err := e.process(ctx, c, since); err != nil {
+		return err
+	}
+
+	return errors.Wrap(e.lastChkdDao.SetLastChecked(now), "error writing last checked time")
+}
+
+// Reconfigure switches the engine to a new configuration. It must not be
+// called during a cycle.
+func (e *Engine) Reconfigure(cfg Config) error {
+	quiet, err := NewQuietHours(cfg)
+	if err != nil {
+		return errors.Wrap(err, "error reading quiet hours")
+	}
+
+	e.cfg = cfg
+	e.quiet = quiet
+	e.conflicts = newConflictDetector(cfg, e.calSvc)
+	return nil
+}
+
+// Backfill replays the changes made since the given time, regardless of when
+// the calendars were last checked and of the maximal look-back. It leaves the
+// last check time alone, so the next cycle still picks up from there.
+func (e *Engine) Backfill(ctx context.Context, since time.Time) error {
+	c := newCycle(e.clock.Now())
+	c.logger = c.logger.WithField("backfillSince", since)
+	return e.process(ctx, c, since)
+}
+
+// process notifies about the changes made to the calendars between since and
+// the time of the cycle, auditing every decision made along the way.
+func (e *Engine) process(ctx context.Context, c *cycle, since time.Time) error {
+	defer e.audit(c)
+	now, logger := c.now, c.logger
+
+	subs, err := e.subsDao.GetSubscriptions()
+	if err != nil {
+		return errors.Wrap(err, "error reading subscriptions")
+	}
+	// users taken off the allow list keep their subscriptions, in case
+	// they're let back in, but aren't notified anymore
+	subs = slices.DeleteFunc(subs, func(sub Subscription) bool {
+		if allowedUserId(e.cfg, e.people, sub.ChatId) {
+			return false
+		}
+		logger.WithField("chatId", sub.ChatId).Debug("skipping subscription of a user who is no longer allowed")
+		return true
+	})
+
+	if err := e.flushOutbox(c); err != nil {
+		return errors.Wrap(err, "error flushing outbox")
+	}
+
+	var paused []string
+	if e.pausedDao != nil {
+		if paused, err = e.pausedDao.GetPaused(); err != nil {
+			return errors.Wrap(err, "error reading paused calendars")
+		}
+	}
+
+	var chats []int64
+	pending := make(map[int64][]CalendarEvent)
+	// the audit records of the pending events
+	pendingRecords := make(map[int64][]int)
+	// chats that must be notified, as opposed to subscribers
+	required := make(map[int64]bool)
+	add := func(chatId int64, event CalendarEvent, record int) {
+		if _, ok := pending[chatId]; !ok {
+			chats = append(chats, chatId)
+		}
+		pending[chatId] = append(pending[chatId], event)
+		pendingRecords[chatId] = append(pendingRecords[chatId], record)
+	}
+	// the events to email, and their audit records, by recipient
+	emails := make([][]CalendarEvent, len(e.emailRecipients))
+	emailRecords := make([][]int, len(e.emailRecipients))
+
+	for _, calendarId := range e.cfg.Calendars() {
+		calLogger := logger.WithField("calendarId", calendarId)
+		if slices.Contains(paused, calendarId) {
+			calLogger.Info("skipping paused calendar")
+			continue
+		}
+
+		events, err := e.calSvc.GetRecentEvents(ctx, calendarId, since)
+		if err != nil {
+			return errors.Wrap(err, "error getting events")
+		}
+		calLogger.WithField("events", len(events)).Debug("fetched changed events")
+
+		for _, event := range events {
+			eventLogger := calLogger.WithFields(log.Fields{"eventId": event.Id, "status": event.Status.String()})
+			e.metrics.eventFetched(event)
+			record := c.record(auditSourceCalendar, calendarId, event)
+			if !c.check(record, filterReasonOwner, event.Creator != calendarId) {
+				e.filter(eventLogger, filterReasonOwner, "ignoring event created by calendar owner")
+				continue
+			}
+
+			if !c.check(record, filterReasonOutdated, !event.Start.Before(now)) {
+				e.filter(eventLogger, filterReasonOutdated, "ignoring outdated event")
+				continue
+			}
+
+			// the warning is a nicety, it shouldn't hold back the
+			// notification itself
+			if e.cfg.DetectConflicts {
+				if event.Conflicts, err = e.conflicts.Detect(ctx, event); err != nil {
+					eventLogger.WithError(err).Warn("error detecting conflicts")
+				}
+			}
+
+			e.moderate(eventLogger, event, now)
+
+			recipients := e.recipients(event)
+			for _, chatId := range recipients {
+				add(chatId, event, record)
+				required[chatId] = true
+			}
+
+			notified := slices.Clone(recipients)
+			for _, sub := range subs {
+				if !slices.Contains(recipients, sub.ChatId) && sub.Matches(event) {
+					add(sub.ChatId, event, record)
+					notified = append(notified, sub.ChatId)
+				}
+			}
+			var emailed []string
+			for i, recipient := range e.emailRecipients {
+				if recipient.Matches(event) {
+					emails[i] = append(emails[i], event)
+					emailRecords[i] = append(emailRecords[i], record)
+					emailed = append(emailed, recipient.Email)
+				}
+			}
+			if !c.check(record, filterReasonNoRecipients, len(notified) > 0 || len(emailed) > 0) {
+				e.filter(eventLogger, filterReasonNoRecipients, "ignoring event nobody is to be notified about")
+				continue
+			}
+			eventLogger.WithFields(log.Fields{"chatIds": notified, "emails": emailed}).Debug("announcing event")
+		}
+	}
+
+	for _, chatId := range chats {
+		deliveries, err := e.deliverAll(logger.WithField("chatId", chatId), chatId, pending[chatId], since, now)
+		for i, delivery := range deliveries {
+			record := &c.records[pendingRecords[chatId][i]]
+			record.Deliveries = append(record.Deliveries, delivery)
+		}
+		if err != nil && required[chatId] {
+			return errors.Wrap(err, "error sending telegram message")
+		}
+		// A subscriber who blocked the bot must not hold back everyone
+		// else, so failures are only logged.
+		if err != nil {
+			logger.WithError(err).WithField("chatId", chatId).Warn("error notifying subscriber")
+		}
+	}
+
+	if len(e.emailRecipients) > 0 {
+		if err := e.emailAll(c, emails, emailRecords); err != nil {
+			return errors.Wrap(err, "error queueing emails")
+		}
+	}
+
+	return nil
+}
+
+// emailAll emails the recipients about the events, along with the emails that
+// failed to send on earlier cycles. Like subscribers, email recipients don't
+// hold back the check: the emails that fail are queued in the outbox for the
+// next cycle. Once the SMTP server can't be reached, the remaining emails are
+// queued without trying.
+func (e *Engine) emailAll(c *cycle, emails [][]CalendarEvent, emailRecords [][]int) error {
+	entries, err := e.outboxDao.GetOutbox()
+	if err != nil {
+		return err
+	}
+	queued := make(map[string][]OutboxEntry)
+	for _, entry := range entries {
+		if entry.Email != "" {
+			queued[entry.Email] = append(queued[entry.Email], entry)
+		}
+	}
+
+	unavailable := false
+	for i, recipient := range e.emailRecipients {
+		pending := slices.Clone(queued[recipient.Email])
+		records := make([]int, 0, len(pending)+len(emails[i]))
+		for _, entry := range pending {
+			records = append(records, c.record(auditSourceOutbox, entry.Event.CalendarId, entry.Event))
+		}
+		records = append(records, emailRecords[i]...)
+		for _, event := range emails[i] {
+			pending = append(pending, OutboxEntry{Email: recipient.Email, Event: event, QueuedAt: c.now})
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		logger := c.logger.WithField("email", recipient.Email)
+		events := make([]CalendarEvent, len(pending))
+		for j, entry := range pending {
+			events[j] = entry.Event
+		}
+		// the emails that didn't make it, from the failed one on, are
+		// retried on the next cycle
+		var deliveries []AuditDelivery
+		sent := 0
+		if unavailable {
+			logger.Info("SMTP server unavailable, queueing emails")
+		} else {
+			deliveries, err = e.email(logger, recipient, events)
+			sent = len(deliveries)
+			if err != nil {
+				logger.WithError(err).Warn("error emailing recipient, will retry on the next cycle")
+				// a digest is sent whole or not at all
+				sent--
+				if deliveries[0].Summarized {
+					sent = 0
+				}
+				var smtpErr smtpUnavailableError
+				unavailable = errors.As(err, &smtpErr)
+			}
+		}
+		for j := range pending {
+			delivery := newEmailDelivery(recipient, nil)
+			if j < len(deliveries) {
+				delivery = deliveries[j]
+			}
+			delivery.Deferred = j >= sent
+			record := &c.records[records[j]]
+			record.Deliveries = append(record.Deliveries, delivery)
+		}
+
+		if err := e.requeueEmails(recipient, len(queued[recipient.Email]) > 0, pending[sent:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeueEmails replaces the emails queued for the recipient with the ones
+// still to be sent.
+func (e *Engine) requeueEmails(to EmailRecipient, wasQueued bool, entries []OutboxEntry) error {
+	if wasQueued {
+		if err := e.outboxDao.RemoveEmail(to.Email); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := e.outboxDao.Enqueue(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// audit appends the records of the cycle to the audit log. Failing to do so
+// is only logged, as the notifications went out either way.
+func (e *Engine) audit(c *cycle) {
+	if e.auditDao == nil {
+		return
+	}
+	if err := e.auditDao.Append(c.records); err != nil {
+		c.logger.WithError(err).Error("error writing audit log")
+	}
+}
+
+// filter logs why the event isn't announced.
+func (e *Engine) filter(logger *log.Entry, reason string, msg string) {
+	logger.WithField("reason", reason).Info(msg)
+	e.metrics.eventFiltered(reason)
+}
+
+// deliverAll notifies the chat about the events, summarizing them in a single
+// message when there are more than CatchUpThreshold of them. During quiet
+// hours they're all queued, as the outbox is flushed as a digest anyway. It
+// returns the deliveries of the events it got to, in order.
+func (e *Engine) deliverAll(logger *log.Entry, chatId int64, events []CalendarEvent, since, now time.Time) ([]AuditDelivery, error) {
+	_, quiet := e.quiet.QuietUntil(chatId, now)
+	if quiet || e.cfg.CatchUpThreshold <= 0 || len(events) <= e.cfg.CatchUpThreshold {
+		var deliveries []AuditDelivery
+		for _, event := range events {
+			delivery, err := e.deliver(logger, chatId, event, now)
+			deliveries = append(deliveries, delivery)
+			if err != nil {
+				return deliveries, err
+			}
+		}
+		return deliveries, nil
+	}
+
+	logger.WithFields(log.Fields{"events": len(events), "eventIds": eventIds(events)}).Info("summarizing changes")
+	messageId, err := e.telcli.NotifyCatchUp(chatId, since, events)
+	deliveries := make([]AuditDelivery, len(events))
+	for i := range deliveries {
+		deliveries[i] = newAuditDelivery(chatId, messageId, err)
+		deliveries[i].Summarized = true
+	}
 	if err != nil {
-		return errors.Wrap(err, "error getting events")
+		return deliveries, err
+	}
+
+	e.metrics.eventsSent(events...)
+	return deliveries, nil
+}
+
+// email notifies the recipient about the events, in a single digest if they
+// asked for one or if there are too many of them. It returns the deliveries of
+// the events it got to, in order.
+func (e *Engine) email(logger *log.Entry, to EmailRecipient, events []CalendarEvent) ([]AuditDelivery, error) {
+	tooMany := e.cfg.CatchUpThreshold > 0 && len(events) > e.cfg.CatchUpThreshold
+	if len(events) > 1 && (to.Digest || tooMany) {
+		logger.WithField("eventIds", eventIds(events)).Info("emailing digest")
+		err := e.mailer.NotifyDigest(to, events)
+		deliveries := make([]AuditDelivery, len(events))
+		for i := range deliveries {
+			deliveries[i] = newEmailDelivery(to, err)
+			deliveries[i].Summarized = true
+		}
+		if err != nil {
+			return deliveries, err
+		}
+
+		e.metrics.eventsSent(events...)
+		return deliveries, nil
 	}
 
+	var deliveries []AuditDelivery
 	for _, event := range events {
-		if event.Creator == e.cfg.CalendarId {
-			log.Info("ignoring event created by calendar owner")
+		err := e.mailer.NotifyEvent(to, event)
+		deliveries = append(deliveries, newEmailDelivery(to, err))
+		if err != nil {
+			return deliveries, err
+		}
+		e.metrics.eventsSent(event)
+	}
+	return deliveries, nil
+}
+
+func newEmailDelivery(to EmailRecipient, err error) AuditDelivery {
+	delivery := newAuditDelivery(0, 0, err)
+	delivery.Email = to.Email
+	return delivery
+}
+
+func newAuditDelivery(chatId int64, messageId int, err error) AuditDelivery {
+	delivery := AuditDelivery{ChatId: chatId, MessageId: messageId}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
+
+// moderate asks the moderation chat to approve new events, when moderation
+// is enabled. Failing to do so is only logged, as the event is announced
+// either way. An event seen again, because a failed cycle is retried, isn't
+// asked about twice.
+func (e *Engine) moderate(logger *log.Entry, event CalendarEvent, now time.Time) {
+	if e.cfg.ModerationChatId == 0 || event.Status != StatusCreated {
+		return
+	}
+
+	pending, err := e.modDao.IsPending(event.CalendarId, event.Id)
+	if err != nil {
+		logger.WithError(err).Warn("error reading moderation requests")
+		return
+	}
+	if pending {
+		logger.Debug("event already awaits moderation")
+		return
+	}
+
+	req, err := e.modDao.AddRequest(event)
+	if err == nil {
+		err = e.telcli.SendChoices(e.cfg.ModerationChatId, moderationText(req, e.people, now), moderationChoices(req))
+	}
+	if err != nil {
+		logger.WithError(err).Warn("error requesting moderation")
+	}
+}
+
+// recipients returns the chats that should be notified about the event. When
+// NotifyAttendeesOnly is set, attendees known to the bot are messaged privately
+// instead of the group chat.
+func (e *Engine) recipients(event CalendarEvent) []int64 {
+	if e.cfg.NotifyAttendeesOnly {
+		if ids := e.people.TelegramIds(event.Attendees); len(ids) > 0 {
+			return ids
+		}
+	}
+
+	if e.cfg.TelegramChatId == 0 {
+		return nil
+	}
+
+	return []int64{e.cfg.TelegramChatId}
+}
+
+// deliver notifies the chat about the event, unless the chat is in its quiet
+// hours, in which case the notification is queued in the outbox.
+func (e *Engine) deliver(logger *log.Entry, chatId int64, event CalendarEvent, now time.Time) (AuditDelivery, error) {
+	if until, quiet := e.quiet.QuietUntil(chatId, now); quiet {
+		logger.WithFields(log.Fields{"eventId": event.Id, "until": until}).Info("deferring notification during quiet hours")
+		err := e.outboxDao.Enqueue(OutboxEntry{ChatId: chatId, Event: event, QueuedAt: now})
+		delivery := newAuditDelivery(chatId, 0, err)
+		delivery.Deferred = true
+		return delivery, err
+	}
+
+	messageId, err := e.telcli.NotifyEvent(chatId, event)
+	if err != nil {
+		return newAuditDelivery(chatId, 0, err), err
+	}
+
+	e.metrics.eventsSent(event)
+	return newAuditDelivery(chatId, messageId, nil), nil
+}
+
+// flushOutbox delivers the notifications held back for chats whose quiet
+// hours are over, batched into a single message per chat.
+func (e *Engine) flushOutbox(c *cycle) error {
+	now, logger := c.now, c.logger
+	entries, err := e.outboxDao.GetOutbox()
+	if err != nil {
+		return err
+	}
+
+	var chats []int64
+	events := make(map[int64][]CalendarEvent)
+	for _, entry := range entries {
+		// emails are retried along with the new ones
+		if entry.Email != "" {
 			continue
 		}
+		if _, ok := events[entry.ChatId]; !ok {
+			chats = append(chats, entry.ChatId)
+		}
+		events[entry.ChatId] = append(events[entry.ChatId], entry.Event)
+	}
 
-		if event.Start.Before(timeCheck) {
-			log.Info("ignoring outdated event")
+	for _, chatId := range chats {
+		if _, quiet := e.quiet.QuietUntil(chatId, now); quiet {
 			continue
 		}
 
-		if err := e.telcli.NotifyEvent(event); err != nil {
+		logger.WithFields(log.Fields{"chatId": chatId, "eventIds": eventIds(events[chatId])}).Info("delivering notifications deferred during quiet hours")
+		var messageId int
+		if len(events[chatId]) == 1 {
+			messageId, err = e.telcli.NotifyEvent(chatId, events[chatId][0])
+		} else {
+			messageId, err = e.telcli.NotifyDigest(chatId, events[chatId])
+		}
+		for _, event := range events[chatId] {
+			record := c.record(auditSourceOutbox, event.CalendarId, event)
+			delivery := newAuditDelivery(chatId, messageId, err)
+			delivery.Summarized = len(events[chatId]) > 1
+			c.records[record].Deliveries = []AuditDelivery{delivery}
+		}
+		if err != nil {
 			return errors.Wrap(err, "error sending telegram message")
 		}
-	}
+		e.metrics.eventsSent(events[chatId]...)
 
-	if err := e.lastChkdDao.SetLastChecked(timeCheck); err != nil {
-		return errors.Wrap(err, "error writing last checked time")
+		if err := e.outboxDao.RemoveChat(chatId); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+func eventIds(events []CalendarEvent) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.Id
+	}
+	return ids
+}
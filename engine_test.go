@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -14,10 +15,16 @@ import (
 type EngineSuite struct {
 	suite.Suite
 	filename    string
+	subsFile    string
+	outboxFile  string
 	calSvcMock  *CalendarServiceMock
 	telCliMock  *TelegramClientMock
 	lastChkdDao LastCheckedDao
+	subsDao     SubscriptionDao
+	outboxDao   OutboxDao
 	calendarId  string
+	chatId      int64
+	clock       *FakeClock
 	engine      Engine
 }
 
@@ -27,52 +34,64 @@ func TestEngineSuite(t *testing.T) {
 
 func (s *EngineSuite) SetupSuite() {
 	s.filename = fmt.Sprintf("%s_%d.txt", "test_last_checked", time.Now().Unix())
+	s.subsFile = fmt.Sprintf("%s_%d.json", "test_subscriptions", time.Now().Unix())
+	s.outboxFile = fmt.Sprintf("%s_%d.json", "test_outbox", time.Now().Unix())
 	s.calendarId = "someCalendarId"
+	s.chatId = 1234
 }
 
 func (s *EngineSuite) SetupTest() {
+	s.clock = NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
 	s.calSvcMock = &CalendarServiceMock{}
 	s.telCliMock = &TelegramClientMock{}
 	s.lastChkdDao = NewLastCheckedDao(Config{LastCheckedFile: s.filename})
+	s.subsDao = NewSubscriptionDao(Config{SubscriptionsFile: s.subsFile})
+	s.outboxDao = NewOutboxDao(Config{OutboxFile: s.outboxFile})
 	s.engine = Engine{
 		cfg: Config{
-			CalendarId: s.calendarId,
+			CalendarId:       s.calendarId,
+			TelegramChatId:   s.chatId,
+			FirstRunLookBack: time.Hour,
+			AllowedUsers:     []int64{11, 22, 33},
 		},
 		calSvc:      s.calSvcMock,
 		telcli:      s.telCliMock,
 		lastChkdDao: s.lastChkdDao,
+		subsDao:     s.subsDao,
+		outboxDao:   s.outboxDao,
+		clock:       s.clock,
 	}
 
 	_ = os.Remove(s.filename)
+	_ = os.Remove(s.subsFile)
+	_ = os.Remove(s.outboxFile)
 }
 
 func (s *EngineSuite) TearDownSuite() {
 	_ = os.Remove(s.filename)
+	_ = os.Remove(s.subsFile)
+	_ = os.Remove(s.outboxFile)
 }
 
 func (s *EngineSuite) TestFirstRun() {
 	ctx := context.Background()
-	s.calSvcMock.On("GetRecentEvents", ctx, mock.Anything).Return([]CalendarEvent{}, nil)
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, s.clock.Now().Add(-time.Hour)).Return([]CalendarEvent{}, nil)
 
 	// SUT
 	s.Require().NoError(s.engine.Work(ctx))
 
-	s.Require().Len(s.calSvcMock.Calls, 1)
-	calledTime, ok := s.calSvcMock.Calls[0].Arguments[1].(time.Time)
-	s.Require().True(ok)
-	s.Assert().WithinDuration(time.Now().Add(-time.Hour), calledTime, 5*time.Second)
-
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "GetRecentEvents", 1)
 	newLastChecked, isExist, err := s.lastChkdDao.GetLastChecked()
 	s.Require().NoError(err)
 	s.Assert().True(isExist)
-	s.Assert().WithinDuration(time.Now(), newLastChecked, 5*time.Second)
+	s.Assert().True(s.clock.Now().Equal(newLastChecked))
 }
 
 func (s *EngineSuite) TestReceiveEvents() {
 	ctx := context.Background()
-	lastChecked := time.Now().Add(-time.Minute)
+	lastChecked := s.clock.Now().Add(-time.Minute)
 	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
-	start := time.Now().Add(24 * time.Hour)
+	start := s.clock.Now().Add(24 * time.Hour)
 	end := start.Add(time.Hour)
 	notifiedEvent := CalendarEvent{
 		Title:   "Should be notified",
@@ -86,26 +105,22 @@ func (s *EngineSuite) TestReceiveEvents() {
 		End:     end,
 		Creator: s.calendarId,
 	}
-	s.calSvcMock.On("GetRecentEvents", ctx, mock.Anything).Return([]CalendarEvent{
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return([]CalendarEvent{
 		notifiedEvent,
 		ignoredEvent,
 	}, nil)
-	s.telCliMock.On("NotifyEvent", mock.Anything).Return(nil)
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
 
 	// SUT
 	s.Require().NoError(s.engine.Work(ctx))
 
-	s.Require().Len(s.calSvcMock.Calls, 1)
-	calledTime, ok := s.calSvcMock.Calls[0].Arguments[1].(time.Time)
-	s.Require().True(ok)
-	s.Assert().WithinDuration(lastChecked, calledTime, time.Second)
-
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "GetRecentEvents", 1)
 	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 1)
-	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", notifiedEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, notifiedEvent)
 }
 
 func (s *EngineSuite) TestIgnoreEvents() {
-	start := time.Now().Add(24 * time.Hour)
+	start := s.clock.Now().Add(24 * time.Hour)
 	end := start.Add(time.Hour)
 
 	tests := []struct {
@@ -120,8 +135,8 @@ func (s *EngineSuite) TestIgnoreEvents() {
 		}},
 		{"ignore outdated events", CalendarEvent{
 			Title:   "Should be ignored",
-			Start:   time.Now().Add(-24 * time.Hour),
-			End:     time.Now().Add(-23 * time.Hour),
+			Start:   s.clock.Now().Add(-24 * time.Hour),
+			End:     s.clock.Now().Add(-23 * time.Hour),
 			Creator: "some-other-calendar-id",
 		}},
 	}
@@ -129,24 +144,644 @@ func (s *EngineSuite) TestIgnoreEvents() {
 
 	for _, test := range tests {
 		s.Suite.Run(test.name, func() {
-			lastChecked := time.Now().Add(-time.Minute)
+			lastChecked := s.clock.Now().Add(-time.Minute)
 			s.SetupTest()
 
 			s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
-			s.calSvcMock.On("GetRecentEvents", ctx, mock.Anything).Return([]CalendarEvent{
+			s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return([]CalendarEvent{
 				test.event,
 			}, nil)
-			s.telCliMock.On("NotifyEvent", mock.Anything).Return(nil)
+			s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
 
 			// SUT
 			s.Require().NoError(s.engine.Work(ctx))
 
-			s.Require().Len(s.calSvcMock.Calls, 1)
-			calledTime, ok := s.calSvcMock.Calls[0].Arguments[1].(time.Time)
-			s.Require().True(ok)
-			s.Assert().WithinDuration(lastChecked, calledTime, time.Second)
+			s.calSvcMock.AssertNumberOfCalls(s.T(), "GetRecentEvents", 1)
 
-			s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything)
+			s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
 		})
 	}
 }
+
+func (s *EngineSuite) TestNotifyAttendeesOnly() {
+	ctx := context.Background()
+	s.engine.cfg.NotifyAttendeesOnly = true
+	s.engine.people = newPeopleDirectory([]Person{
+		{Email: "dad@example.com", TelegramId: 11},
+		{Email: "mom@example.com", TelegramId: 22},
+	})
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := s.clock.Now().Add(24 * time.Hour)
+	withAttendees := CalendarEvent{
+		Title:     "Parents meeting",
+		Start:     start,
+		End:       start.Add(time.Hour),
+		Creator:   "someone else",
+		Attendees: []string{"Dad@example.com", "unknown@example.com"},
+	}
+	withoutAttendees := CalendarEvent{
+		Title:   "Family dinner",
+		Start:   start,
+		End:     start.Add(time.Hour),
+		Creator: "someone else",
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{
+		withAttendees,
+		withoutAttendees,
+	}, nil)
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(11), withAttendees)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, withoutAttendees)
+}
+
+func (s *EngineSuite) TestNotifySubscribers() {
+	ctx := context.Background()
+	otherCalendarId := "otherCalendarId"
+	s.engine.cfg.ExtraCalendarIds = []string{otherCalendarId}
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 11}))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 22, Calendars: []string{otherCalendarId}}))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 33, Keywords: []string{"football"}}))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: s.chatId}))
+	start := s.clock.Now().Add(24 * time.Hour)
+	primaryEvent := CalendarEvent{
+		CalendarId: s.calendarId,
+		Title:      "Dentist",
+		Start:      start,
+		End:        start.Add(time.Hour),
+		Creator:    "someone else",
+	}
+	otherEvent := CalendarEvent{
+		CalendarId: otherCalendarId,
+		Title:      "Football practice",
+		Start:      start,
+		End:        start.Add(time.Hour),
+		Creator:    "someone else",
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{primaryEvent}, nil)
+	s.calSvcMock.On("GetRecentEvents", ctx, otherCalendarId, mock.Anything).Return([]CalendarEvent{otherEvent}, nil)
+	s.telCliMock.On("NotifyEvent", int64(33), mock.Anything).Return(errors.New("bot was blocked by the user"))
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 6)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, primaryEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(11), primaryEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, otherEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(11), otherEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(22), otherEvent)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(33), otherEvent)
+}
+
+func (s *EngineSuite) TestSubscriberNoLongerAllowed() {
+	ctx := context.Background()
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 11}))
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 44}))
+	start := s.clock.Now().Add(24 * time.Hour)
+	event := CalendarEvent{Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{event}, nil)
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, event)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", int64(11), event)
+	subs, err := s.subsDao.GetSubscriptions()
+	s.Require().NoError(err)
+	s.Assert().Len(subs, 2, "the subscription is kept in case the user is let back in")
+}
+
+func (s *EngineSuite) TestQuietHours() {
+	ctx := context.Background()
+	now := s.clock.Now()
+	// a window that is surely open now, and another that surely isn't
+	quietWindow := dailyWindow{
+		start: clockTime{hour: now.Add(-time.Hour).Hour()},
+		end:   clockTime{hour: now.Add(2 * time.Hour).Hour()},
+		loc:   now.Location(),
+	}
+	s.engine.quiet = quietHours{chats: map[int64]quietSchedule{s.chatId: {quietWindow}}}
+	auditDao := &dryRunAuditDao{dao: NewAuditDao(Config{})}
+	s.engine.auditDao = auditDao
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(now.Add(-time.Minute)))
+	// the outbox persists events as JSON, which drops monotonic clock readings
+	// and locations
+	start := now.Add(24 * time.Hour).Truncate(time.Second).UTC()
+	events := []CalendarEvent{
+		{Title: "First", Start: start, End: start.Add(time.Hour), Creator: "someone else", Status: StatusCreated},
+		{Title: "Second", Start: start, End: start.Add(time.Hour), Creator: "someone else", Status: StatusCanceled},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return(events, nil).Once()
+
+	// SUT - notifications are deferred while the chat is quiet
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
+	outbox, err := s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Require().Len(outbox, 2)
+	s.Assert().Equal(s.chatId, outbox[0].ChatId)
+
+	// SUT - still quiet, nothing is sent
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{}, nil)
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyDigest", mock.Anything, mock.Anything)
+
+	// SUT - once quiet hours are over the queued notifications are batched
+	s.engine.quiet = quietHours{}
+	s.telCliMock.On("NotifyDigest", s.chatId, mock.Anything).Return(21, nil)
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "NotifyDigest", s.chatId, events)
+	outbox, err = s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Empty(outbox)
+
+	// the audit log tells the events were deferred, then sent in a digest
+	records, err := auditDao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Require().Len(records, 4)
+	s.Assert().Equal("deferred", records[0].Outcome())
+	s.Assert().Equal(auditSourceOutbox, records[2].Source)
+	s.Assert().Equal([]AuditDelivery{{ChatId: s.chatId, MessageId: 21, Summarized: true}}, records[2].Deliveries)
+}
+
+func (s *EngineSuite) TestDaylightSavingTimeEnds() {
+	ctx := context.Background()
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	s.Require().NoError(err)
+	// clocks are turned back from 02:00 to 01:00 on the night of October 27th
+	lastChecked := time.Date(2024, time.October, 26, 22, 50, 0, 0, time.UTC)
+	s.Require().Equal("01:50 +03", lastChecked.In(tz).Format("15:04 -07"))
+	s.clock.Set(lastChecked.Add(20 * time.Minute))
+	s.Require().Equal("01:10 +02", s.clock.Now().In(tz).Format("15:04 -07"))
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+
+	// earlier on the wall clock, yet still ahead
+	upcoming := CalendarEvent{
+		Title:   "Upcoming",
+		Start:   s.clock.Now().Add(10 * time.Minute),
+		End:     s.clock.Now().Add(time.Hour),
+		Creator: "someone else",
+	}
+	// later on the wall clock, yet already started
+	started := CalendarEvent{
+		Title:   "Started",
+		Start:   lastChecked.Add(5 * time.Minute),
+		End:     lastChecked.Add(time.Hour),
+		Creator: "someone else",
+	}
+	s.Require().Equal("01:55", started.Start.In(tz).Format("15:04"))
+	sinceLastChecked := mock.MatchedBy(func(since time.Time) bool { return since.Equal(lastChecked) })
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, sinceLastChecked).Return([]CalendarEvent{upcoming, started}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, upcoming).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 1)
+	newLastChecked, _, err := s.lastChkdDao.GetLastChecked()
+	s.Require().NoError(err)
+	s.Assert().True(s.clock.Now().Equal(newLastChecked))
+}
+
+func (s *EngineSuite) TestQuietHoursAcrossDaylightSavingTime() {
+	ctx := context.Background()
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	s.Require().NoError(err)
+	s.engine.quiet = quietHours{defaults: quietSchedule{
+		dailyWindow{start: clockTime{hour: 23}, end: clockTime{hour: 7}, loc: tz},
+	}}
+	s.clock.Set(time.Date(2024, time.October, 26, 23, 30, 0, 0, tz))
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := time.Date(2024, time.October, 28, 18, 0, 0, 0, time.UTC)
+	event := CalendarEvent{Title: "Deferred", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{event}, nil).Once()
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, event).Return(nil)
+
+	// SUT - the notification is deferred until the morning
+	s.Require().NoError(s.engine.Work(ctx))
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
+
+	// SUT - 7.5 hours went by on the wall clock, but 8.5 hours passed
+	s.clock.Advance(8 * time.Hour)
+	s.Require().Equal("06:30", s.clock.Now().In(tz).Format("15:04"))
+	s.Require().NoError(s.engine.Work(ctx))
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
+
+	// SUT - 07:00 on the wall clock
+	s.clock.Advance(30 * time.Minute)
+	s.Require().NoError(s.engine.Work(ctx))
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, event)
+}
+
+func (s *EngineSuite) TestMidnight() {
+	ctx := context.Background()
+	midnight := time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC)
+	s.engine.quiet = quietHours{defaults: quietSchedule{
+		dailyWindow{start: clockTime{hour: 22}, end: clockTime{hour: 0}, loc: time.UTC},
+	}}
+	s.clock.Set(midnight.Add(-time.Second))
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	queued := CalendarEvent{Title: "Queued", Start: midnight.Add(time.Hour), End: midnight.Add(2 * time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{queued}, nil).Once()
+
+	// SUT - a second before midnight the chat is still quiet
+	s.Require().NoError(s.engine.Work(ctx))
+	s.telCliMock.AssertNotCalled(s.T(), "NotifyEvent", mock.Anything, mock.Anything)
+
+	// an event starting right now is still relevant, one that started a
+	// second ago isn't
+	startsNow := CalendarEvent{Title: "Starts now", Start: midnight, End: midnight.Add(time.Hour), Creator: "someone else"}
+	startedBefore := CalendarEvent{Title: "Started before", Start: midnight.Add(-time.Second), End: midnight.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, s.clock.Now()).Return([]CalendarEvent{startsNow, startedBefore}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, mock.Anything).Return(nil)
+
+	// SUT - at midnight quiet hours are over
+	s.clock.Set(midnight)
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, queued)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, startsNow)
+}
+
+func (s *EngineSuite) TestLongOutage() {
+	ctx := context.Background()
+	lastChecked := s.clock.Now()
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	queuedStart := lastChecked.Add(24 * time.Hour)
+	queued := CalendarEvent{Title: "Queued before the outage", Start: queuedStart, End: queuedStart.Add(time.Hour), Creator: "someone else"}
+	s.Require().NoError(s.outboxDao.Enqueue(OutboxEntry{ChatId: s.chatId, Event: queued, QueuedAt: lastChecked}))
+
+	// the bot was down for three days
+	s.clock.Advance(72 * time.Hour)
+	missed := CalendarEvent{Title: "Missed", Start: lastChecked.Add(48 * time.Hour), End: lastChecked.Add(49 * time.Hour), Creator: "someone else"}
+	upcoming := CalendarEvent{Title: "Upcoming", Start: s.clock.Now().Add(time.Hour), End: s.clock.Now().Add(2 * time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return([]CalendarEvent{missed, upcoming}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "GetRecentEvents", 1)
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, upcoming)
+	outbox, err := s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Empty(outbox)
+	newLastChecked, _, err := s.lastChkdDao.GetLastChecked()
+	s.Require().NoError(err)
+	s.Assert().True(s.clock.Now().Equal(newLastChecked))
+}
+
+func (s *EngineSuite) TestMaxLookBack() {
+	ctx := context.Background()
+	s.engine.cfg.MaxLookBack = 24 * time.Hour
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-7 * 24 * time.Hour)))
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, s.clock.Now().Add(-24*time.Hour)).Return([]CalendarEvent{}, nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "GetRecentEvents", 1)
+}
+
+func (s *EngineSuite) TestCatchUpSummary() {
+	ctx := context.Background()
+	s.engine.cfg.CatchUpThreshold = 2
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 11, Keywords: []string{"football"}}))
+	lastChecked := s.clock.Now().Add(-7 * 24 * time.Hour)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+		{Title: "Football practice", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+		{Title: "Dinner", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return(events, nil)
+	s.telCliMock.On("NotifyCatchUp", s.chatId, lastChecked, events).Return(nil)
+	s.telCliMock.On("NotifyEvent", int64(11), events[1]).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyCatchUp", 1)
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 1)
+}
+
+func (s *EngineSuite) TestAudit() {
+	ctx := context.Background()
+	auditDao := &dryRunAuditDao{dao: NewAuditDao(Config{})}
+	s.engine.auditDao = auditDao
+	s.Require().NoError(s.subsDao.SaveSubscription(Subscription{ChatId: 11, Keywords: []string{"football"}}))
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Id: "own", Title: "Own", Start: start, End: start.Add(time.Hour), Creator: s.calendarId},
+		{Id: "past", Title: "Past", Start: start.Add(-48 * time.Hour), End: start.Add(-47 * time.Hour), Creator: "someone else"},
+		{Id: "football", Title: "Football practice", Start: start, End: start.Add(time.Hour), Creator: "someone else", Status: StatusCreated},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return(events, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, events[2]).Return(17, nil)
+	s.telCliMock.On("NotifyEvent", int64(11), events[2]).Return(errors.New("forbidden"))
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	records, err := auditDao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Require().Len(records, 3)
+	for _, record := range records {
+		s.Assert().Equal(records[0].CycleId, record.CycleId)
+		s.Assert().Equal(s.clock.Now(), record.At)
+		s.Assert().Equal(s.calendarId, record.CalendarId)
+		s.Assert().Equal(auditSourceCalendar, record.Source)
+	}
+	s.Assert().Equal("own", records[0].EventId)
+	s.Assert().Equal([]AuditFilter{{Name: filterReasonOwner, Passed: false}}, records[0].Filters)
+	s.Assert().Equal("filtered by owner", records[0].Outcome())
+	s.Assert().Equal("filtered by outdated", records[1].Outcome())
+	s.Assert().Equal("football", records[2].EventId)
+	s.Assert().Equal(StatusCreated, records[2].Status)
+	s.Assert().Equal([]AuditFilter{
+		{Name: filterReasonOwner, Passed: true},
+		{Name: filterReasonOutdated, Passed: true},
+		{Name: filterReasonNoRecipients, Passed: true},
+	}, records[2].Filters)
+	s.Assert().Equal([]AuditDelivery{
+		{ChatId: s.chatId, MessageId: 17},
+		{ChatId: 11, Error: "forbidden"},
+	}, records[2].Deliveries)
+}
+
+func (s *EngineSuite) TestAuditFailedCycle() {
+	ctx := context.Background()
+	auditDao := &dryRunAuditDao{dao: NewAuditDao(Config{})}
+	s.engine.auditDao = auditDao
+	s.engine.cfg.CatchUpThreshold = 1
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Id: "a", Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+		{Id: "b", Title: "Dinner", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return(events, nil)
+	s.telCliMock.On("NotifyCatchUp", s.chatId, lastChecked, events).Return(errors.New("too many requests"))
+
+	// SUT
+	s.Require().Error(s.engine.Work(ctx))
+
+	records, err := auditDao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Require().Len(records, 2)
+	for _, record := range records {
+		s.Assert().Equal([]AuditDelivery{{ChatId: s.chatId, Summarized: true, Error: "too many requests"}}, record.Deliveries)
+		s.Assert().Equal("failed", record.Outcome())
+	}
+}
+
+func (s *EngineSuite) TestEmailRecipients() {
+	ctx := context.Background()
+	mailerMock := &MailerMock{}
+	auditDao := &dryRunAuditDao{dao: NewAuditDao(Config{})}
+	grandma := EmailRecipient{Email: "grandma@example.com"}
+	grandpa := EmailRecipient{Email: "grandpa@example.com", Keywords: []string{"dinner"}}
+	digest := EmailRecipient{Email: "aunt@example.com", Digest: true}
+	s.engine.mailer = mailerMock
+	s.engine.emailRecipients = []EmailRecipient{grandma, grandpa, digest}
+	s.engine.auditDao = auditDao
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Id: "dinner", Title: "Dinner", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+		{Id: "dentist", Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return(events, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, mock.Anything).Return(nil)
+	mailerMock.On("NotifyEvent", grandma, mock.Anything).Return(nil)
+	mailerMock.On("NotifyEvent", grandpa, events[0]).Return(errors.New("mailbox full"))
+	mailerMock.On("NotifyDigest", digest, events).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	mailerMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 3)
+	mailerMock.AssertNumberOfCalls(s.T(), "NotifyDigest", 1)
+	records, err := auditDao.Query(AuditQuery{})
+	s.Require().NoError(err)
+	s.Require().Len(records, 2)
+	s.Assert().Equal([]AuditDelivery{
+		{ChatId: s.chatId},
+		{Email: "grandma@example.com"},
+		{Email: "grandpa@example.com", Deferred: true, Error: "mailbox full"},
+		{Email: "aunt@example.com", Summarized: true},
+	}, records[0].Deliveries)
+	s.Assert().Equal([]AuditDelivery{
+		{ChatId: s.chatId},
+		{Email: "grandma@example.com"},
+		{Email: "aunt@example.com", Summarized: true},
+	}, records[1].Deliveries)
+	outbox, err := s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Equal([]OutboxEntry{{Email: "grandpa@example.com", Event: events[0], QueuedAt: s.clock.Now()}}, outbox)
+}
+
+func (s *EngineSuite) TestEmailRetriedOnNextCycle() {
+	ctx := context.Background()
+	mailerMock := &MailerMock{}
+	grandma := EmailRecipient{Email: "grandma@example.com"}
+	s.engine.mailer = mailerMock
+	s.engine.emailRecipients = []EmailRecipient{grandma}
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	dinner := CalendarEvent{Id: "dinner", Title: "Dinner", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	dentist := CalendarEvent{Id: "dentist", Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return([]CalendarEvent{dinner}, nil).Once()
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, s.clock.Now()).Return([]CalendarEvent{dentist}, nil).Once()
+	s.telCliMock.On("NotifyEvent", s.chatId, mock.Anything).Return(nil)
+	mailerMock.On("NotifyEvent", grandma, dinner).Return(errors.New("try again later")).Once()
+	mailerMock.On("NotifyEvent", grandma, mock.Anything).Return(nil)
+
+	// SUT - the email fails, and goes out with the next one
+	s.Require().NoError(s.engine.Work(ctx))
+	s.Require().NoError(s.engine.Work(ctx))
+
+	mailerMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 3)
+	mailerMock.AssertCalled(s.T(), "NotifyEvent", grandma, dentist)
+	outbox, err := s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Empty(outbox)
+}
+
+func (s *EngineSuite) TestEmailServerUnavailable() {
+	ctx := context.Background()
+	mailerMock := &MailerMock{}
+	grandma := EmailRecipient{Email: "grandma@example.com"}
+	grandpa := EmailRecipient{Email: "grandpa@example.com"}
+	s.engine.mailer = mailerMock
+	s.engine.emailRecipients = []EmailRecipient{grandma, grandpa}
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	start := s.clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Id: "dinner", Title: "Dinner", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+		{Id: "dentist", Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "someone else"},
+	}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return(events, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, mock.Anything).Return(nil)
+	mailerMock.On("NotifyEvent", grandma, events[0]).Return(smtpUnavailableError{errors.New("connection refused")})
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	// nothing else is tried once the server can't be reached
+	mailerMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 1)
+	outbox, err := s.outboxDao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Len(outbox, 4)
+}
+
+func (s *EngineSuite) TestBackfill() {
+	ctx := context.Background()
+	s.engine.cfg.MaxLookBack = 24 * time.Hour
+	lastChecked := s.clock.Now().Add(-time.Minute)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	since := s.clock.Now().Add(-72 * time.Hour)
+	start := s.clock.Now().Add(24 * time.Hour)
+	event := CalendarEvent{Title: "Replayed", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, since).Return([]CalendarEvent{event}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, event).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Backfill(ctx, since))
+
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, event)
+	newLastChecked, _, err := s.lastChkdDao.GetLastChecked()
+	s.Require().NoError(err)
+	s.Assert().True(lastChecked.Equal(newLastChecked))
+}
+
+func (s *EngineSuite) TestBackfillSinceLastChecked() {
+	ctx := context.Background()
+	lastChecked := s.clock.Now().Add(-2 * time.Hour)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(lastChecked))
+	since := s.clock.Now().Add(-time.Hour)
+	start := s.clock.Now().Add(24 * time.Hour)
+	missed := CalendarEvent{Title: "Missed", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, since).Return([]CalendarEvent{}, nil).Once()
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, lastChecked).Return([]CalendarEvent{missed}, nil).Once()
+	s.telCliMock.On("NotifyEvent", s.chatId, missed).Return(nil)
+
+	// SUT - the changes made before the backfill's start aren't skipped
+	s.Require().NoError(s.engine.Backfill(ctx, since))
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, missed)
+}
+
+func (s *EngineSuite) TestDetectConflicts() {
+	ctx := context.Background()
+	s.engine.cfg.DetectConflicts = true
+	s.engine.conflicts = newConflictDetector(s.engine.cfg, s.calSvcMock)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := s.clock.Now().Add(24 * time.Hour)
+	event := CalendarEvent{Id: "new", Title: "Football", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	conflict := CalendarEvent{Id: "old", Title: "Swimming", Start: start, End: start.Add(time.Hour)}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{event}, nil)
+	s.calSvcMock.On("GetEvents", ctx, s.calendarId, event.Start, event.End).Return([]CalendarEvent{event, conflict}, nil)
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	event.Conflicts = []CalendarEvent{conflict}
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, event)
+}
+
+func (s *EngineSuite) TestDetectConflictsFailure() {
+	ctx := context.Background()
+	s.engine.cfg.DetectConflicts = true
+	s.engine.conflicts = newConflictDetector(s.engine.cfg, s.calSvcMock)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := s.clock.Now().Add(24 * time.Hour)
+	event := CalendarEvent{Id: "new", Title: "Football", Start: start, End: start.Add(time.Hour), Creator: "someone else"}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{event}, nil)
+	s.calSvcMock.On("GetEvents", ctx, s.calendarId, event.Start, event.End).Return([]CalendarEvent{}, errors.New("quota exceeded"))
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// SUT - the notification goes out without the warning
+	s.Require().NoError(s.engine.Work(ctx))
+
+	s.telCliMock.AssertCalled(s.T(), "NotifyEvent", s.chatId, event)
+}
+
+func (s *EngineSuite) TestModeration() {
+	ctx := context.Background()
+	modFile := fmt.Sprintf("%s_%d.json", "test_engine_moderation", time.Now().Unix())
+	defer func() { _ = os.Remove(modFile) }()
+	s.engine.cfg.ModerationChatId = -500
+	s.engine.modDao = NewModerationDao(Config{ModerationFile: modFile}, s.clock)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := s.clock.Now().Add(24 * time.Hour)
+	created := CalendarEvent{Id: "created", Title: "Party", Start: start, End: start.Add(time.Hour), Creator: "kid@example.com", Status: StatusCreated}
+	updated := CalendarEvent{Id: "updated", Title: "Dentist", Start: start, End: start.Add(time.Hour), Creator: "kid@example.com", Status: StatusUpdated}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{created, updated}, nil)
+	s.telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("SendChoices", int64(-500), mock.Anything, mock.Anything).Return(nil)
+
+	// SUT
+	s.Require().NoError(s.engine.Work(ctx))
+
+	// new events are announced as usual, and also sent for approval
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertNumberOfCalls(s.T(), "SendChoices", 1)
+	req, ok, err := s.engine.modDao.GetRequest(1)
+	s.Require().NoError(err)
+	s.Require().True(ok)
+	s.Assert().Equal("created", req.Event.Id)
+	s.telCliMock.AssertCalled(s.T(), "SendChoices", int64(-500), mock.Anything, moderationChoices(req))
+}
+
+func (s *EngineSuite) TestModerationRetriedCycle() {
+	ctx := context.Background()
+	modFile := fmt.Sprintf("%s_%d.json", "test_engine_moderation_retry", time.Now().Unix())
+	defer func() { _ = os.Remove(modFile) }()
+	s.engine.cfg.ModerationChatId = -500
+	s.engine.modDao = NewModerationDao(Config{ModerationFile: modFile}, s.clock)
+	s.Require().NoError(s.lastChkdDao.SetLastChecked(s.clock.Now().Add(-time.Minute)))
+	start := s.clock.Now().Add(24 * time.Hour)
+	created := CalendarEvent{Id: "created", CalendarId: s.calendarId, Title: "Party", Start: start, End: start.Add(time.Hour), Creator: "kid@example.com", Status: StatusCreated}
+	s.calSvcMock.On("GetRecentEvents", ctx, s.calendarId, mock.Anything).Return([]CalendarEvent{created}, nil)
+	s.telCliMock.On("NotifyEvent", s.chatId, created).Return(errors.New("too many requests")).Once()
+	s.telCliMock.On("NotifyEvent", s.chatId, created).Return(nil)
+	s.telCliMock.On("SendChoices", int64(-500), mock.Anything, mock.Anything).Return(nil)
+
+	// SUT - the group chat can't be notified, so the cycle is retried
+	s.Require().Error(s.engine.Work(ctx))
+	s.Require().NoError(s.engine.Work(ctx))
+
+	// the event is sent for approval once
+	s.telCliMock.AssertNumberOfCalls(s.T(), "NotifyEvent", 2)
+	s.telCliMock.AssertNumberOfCalls(s.T(), "SendChoices", 1)
+	_, ok, err := s.engine.modDao.GetRequest(2)
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const freeHelp = `מציאת זמן שבו כל היומנים פנויים:
+
+/free [משך] [אחרי HH:MM] [לפני HH:MM] [היום|השבוע]
+למשל: /free 90m אחרי 18:00`
+
+// maxFreeSlots is the most slots proposed at once, to keep the buttons
+// manageable.
+const maxFreeSlots = 6
+
+// tentativeTitle is the title of the events booked through /free, to be
+// renamed by whoever takes it from there.
+const tentativeTitle = "אירוע מוצע"
+
+// freeCommands finds open slots across the watched calendars and books them
+// as tentative events on the primary calendar.
+type freeCommands struct {
+	// config returns the current configuration, which changes on reload.
+	config func() Config
+	calSvc CalendarService
+	telcli Telegram
+	clock  Clock
+	loc    *time.Location
+	people peopleDirectory
+}
+
+func (c *freeCommands) Register(d *Dispatcher) {
+	d.HandleCommand("free", c.free)
+	d.HandleCallback("free", c.book)
+}
+
+func (c *freeCommands) free(ctx context.Context, msg *tgbotapi.Message) error {
+	if !allowedChat(c.config(), c.people, msg.Chat, msg.From) {
+		return c.telcli.SendText(msg.Chat.ID, "אין הרשאה")
+	}
+
+	q, err := parseFreeQuery(msg.CommandArguments())
+	if err != nil {
+		return c.telcli.SendText(msg.Chat.ID, err.Error()+"\n\n"+freeHelp)
+	}
+
+	now := c.clock.Now().In(c.loc)
+	busy, err := c.calSvc.GetBusy(ctx, c.config().Calendars(), now, now.AddDate(0, 0, q.days))
+	if err != nil {
+		return errors.Wrap(err, "error getting free/busy")
+	}
+
+	slots := findFreeSlots(q, busy, now, maxFreeSlots)
+	if len(slots) == 0 {
+		return c.telcli.SendText(msg.Chat.ID, "לא מצאתי זמן שבו כולם פנויים 😕")
+	}
+
+	choices := make([]Choice, 0, len(slots))
+	for _, slot := range slots {
+		choices = append(choices, Choice{Text: slot.String(), Data: freeCallbackData(slot)})
+	}
+	text := fmt.Sprintf("זמנים פנויים ל%s בין %s ל-%s. לחיצה על זמן תיצור אירוע מוצע ביומן:",
+		FormatDuration(q.length), q.from, q.to)
+	return c.telcli.SendChoices(msg.Chat.ID, text, choices)
+}
+
+// book creates a tentative event in the chosen slot, unless it was taken in
+// the meantime.
+func (c *freeCommands) book(ctx context.Context, query *tgbotapi.CallbackQuery) error {
+	var chat *tgbotapi.Chat
+	if query.Message != nil {
+		chat = query.Message.Chat
+	}
+	if !allowedChat(c.config(), c.people, chat, query.From) {
+		return c.telcli.AnswerCallback(query.ID, "אין הרשאה")
+	}
+
+	slot, err := parseFreeCallbackData(query.Data, c.loc)
+	if err != nil {
+		return err
+	}
+
+	busy, err := c.calSvc.GetBusy(ctx, c.config().Calendars(), slot.Start, slot.End)
+	if err != nil {
+		return errors.Wrap(err, "error getting free/busy")
+	}
+	if len(busy) > 0 {
+		return c.telcli.AnswerCallback(query.ID, "הזמן הזה כבר לא פנוי")
+	}
+
+	event := CalendarEvent{Title: tentativeTitle, Start: slot.Start, End: slot.End, Tentative: true}
+	if _, err := c.calSvc.CreateEvent(ctx, c.config().CalendarId, event); err != nil {
+		return errors.Wrap(err, "error creating event")
+	}
+
+	if err := c.telcli.AnswerCallback(query.ID, "האירוע נוצר"); err != nil {
+		return err
+	}
+	if query.Message == nil {
+		return nil
+	}
+
+	by := ""
+	if query.From != nil {
+		by = " על ידי " + strings.TrimSpace(query.From.FirstName+" "+query.From.LastName)
+	}
+	return c.telcli.SendText(query.Message.Chat.ID, fmt.Sprintf("✅ נוצר אירוע מוצע ב%s%s", slot, by))
+}
+
+// freeCallbackData encodes a slot as "free:<start unix time>:<minutes>", well
+// within the 64 bytes Telegram allows.
+func freeCallbackData(slot TimeRange) string {
+	return fmt.Sprintf("free:%d:%d", slot.Start.Unix(), int(slot.End.Sub(slot.Start)/time.Minute))
+}
+
+func parseFreeCallbackData(data string, loc *time.Location) (TimeRange, error) {
+	fields := strings.Split(data, ":")
+	if len(fields) != 3 || fields[0] != "free" {
+		return TimeRange{}, fmt.Errorf("invalid callback data: %q", data)
+	}
+	start, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return TimeRange{}, errors.Wrapf(err, "invalid callback data: %q", data)
+	}
+	minutes, err := strconv.Atoi(fields[2])
+	if err != nil || minutes <= 0 {
+		return TimeRange{}, fmt.Errorf("invalid callback data: %q", data)
+	}
+
+	startTime := time.Unix(start, 0).In(loc)
+	return TimeRange{Start: startTime, End: startTime.Add(time.Duration(minutes) * time.Minute)}, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type FreeCommandsSuite struct {
+	suite.Suite
+	tz         *time.Location
+	now        time.Time
+	calSvcMock *CalendarServiceMock
+	telCliMock *TelegramClientMock
+	dispatcher *Dispatcher
+}
+
+func TestFreeCommandsSuite(t *testing.T) {
+	suite.Run(t, new(FreeCommandsSuite))
+}
+
+func (s *FreeCommandsSuite) SetupTest() {
+	var err error
+	s.tz, err = time.LoadLocation("Asia/Jerusalem")
+	s.Require().NoError(err)
+	// Monday
+	s.now = time.Date(2024, time.June, 3, 10, 0, 0, 0, s.tz)
+	cfg := Config{CalendarId: "family", ExtraCalendarIds: []string{"kids"}, TelegramChatId: -100}
+	s.calSvcMock = &CalendarServiceMock{}
+	s.telCliMock = &TelegramClientMock{}
+	s.telCliMock.On("SendText", mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("SendChoices", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("AnswerCallback", mock.Anything, mock.Anything).Return(nil)
+	s.dispatcher = NewDispatcher()
+	cmds := freeCommands{config: func() Config { return cfg }, calSvc: s.calSvcMock, telcli: s.telCliMock, clock: NewFakeClock(s.now), loc: s.tz}
+	cmds.Register(s.dispatcher)
+}
+
+func (s *FreeCommandsSuite) TestProposeSlots() {
+	ctx := context.Background()
+	s.calSvcMock.On("GetBusy", ctx, []string{"family", "kids"}, s.now, s.now.AddDate(0, 0, 1)).Return([]TimeRange{
+		{Start: time.Date(2024, time.June, 3, 18, 0, 0, 0, s.tz), End: time.Date(2024, time.June, 3, 20, 30, 0, 0, s.tz)},
+	}, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, tgbotapi.Update{Message: commandMessage(-100, "group", "/free 90m אחרי 18:00 לפני 22:30 היום")})
+
+	s.telCliMock.AssertCalled(s.T(), "SendChoices", int64(-100), mock.Anything,
+		[]Choice{{Text: "יום שני 3.6 20:30–22:00", Data: "free:1717435800:90"}})
+}
+
+func (s *FreeCommandsSuite) TestProposeSlotsThisWeek() {
+	ctx := context.Background()
+	s.calSvcMock.On("GetBusy", ctx, []string{"family", "kids"}, s.now, s.now.AddDate(0, 0, 7)).Return([]TimeRange{
+		{Start: time.Date(2024, time.June, 3, 18, 0, 0, 0, s.tz), End: time.Date(2024, time.June, 3, 20, 30, 0, 0, s.tz)},
+	}, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, tgbotapi.Update{Message: commandMessage(-100, "group", "/free 90m אחרי 18:00")})
+
+	s.telCliMock.AssertCalled(s.T(), "SendChoices", int64(-100),
+		"זמנים פנויים לשעה וחצי בין 18:00 ל-22:00. לחיצה על זמן תיצור אירוע מוצע ביומן:",
+		[]Choice{
+			{Text: "יום שני 3.6 20:30–22:00", Data: "free:1717435800:90"},
+			{Text: "יום שלישי 4.6 18:00–19:30", Data: "free:1717513200:90"},
+			{Text: "יום רביעי 5.6 18:00–19:30", Data: "free:1717599600:90"},
+			{Text: "יום חמישי 6.6 18:00–19:30", Data: "free:1717686000:90"},
+			{Text: "יום שישי 7.6 18:00–19:30", Data: "free:1717772400:90"},
+			{Text: "שבת 8.6 18:00–19:30", Data: "free:1717858800:90"},
+		})
+}
+
+func (s *FreeCommandsSuite) TestNoSlots() {
+	ctx := context.Background()
+	s.calSvcMock.On("GetBusy", ctx, mock.Anything, mock.Anything, mock.Anything).Return([]TimeRange{
+		{Start: s.now, End: s.now.AddDate(0, 0, 1)},
+	}, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, tgbotapi.Update{Message: commandMessage(-100, "group", "/free היום")})
+
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "לא מצאתי זמן שבו כולם פנויים 😕")
+	s.telCliMock.AssertNotCalled(s.T(), "SendChoices", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *FreeCommandsSuite) TestBook() {
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 4, 18, 0, 0, 0, s.tz)
+	end := start.Add(90 * time.Minute)
+	s.calSvcMock.On("GetBusy", ctx, []string{"family", "kids"}, start, end).Return([]TimeRange{}, nil)
+	expected := CalendarEvent{Title: tentativeTitle, Start: start, End: end, Tentative: true}
+	s.calSvcMock.On("CreateEvent", ctx, "family", expected).Return(expected, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, s.callback(freeCallbackData(TimeRange{Start: start, End: end})))
+
+	s.calSvcMock.AssertCalled(s.T(), "CreateEvent", ctx, "family", expected)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "האירוע נוצר")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "✅ נוצר אירוע מוצע ביום שלישי 4.6 18:00–19:30 על ידי Dana")
+}
+
+func (s *FreeCommandsSuite) TestBookTakenSlot() {
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 4, 18, 0, 0, 0, s.tz)
+	end := start.Add(time.Hour)
+	s.calSvcMock.On("GetBusy", ctx, mock.Anything, start, end).Return([]TimeRange{{Start: start, End: end}}, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, s.callback(freeCallbackData(TimeRange{Start: start, End: end})))
+
+	s.calSvcMock.AssertNotCalled(s.T(), "CreateEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "הזמן הזה כבר לא פנוי")
+}
+
+func (s *FreeCommandsSuite) TestUnknownChat() {
+	ctx := context.Background()
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, tgbotapi.Update{Message: commandMessage(-200, "group", "/free היום")})
+	s.dispatcher.HandleUpdate(ctx, tgbotapi.Update{Message: commandMessage(9, "private", "/free היום")})
+
+	s.calSvcMock.AssertNotCalled(s.T(), "GetBusy", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-200), "אין הרשאה")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(9), "אין הרשאה")
+}
+
+func (s *FreeCommandsSuite) TestBookFromUnknownChat() {
+	ctx := context.Background()
+	start := time.Date(2024, time.June, 4, 18, 0, 0, 0, s.tz)
+	update := s.callback(freeCallbackData(TimeRange{Start: start, End: start.Add(time.Hour)}))
+	update.CallbackQuery.Message.Chat.ID = -200
+
+	// SUT
+	s.dispatcher.HandleUpdate(ctx, update)
+
+	s.calSvcMock.AssertNotCalled(s.T(), "CreateEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "אין הרשאה")
+}
+
+func (s *FreeCommandsSuite) callback(data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "query",
+		From:    &tgbotapi.User{ID: 7, FirstName: "Dana"},
+		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100, Type: "group"}},
+		Data:    data,
+	}}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TimeRange is a period of time, from Start up to End.
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (r TimeRange) String() string {
+	return fmt.Sprintf("%s %s", absoluteDay(r.Start, r.Start), timeRange(r.Start, r.End.In(r.Start.Location())))
+}
+
+// slotGranularity is what proposed slots are rounded to, so nobody's asked
+// to meet at 18:07.
+const slotGranularity = 15 * time.Minute
+
+// freeQuery is what the /free command looks for: slots of a given length,
+// between certain hours of the day, within the next few days.
+type freeQuery struct {
+	length time.Duration
+	from   clockTime
+	to     clockTime
+	days   int
+}
+
+var defaultFreeQuery = freeQuery{
+	length: time.Hour,
+	from:   clockTime{hour: 8},
+	to:     clockTime{hour: 22},
+	days:   7,
+}
+
+// parseFreeQuery parses the arguments of the /free command, e.g.
+// "90m אחרי 18:00". Anything not given is taken from defaultFreeQuery.
+func parseFreeQuery(args string) (freeQuery, error) {
+	q := defaultFreeQuery
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		field := strings.ToLower(fields[i])
+		switch field {
+		case "אחרי", "after", "לפני", "before":
+			if i+1 == len(fields) {
+				return freeQuery{}, fmt.Errorf("חסרה שעה אחרי %q", fields[i])
+			}
+			i++
+			t, err := parseClockTime(fields[i])
+			if err != nil {
+				return freeQuery{}, fmt.Errorf("שעה לא תקינה: %s", fields[i])
+			}
+			if field == "אחרי" || field == "after" {
+				q.from = t
+			} else {
+				q.to = t
+			}
+		case "היום", "today":
+			q.days = 1
+		case "השבוע", "week":
+			q.days = 7
+		default:
+			d, err := time.ParseDuration(field)
+			if err != nil || d <= 0 {
+				return freeQuery{}, fmt.Errorf("לא הבנתי את %q", fields[i])
+			}
+			q.length = d
+		}
+	}
+
+	if !q.from.before(q.to) {
+		return freeQuery{}, fmt.Errorf("טווח שעות לא תקין: %s-%s", q.from, q.to)
+	}
+
+	return q, nil
+}
+
+// findFreeSlots proposes up to max slots of the query's length, starting at
+// now, that don't overlap any of the busy periods. Each gap between busy
+// periods is proposed once, at its beginning.
+func findFreeSlots(q freeQuery, busy []TimeRange, now time.Time, max int) []TimeRange {
+	busy = slices.Clone(busy)
+	slices.SortFunc(busy, func(a, b TimeRange) int { return a.Start.Compare(b.Start) })
+
+	var slots []TimeRange
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for i := 0; i < q.days && len(slots) < max; i, day = i+1, day.AddDate(0, 0, 1) {
+		start, end := q.from.on(day), q.to.on(day)
+		if start.Before(now) {
+			start = now
+		}
+
+		cursor := roundUp(start, slotGranularity)
+		for _, b := range busy {
+			if !b.End.After(cursor) || !b.Start.Before(end) {
+				continue
+			}
+			if b.Start.Sub(cursor) >= q.length {
+				slots = append(slots, TimeRange{Start: cursor, End: cursor.Add(q.length)})
+			}
+			cursor = roundUp(b.End, slotGranularity)
+		}
+		if end.Sub(cursor) >= q.length {
+			slots = append(slots, TimeRange{Start: cursor, End: cursor.Add(q.length)})
+		}
+	}
+
+	if len(slots) > max {
+		slots = slots[:max]
+	}
+	return slots
+}
+
+func roundUp(t time.Time, d time.Duration) time.Time {
+	if rounded := t.Truncate(d); !rounded.Equal(t) {
+		return rounded.Add(d)
+	}
+	return t
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFreeQuery(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected freeQuery
+	}{
+		{"", defaultFreeQuery},
+		{"90m", freeQuery{length: 90 * time.Minute, from: clockTime{hour: 8}, to: clockTime{hour: 22}, days: 7}},
+		{"השבוע אחרי 18:00", freeQuery{length: time.Hour, from: clockTime{hour: 18}, to: clockTime{hour: 22}, days: 7}},
+		{"2h after 9:30 before 13:00 today", freeQuery{length: 2 * time.Hour, from: clockTime{hour: 9, minute: 30}, to: clockTime{hour: 13}, days: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			actual, err := parseFreeQuery(test.args)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	for _, args := range []string{"אחרי", "אחרי 25:00", "מחרתיים", "-1h", "אחרי 20:00 לפני 18:00"} {
+		t.Run("invalid "+args, func(t *testing.T) {
+			_, err := parseFreeQuery(args)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFindFreeSlots(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, tz)
+	}
+	q := freeQuery{length: time.Hour, from: clockTime{hour: 18}, to: clockTime{hour: 22}, days: 3}
+	busy := []TimeRange{
+		// out of order, and overlapping
+		{Start: at(3, 19, 30), End: at(3, 20, 10)},
+		{Start: at(3, 18, 30), End: at(3, 19, 45)},
+		// the whole evening
+		{Start: at(4, 12, 0), End: at(4, 23, 0)},
+		{Start: at(5, 19, 0), End: at(5, 20, 0)},
+	}
+
+	// SUT
+	slots := findFreeSlots(q, busy, at(3, 17, 55), 10)
+
+	assert.Equal(t, []TimeRange{
+		{Start: at(3, 20, 15), End: at(3, 21, 15)},
+		{Start: at(5, 18, 0), End: at(5, 19, 0)},
+		{Start: at(5, 20, 0), End: at(5, 21, 0)},
+	}, slots)
+}
+
+func TestFindFreeSlotsStartsNow(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	now := time.Date(2024, time.June, 3, 20, 50, 0, 0, tz)
+	q := freeQuery{length: time.Hour, from: clockTime{hour: 18}, to: clockTime{hour: 22}, days: 2}
+
+	// SUT
+	slots := findFreeSlots(q, nil, now, 1)
+
+	assert.Equal(t, []TimeRange{{Start: time.Date(2024, time.June, 3, 21, 0, 0, 0, tz), End: time.Date(2024, time.June, 3, 22, 0, 0, 0, tz)}}, slots)
+}
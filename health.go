@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Health follows the outcome of the engine's cycles, for the health endpoints
+// and the watchdog. The watchdog alerts the admin chat once the cycles have
+// been failing for WatchdogThreshold, and again when they recover. The methods
+// recording cycles can be called on a nil *Health, which records nothing.
+type Health struct {
+	telcli Telegram
+	clock  Clock
+
+	mu           sync.Mutex
+	threshold    time.Duration
+	lastSuccess  time.Time
+	failingSince time.Time
+	lastErr      error
+	// alertedChat is the chat the watchdog alerted about the current
+	// failures, if it did.
+	alertedChat int64
+}
+
+func NewHealth(cfg Config, telcli Telegram, clock Clock) *Health {
+	return &Health{
+		telcli:    telcli,
+		clock:     clock,
+		threshold: cfg.WatchdogThreshold,
+	}
+}
+
+// observe records the outcome of a cycle that ended at now, alerting the admin
+// chat of cfg as needed.
+func (h *Health) observe(cfg Config, now time.Time, err error) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.threshold = cfg.WatchdogThreshold
+	if err == nil {
+		failedFor := now.Sub(h.failingSince)
+		alertedChat := h.alertedChat
+		h.lastSuccess, h.failingSince, h.lastErr, h.alertedChat = now, time.Time{}, nil, 0
+		h.mu.Unlock()
+
+		if alertedChat != 0 {
+			log.WithField("failedFor", failedFor).Info("cycles recovered")
+			h.send(alertedChat, fmt.Sprintf("✅ בדיקת היומנים חזרה לעבוד אחרי %s של תקלות.", FormatDuration(failedFor)))
+		}
+		return
+	}
+
+	if h.failingSince.IsZero() {
+		h.failingSince = now
+	}
+	h.lastErr = err
+	failingFor := now.Sub(h.failingSince)
+	alert := h.alertedChat == 0 && cfg.AdminChatId != 0 && cfg.WatchdogThreshold > 0 && failingFor >= cfg.WatchdogThreshold
+	if alert {
+		h.alertedChat = cfg.AdminChatId
+	}
+	h.mu.Unlock()
+
+	if !alert {
+		return
+	}
+
+	log.WithError(err).WithField("failingFor", failingFor).Error("cycles keep failing, alerting the admin")
+	text := fmt.Sprintf("🚨 בדיקת היומנים נכשלת כבר %s:\n%s", FormatDuration(failingFor), err)
+	if credentialsError(err) {
+		text += "\nנראה שההרשאה לגשת ליומן פגה או בוטלה, למשל מפתח של חשבון השירות שפג תוקפו או שיתוף יומן שהוסר."
+	}
+	if !h.send(cfg.AdminChatId, text) {
+		// try again on the next failure
+		h.mu.Lock()
+		h.alertedChat = 0
+		h.mu.Unlock()
+	}
+}
+
+func (h *Health) send(chatId int64, text string) bool {
+	if err := h.telcli.SendText(chatId, text); err != nil {
+		log.WithError(err).Error("error sending watchdog message")
+		return false
+	}
+	return true
+}
+
+// credentialsError reports whether the error means the bot isn't allowed to
+// read the calendars anymore. A calendar that's no longer shared with the
+// service account is reported as not found.
+func credentialsError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusNotFound
+	}
+	var tokenErr *oauth2.RetrieveError
+	return errors.As(err, &tokenErr)
+}
+
+type healthStatus struct {
+	Status                string     `json:"status"`
+	LastSuccess           *time.Time `json:"lastSuccess,omitempty"`
+	LastSuccessAgeSeconds *float64   `json:"lastSuccessAgeSeconds,omitempty"`
+	FailingSince          *time.Time `json:"failingSince,omitempty"`
+	LastError             string     `json:"lastError,omitempty"`
+	CredentialsValid      bool       `json:"credentialsValid"`
+
+	healthy bool
+	ready   bool
+}
+
+func (h *Health) status() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	s := healthStatus{
+		CredentialsValid: !credentialsError(h.lastErr),
+		ready:            !h.lastSuccess.IsZero() && h.failingSince.IsZero(),
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess, age := h.lastSuccess, now.Sub(h.lastSuccess).Seconds()
+		s.LastSuccess, s.LastSuccessAgeSeconds = &lastSuccess, &age
+	}
+	if !h.failingSince.IsZero() {
+		failingSince := h.failingSince
+		s.FailingSince = &failingSince
+		s.LastError = h.lastErr.Error()
+	}
+	failingTooLong := !h.failingSince.IsZero() && h.threshold > 0 && now.Sub(h.failingSince) >= h.threshold
+	s.healthy = s.CredentialsValid && !failingTooLong
+
+	s.Status = "ok"
+	if !s.healthy {
+		s.Status = "failing"
+	} else if !s.ready {
+		s.Status = "not ready"
+	}
+	return s
+}
+
+// LivenessHandler serves /healthz, which fails once the cycles have been
+// failing for WatchdogThreshold, or the calendar credentials were rejected.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := h.status()
+		writeHealthStatus(w, s, s.healthy)
+	})
+}
+
+// ReadinessHandler serves /readyz, which succeeds once a cycle succeeded and
+// as long as the last one did.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := h.status()
+		writeHealthStatus(w, s, s.ready)
+	})
+}
+
+func writeHealthStatus(w http.ResponseWriter, s healthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.WithError(err).Warn("error writing health status")
+	}
+}
+
+// httpShutdownTimeout bounds the wait for requests in progress when a server
+// stops.
+const httpShutdownTimeout = 5 * time.Second
+
+// ServeMonitoring serves /metrics, /healthz and /readyz on the address until
+// the context is done.
+func ServeMonitoring(ctx context.Context, addr string, m *Metrics, h *Health) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	mux.Handle("/healthz", h.LivenessHandler())
+	mux.Handle("/readyz", h.ReadinessHandler())
+	return serveHTTP(ctx, addr, mux, "metrics and health")
+}
+
+// serveHTTP serves the handler on the address until the context is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler, what string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "error listening for %s requests", what)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	log.WithField("addr", listener.Addr().String()).Info("serving " + what)
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		return err
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
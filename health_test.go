@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestWatchdog(t *testing.T) {
+	cfg := Config{AdminChatId: 7, WatchdogThreshold: 30 * time.Minute}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(7), mock.Anything).Return(nil)
+	health := NewHealth(cfg, telCliMock, clock)
+	failure := errors.New("error getting events: quota exceeded")
+
+	health.observe(cfg, clock.Now(), nil)
+	for range 4 {
+		clock.Advance(10 * time.Minute)
+		health.observe(cfg, clock.Now(), failure)
+	}
+	clock.Advance(10 * time.Minute)
+	health.observe(cfg, clock.Now(), nil)
+	clock.Advance(10 * time.Minute)
+	health.observe(cfg, clock.Now(), nil)
+
+	telCliMock.AssertNumberOfCalls(t, "SendText", 2)
+	telCliMock.AssertCalled(t, "SendText", int64(7), "🚨 בדיקת היומנים נכשלת כבר חצי שעה:\nerror getting events: quota exceeded")
+	telCliMock.AssertCalled(t, "SendText", int64(7), "✅ בדיקת היומנים חזרה לעבוד אחרי 40 דקות של תקלות.")
+}
+
+func TestWatchdogRetriesFailedAlert(t *testing.T) {
+	cfg := Config{AdminChatId: 7, WatchdogThreshold: time.Minute}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(7), mock.Anything).Return(errors.New("telegram is down")).Once()
+	telCliMock.On("SendText", int64(7), mock.Anything).Return(nil)
+	health := NewHealth(cfg, telCliMock, clock)
+	failure := errors.New("failure")
+
+	for range 4 {
+		health.observe(cfg, clock.Now(), failure)
+		clock.Advance(time.Minute)
+	}
+
+	// the first alert failed, the second one went through
+	telCliMock.AssertNumberOfCalls(t, "SendText", 2)
+}
+
+func TestWatchdogWithoutAdminChat(t *testing.T) {
+	cfg := Config{WatchdogThreshold: time.Minute}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	telCliMock := &TelegramClientMock{}
+	health := NewHealth(cfg, telCliMock, clock)
+
+	health.observe(cfg, clock.Now(), errors.New("failure"))
+	clock.Advance(time.Hour)
+	health.observe(cfg, clock.Now(), errors.New("failure"))
+	health.observe(cfg, clock.Now(), nil)
+
+	telCliMock.AssertNotCalled(t, "SendText", mock.Anything, mock.Anything)
+}
+
+func TestWatchdogCredentialsAlert(t *testing.T) {
+	cfg := Config{AdminChatId: 7, WatchdogThreshold: time.Minute}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(7), mock.Anything).Return(nil)
+	health := NewHealth(cfg, telCliMock, clock)
+	failure := pkgerrors.Wrap(&oauth2.RetrieveError{ErrorCode: "invalid_grant"}, "error getting events")
+
+	health.observe(cfg, clock.Now(), failure)
+	clock.Advance(time.Minute)
+	health.observe(cfg, clock.Now(), failure)
+
+	telCliMock.AssertNumberOfCalls(t, "SendText", 1)
+	text := telCliMock.Calls[0].Arguments.String(1)
+	assert.Contains(t, text, "נראה שההרשאה לגשת ליומן פגה או בוטלה")
+}
+
+func TestCredentialsError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"no error", nil, false},
+		{"network error", errors.New("connection refused"), false},
+		{"server error", &googleapi.Error{Code: 500}, false},
+		{"unauthorized", pkgerrors.Wrap(&googleapi.Error{Code: 401}, "error getting events"), true},
+		{"calendar no longer shared", pkgerrors.Wrap(&googleapi.Error{Code: 404}, "error getting events"), true},
+		{"expired key", &oauth2.RetrieveError{ErrorCode: "invalid_grant"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, credentialsError(test.err))
+		})
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	cfg := Config{WatchdogThreshold: 30 * time.Minute}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	health := NewHealth(cfg, &TelegramClientMock{}, clock)
+	get := func(handler http.Handler) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	code, body := get(health.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code, "healthy before the first cycle")
+	assert.Equal(t, "not ready", body["status"])
+	code, _ = get(health.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code, "not ready before the first cycle")
+
+	health.observe(cfg, clock.Now(), nil)
+	clock.Advance(90 * time.Second)
+	code, body = get(health.ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+	assert.Equal(t, 90.0, body["lastSuccessAgeSeconds"])
+	assert.Equal(t, true, body["credentialsValid"])
+
+	health.observe(cfg, clock.Now(), errors.New("quota exceeded"))
+	code, body = get(health.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code, "not ready after a failure")
+	assert.Equal(t, "quota exceeded", body["lastError"])
+	code, _ = get(health.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code, "healthy while failing for less than the threshold")
+
+	clock.Advance(30 * time.Minute)
+	code, body = get(health.LivenessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "failing", body["status"])
+
+	health.observe(cfg, clock.Now(), &googleapi.Error{Code: 403})
+	_, body = get(health.LivenessHandler())
+	assert.Equal(t, false, body["credentialsValid"])
+}
+
+func TestEngineReportsHealth(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := Config{CalendarId: "family", TelegramChatId: 42, FirstRunLookBack: time.Hour}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetRecentEvents", ctx, "family", mock.Anything).Return([]CalendarEvent{}, &googleapi.Error{Code: 404}).Once()
+	calSvcMock.On("GetRecentEvents", ctx, "family", mock.Anything).Return([]CalendarEvent{}, nil)
+	health := NewHealth(cfg, &TelegramClientMock{}, clock)
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		lastChkdDao: NewLastCheckedDao(Config{LastCheckedFile: filepath.Join(dir, "last_checked.txt")}),
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   NewOutboxDao(Config{OutboxFile: filepath.Join(dir, "outbox.json")}),
+		clock:       clock,
+		health:      health,
+	}
+
+	require.Error(t, engine.Work(ctx))
+	s := health.status()
+	assert.False(t, s.ready)
+	assert.False(t, s.CredentialsValid)
+
+	require.NoError(t, engine.Work(ctx))
+	s = health.status()
+	assert.True(t, s.ready)
+	assert.True(t, s.CredentialsValid)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HebrewMonth numbers months the way the Hebrew calendar does, starting from
+// Nisan even though the year starts in Tishrei.
+type HebrewMonth int
+
+const (
+	Nisan HebrewMonth = iota + 1
+	Iyyar
+	Sivan
+	Tammuz
+	Av
+	Elul
+	Tishrei
+	Marheshvan
+	Kislev
+	Tevet
+	Shevat
+	Adar
+	AdarII
+)
+
+// HebrewDate is a date in the Hebrew calendar. In leap years Adar is Adar I.
+type HebrewDate struct {
+	Year  int
+	Month HebrewMonth
+	Day   int
+}
+
+// hebrewEpoch is the fixed day number (days since the Gregorian 0001-01-01,
+// which is day 1) of 1 Tishrei AM 1.
+const hebrewEpoch = -1373427
+
+// unixEpochFixed is the fixed day number of 1970-01-01.
+const unixEpochFixed = 719163
+
+// HebrewDateOf converts the calendar date of t, in t's own location. Note the
+// Hebrew day actually starts at the preceding sunset.
+func HebrewDateOf(t time.Time) HebrewDate {
+	return hebrewFromFixed(fixedFromTime(t))
+}
+
+// Time returns midnight of the Gregorian day on which the Hebrew date falls.
+func (d HebrewDate) Time(loc *time.Location) time.Time {
+	fixed := fixedFromHebrew(d.Year, d.Month, d.Day)
+	utc := time.Unix(int64(fixed-unixEpochFixed)*24*60*60, 0).UTC()
+	return time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, loc)
+}
+
+// AddDays returns the Hebrew date n days later.
+func (d HebrewDate) AddDays(n int) HebrewDate {
+	return hebrewFromFixed(fixedFromHebrew(d.Year, d.Month, d.Day) + n)
+}
+
+func fixedFromTime(t time.Time) int {
+	utc := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return int(utc.Unix()/(24*60*60)) + unixEpochFixed
+}
+
+func isHebrewLeapYear(year int) bool {
+	return (7*year+1)%19 < 7
+}
+
+func lastMonthOfHebrewYear(year int) HebrewMonth {
+	if isHebrewLeapYear(year) {
+		return AdarII
+	}
+	return Adar
+}
+
+// hebrewCalendarElapsedDays returns the number of days from the epoch to the
+// molad of Tishrei of the given year, after the first postponement rule.
+func hebrewCalendarElapsedDays(year int) int {
+	monthsElapsed := floorDiv(235*year-234, 19)
+	partsElapsed := 12084 + 13753*monthsElapsed
+	day := 29*monthsElapsed + floorDiv(partsElapsed, 25920)
+	if (3*(day+1))%7 < 3 {
+		return day + 1
+	}
+	return day
+}
+
+// hebrewYearLengthCorrection applies the remaining postponement rules, which
+// keep years within their allowed lengths.
+func hebrewYearLengthCorrection(year int) int {
+	ny0 := hebrewCalendarElapsedDays(year - 1)
+	ny1 := hebrewCalendarElapsedDays(year)
+	ny2 := hebrewCalendarElapsedDays(year + 1)
+
+	switch {
+	case ny2-ny1 == 356:
+		return 2
+	case ny1-ny0 == 382:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func hebrewNewYear(year int) int {
+	return hebrewEpoch + hebrewCalendarElapsedDays(year) + hebrewYearLengthCorrection(year)
+}
+
+func daysInHebrewYear(year int) int {
+	return hebrewNewYear(year+1) - hebrewNewYear(year)
+}
+
+func daysInHebrewMonth(year int, month HebrewMonth) int {
+	switch month {
+	case Iyyar, Tammuz, Elul, Tevet, AdarII:
+		return 29
+	case Adar:
+		if isHebrewLeapYear(year) {
+			return 30
+		}
+		return 29
+	case Marheshvan:
+		if daysInHebrewYear(year)%10 == 5 {
+			return 30
+		}
+		return 29
+	case Kislev:
+		if daysInHebrewYear(year)%10 == 3 {
+			return 29
+		}
+		return 30
+	default:
+		return 30
+	}
+}
+
+func fixedFromHebrew(year int, month HebrewMonth, day int) int {
+	days := day - 1
+	if month < Tishrei {
+		for m := Tishrei; m <= lastMonthOfHebrewYear(year); m++ {
+			days += daysInHebrewMonth(year, m)
+		}
+		for m := Nisan; m < month; m++ {
+			days += daysInHebrewMonth(year, m)
+		}
+	} else {
+		for m := Tishrei; m < month; m++ {
+			days += daysInHebrewMonth(year, m)
+		}
+	}
+
+	return hebrewNewYear(year) + days
+}
+
+func hebrewFromFixed(fixed int) HebrewDate {
+	approx := int(math.Floor(float64(fixed-hebrewEpoch)/(35975351.0/98496))) + 1
+	year := approx - 1
+	for hebrewNewYear(year+1) <= fixed {
+		year++
+	}
+
+	month := Nisan
+	if fixed < fixedFromHebrew(year, Nisan, 1) {
+		month = Tishrei
+	}
+	for fixed > fixedFromHebrew(year, month, daysInHebrewMonth(year, month)) {
+		month++
+	}
+
+	return HebrewDate{
+		Year:  year,
+		Month: month,
+		Day:   fixed - fixedFromHebrew(year, month, 1) + 1,
+	}
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a, b int) int {
+	return a - b*floorDiv(a, b)
+}
+
+func weekdayOfFixed(fixed int) time.Weekday {
+	return time.Weekday(floorMod(fixed, 7))
+}
+
+var hebrewMonthNames = map[HebrewMonth]string{
+	Nisan:      "ניסן",
+	Iyyar:      "אייר",
+	Sivan:      "סיוון",
+	Tammuz:     "תמוז",
+	Av:         "אב",
+	Elul:       "אלול",
+	Tishrei:    "תשרי",
+	Marheshvan: "חשוון",
+	Kislev:     "כסלו",
+	Tevet:      "טבת",
+	Shevat:     "שבט",
+	Adar:       "אדר",
+	AdarII:     "אדר ב׳",
+}
+
+// MonthName returns the Hebrew name of the date's month, telling Adar I and
+// Adar II apart in leap years.
+func (d HebrewDate) MonthName() string {
+	if d.Month == Adar && isHebrewLeapYear(d.Year) {
+		return "אדר א׳"
+	}
+	return hebrewMonthNames[d.Month]
+}
+
+// String formats the date the way it's written in Hebrew, e.g. "כ״ה בכסלו".
+func (d HebrewDate) String() string {
+	return hebrewNumeral(d.Day) + " ב" + d.MonthName()
+}
+
+var hebrewNumeralLetters = []struct {
+	value  int
+	letter string
+}{
+	{400, "ת"}, {300, "ש"}, {200, "ר"}, {100, "ק"},
+	{90, "צ"}, {80, "פ"}, {70, "ע"}, {60, "ס"}, {50, "נ"}, {40, "מ"}, {30, "ל"}, {20, "כ"}, {10, "י"},
+	{9, "ט"}, {8, "ח"}, {7, "ז"}, {6, "ו"}, {5, "ה"}, {4, "ד"}, {3, "ג"}, {2, "ב"}, {1, "א"},
+}
+
+// hebrewNumeral writes a positive number below 1000 in Hebrew letters, with a
+// geresh or gershayim, e.g. 25 is "כ״ה".
+func hebrewNumeral(n int) string {
+	var letters []string
+	for n > 0 {
+		// 15 and 16 are written as 9+6 and 9+7 to avoid spelling God's name
+		if n == 15 || n == 16 {
+			letters = append(letters, "ט", map[int]string{15: "ו", 16: "ז"}[n])
+			break
+		}
+		for _, l := range hebrewNumeralLetters {
+			if l.value <= n {
+				letters = append(letters, l.letter)
+				n -= l.value
+				break
+			}
+		}
+	}
+
+	if len(letters) == 1 {
+		return letters[0] + "׳"
+	}
+	return strings.Join(letters[:len(letters)-1], "") + "״" + letters[len(letters)-1]
+}
+
+// Holiday is a Jewish or Israeli holiday falling on a specific date.
+type Holiday struct {
+	Name string
+	// YomTov is set for days on which work is forbidden, like on Shabbat.
+	YomTov bool
+}
+
+// HolidaysOn returns the holidays falling on the given Hebrew date. Diaspora
+// communities keep an extra day for some of the festivals.
+func HolidaysOn(d HebrewDate, diaspora bool) []Holiday {
+	fixed := fixedFromHebrew(d.Year, d.Month, d.Day)
+
+	var holidays []Holiday
+	for _, rule := range holidayRules {
+		if rule.diasporaOnly && !diaspora || rule.israelOnly && diaspora {
+			continue
+		}
+		if slices.Contains(rule.dates(d.Year), fixed) {
+			holidays = append(holidays, Holiday{Name: rule.name, YomTov: rule.yomTov})
+		}
+	}
+
+	return holidays
+}
+
+// IsYomTov reports whether the given date is a festival on which work is
+// forbidden.
+func IsYomTov(d HebrewDate, diaspora bool) bool {
+	return slices.ContainsFunc(HolidaysOn(d, diaspora), func(h Holiday) bool { return h.YomTov })
+}
+
+type holidayRule struct {
+	name         string
+	yomTov       bool
+	diasporaOnly bool
+	israelOnly   bool
+	// dates returns the fixed days on which the holiday falls in a year
+	dates func(year int) []int
+}
+
+// on is a holiday falling on a fixed date.
+func on(month HebrewMonth, day int) func(int) []int {
+	return days(month, day, 1)
+}
+
+// days is a holiday lasting n days.
+func days(month HebrewMonth, day int, n int) func(int) []int {
+	return func(year int) []int {
+		first := fixedFromHebrew(year, month, day)
+		dates := make([]int, n)
+		for i := range dates {
+			dates[i] = first + i
+		}
+		return dates
+	}
+}
+
+// onAdar is a holiday in Adar, which is Adar II in leap years.
+func onAdar(day int) func(int) []int {
+	return func(year int) []int {
+		return []int{fixedFromHebrew(year, lastMonthOfHebrewYear(year), day)}
+	}
+}
+
+// fast is a fast day that is postponed to Sunday when it falls on Shabbat.
+func fast(month HebrewMonth, day int) func(int) []int {
+	return func(year int) []int {
+		fixed := fixedFromHebrew(year, month, day)
+		if weekdayOfFixed(fixed) == time.Saturday {
+			fixed++
+		}
+		return []int{fixed}
+	}
+}
+
+// taanitEsther is advanced to Thursday when it falls on Shabbat.
+func taanitEsther(year int) []int {
+	fixed := fixedFromHebrew(year, lastMonthOfHebrewYear(year), 13)
+	if weekdayOfFixed(fixed) == time.Saturday {
+		fixed -= 2
+	}
+	return []int{fixed}
+}
+
+// yomHashoah is moved so it is never adjacent to Shabbat.
+func yomHashoah(year int) []int {
+	fixed := fixedFromHebrew(year, Nisan, 27)
+	switch weekdayOfFixed(fixed) {
+	case time.Friday:
+		fixed--
+	case time.Sunday:
+		fixed++
+	}
+	return []int{fixed}
+}
+
+// yomHaatzmaut is moved so neither it nor Yom HaZikaron, the day before it,
+// is adjacent to Shabbat.
+func yomHaatzmaut(year int) []int {
+	fixed := fixedFromHebrew(year, Iyyar, 5)
+	switch weekdayOfFixed(fixed) {
+	case time.Friday:
+		fixed--
+	case time.Saturday:
+		fixed -= 2
+	case time.Monday:
+		fixed++
+	}
+	return []int{fixed}
+}
+
+func yomHazikaron(year int) []int {
+	return []int{yomHaatzmaut(year)[0] - 1}
+}
+
+var holidayRules = []holidayRule{
+	{name: "ראש השנה", yomTov: true, dates: days(Tishrei, 1, 2)},
+	{name: "צום גדליה", dates: fast(Tishrei, 3)},
+	{name: "יום כיפור", yomTov: true, dates: on(Tishrei, 10)},
+	{name: "סוכות", yomTov: true, dates: on(Tishrei, 15)},
+	{name: "סוכות", yomTov: true, diasporaOnly: true, dates: on(Tishrei, 16)},
+	{name: "חול המועד סוכות", israelOnly: true, dates: days(Tishrei, 16, 5)},
+	{name: "חול המועד סוכות", diasporaOnly: true, dates: days(Tishrei, 17, 4)},
+	{name: "הושענא רבה", dates: on(Tishrei, 21)},
+	{name: "שמיני עצרת ושמחת תורה", yomTov: true, israelOnly: true, dates: on(Tishrei, 22)},
+	{name: "שמיני עצרת", yomTov: true, diasporaOnly: true, dates: on(Tishrei, 22)},
+	{name: "שמחת תורה", yomTov: true, diasporaOnly: true, dates: on(Tishrei, 23)},
+	{name: "חנוכה", dates: days(Kislev, 25, 8)},
+	{name: "צום עשרה בטבת", dates: on(Tevet, 10)},
+	{name: "ט״ו בשבט", dates: on(Shevat, 15)},
+	{name: "תענית אסתר", dates: taanitEsther},
+	{name: "פורים", dates: onAdar(14)},
+	{name: "שושן פורים", dates: onAdar(15)},
+	{name: "פסח", yomTov: true, dates: on(Nisan, 15)},
+	{name: "פסח", yomTov: true, diasporaOnly: true, dates: on(Nisan, 16)},
+	{name: "חול המועד פסח", israelOnly: true, dates: days(Nisan, 16, 5)},
+	{name: "חול המועד פסח", diasporaOnly: true, dates: days(Nisan, 17, 4)},
+	{name: "שביעי של פסח", yomTov: true, dates: on(Nisan, 21)},
+	{name: "אחרון של פסח", yomTov: true, diasporaOnly: true, dates: on(Nisan, 22)},
+	{name: "יום השואה", dates: yomHashoah},
+	{name: "יום הזיכרון", dates: yomHazikaron},
+	{name: "יום העצמאות", dates: yomHaatzmaut},
+	{name: "ל״ג בעומר", dates: on(Iyyar, 18)},
+	{name: "יום ירושלים", dates: on(Iyyar, 28)},
+	{name: "שבועות", yomTov: true, dates: on(Sivan, 6)},
+	{name: "שבועות", yomTov: true, diasporaOnly: true, dates: on(Sivan, 7)},
+	{name: "צום י״ז בתמוז", dates: fast(Tammuz, 17)},
+	{name: "תשעה באב", dates: fast(Av, 9)},
+	{name: "ט״ו באב", dates: on(Av, 15)},
+}
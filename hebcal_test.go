@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHebrewDateOf(t *testing.T) {
+	tests := []struct {
+		gregorian string
+		expected  HebrewDate
+	}{
+		{"1948-05-14", HebrewDate{5708, Iyyar, 5}},
+		{"2000-01-01", HebrewDate{5760, Tevet, 23}},
+		{"2023-03-07", HebrewDate{5783, Adar, 14}},
+		{"2023-09-16", HebrewDate{5784, Tishrei, 1}},
+		{"2024-03-24", HebrewDate{5784, AdarII, 14}},
+		{"2024-04-23", HebrewDate{5784, Nisan, 15}},
+		{"2024-10-03", HebrewDate{5785, Tishrei, 1}},
+		{"2024-12-26", HebrewDate{5785, Kislev, 25}},
+		{"2025-06-02", HebrewDate{5785, Sivan, 6}},
+		{"2025-10-02", HebrewDate{5786, Tishrei, 10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.gregorian, func(t *testing.T) {
+			date := parseDateNoError(test.gregorian)
+			assert.Equal(t, test.expected, HebrewDateOf(date))
+			assert.Equal(t, date, test.expected.Time(time.UTC))
+		})
+	}
+}
+
+func TestHebrewDateRoundTrip(t *testing.T) {
+	date := parseDateNoError("1990-01-01")
+	for i := 0; i < 365*60; i++ {
+		day := date.AddDate(0, 0, i)
+		hebrew := HebrewDateOf(day)
+		if !assert.Equal(t, day, hebrew.Time(time.UTC), "%s -> %+v", day, hebrew) {
+			return
+		}
+		if !assert.LessOrEqual(t, hebrew.Month, lastMonthOfHebrewYear(hebrew.Year)) ||
+			!assert.LessOrEqual(t, hebrew.Day, daysInHebrewMonth(hebrew.Year, hebrew.Month)) {
+			return
+		}
+
+		prev := HebrewDateOf(day.AddDate(0, 0, -1))
+		if hebrew.Day > 1 && !assert.Equal(t, HebrewDate{prev.Year, prev.Month, prev.Day + 1}, hebrew) {
+			return
+		}
+		if hebrew.Day == 1 && !assert.Contains(t, []int{29, 30}, prev.Day) {
+			return
+		}
+	}
+}
+
+func TestHolidaysOn(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     HebrewDate
+		diaspora bool
+		expected bool
+	}{
+		{"rosh hashana", HebrewDate{5785, Tishrei, 2}, false, true},
+		{"yom kippur", HebrewDate{5785, Tishrei, 10}, false, true},
+		{"chol hamoed", HebrewDate{5785, Tishrei, 17}, false, false},
+		{"second day of pesach in israel", HebrewDate{5785, Nisan, 16}, false, false},
+		{"second day of pesach in diaspora", HebrewDate{5785, Nisan, 16}, true, true},
+		{"shavuot", HebrewDate{5785, Sivan, 6}, false, true},
+		{"regular day", HebrewDate{5785, Kislev, 3}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, IsYomTov(test.date, test.diaspora))
+		})
+	}
+}
+
+func TestHebrewDateString(t *testing.T) {
+	tests := []struct {
+		gregorian string
+		expected  string
+	}{
+		{"2024-12-26", "כ״ה בכסלו"},
+		{"2024-10-03", "א׳ בתשרי"},
+		{"2024-02-20", "י״א באדר א׳"},
+		{"2024-03-24", "י״ד באדר ב׳"},
+		{"2023-03-07", "י״ד באדר"},
+		{"2025-02-13", "ט״ו בשבט"},
+		{"2024-05-24", "ט״ז באייר"},
+		{"2024-11-01", "ל׳ בתשרי"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.gregorian, func(t *testing.T) {
+			assert.Equal(t, test.expected, HebrewDateOf(parseDateNoError(test.gregorian)).String())
+		})
+	}
+}
+
+func TestHebrewNumeral(t *testing.T) {
+	for n, expected := range map[int]string{1: "א׳", 10: "י׳", 15: "ט״ו", 16: "ט״ז", 19: "י״ט", 30: "ל׳", 115: "קט״ו", 785: "תשפ״ה"} {
+		assert.Equal(t, expected, hebrewNumeral(n), n)
+	}
+}
+
+func TestHolidaysOnGregorianDates(t *testing.T) {
+	tests := []struct {
+		gregorian string
+		diaspora  bool
+		expected  []string
+	}{
+		{"2024-03-21", false, []string{"תענית אסתר"}},
+		{"2024-03-24", false, []string{"פורים"}},
+		{"2024-04-23", false, []string{"פסח"}},
+		{"2024-04-24", false, []string{"חול המועד פסח"}},
+		{"2024-04-24", true, []string{"פסח"}},
+		{"2024-05-06", false, []string{"יום השואה"}},
+		{"2024-05-13", false, []string{"יום הזיכרון"}},
+		{"2024-05-14", false, []string{"יום העצמאות"}},
+		{"2024-05-26", false, []string{"ל״ג בעומר"}},
+		{"2024-08-13", false, []string{"תשעה באב"}},
+		{"2024-10-12", false, []string{"יום כיפור"}},
+		{"2024-10-24", false, []string{"שמיני עצרת ושמחת תורה"}},
+		{"2024-10-25", true, []string{"שמחת תורה"}},
+		{"2024-12-26", false, []string{"חנוכה"}},
+		{"2025-01-02", false, []string{"חנוכה"}},
+		{"2025-01-03", false, nil},
+		{"2025-04-24", false, []string{"יום השואה"}},
+		{"2025-04-30", false, []string{"יום הזיכרון"}},
+		{"2025-05-01", false, []string{"יום העצמאות"}},
+		{"2025-05-02", false, nil},
+		{"2025-08-03", false, []string{"תשעה באב"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.gregorian, func(t *testing.T) {
+			var names []string
+			for _, h := range HolidaysOn(HebrewDateOf(parseDateNoError(test.gregorian)), test.diaspora) {
+				names = append(names, h.Name)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
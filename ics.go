@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const icsProductId = "-//calendarbot//calendarbot//HE"
+
+// icsDateTime is the iCalendar format of local date-times, paired with a
+// TZID parameter.
+const icsDateTime = "20060102T150405"
+
+// icsUTC is the iCalendar format of UTC date-times.
+const icsUTC = "20060102T150405Z"
+
+const icsDate = "20060102"
+
+// MarshalICS renders the event as an iCalendar invitation. The METHOD tells
+// clients whether to add or update the event, or to cancel it, and the UID
+// and SEQUENCE let them match it with the copy they already have. Times are
+// written in loc, which is described in a VTIMEZONE.
+func MarshalICS(event CalendarEvent, loc *time.Location, now time.Time) []byte {
+	var w icsWriter
+	method, status := icsMethod(event), "CONFIRMED"
+	switch {
+	case event.Status == StatusCanceled:
+		status = "CANCELLED"
+	case event.Tentative:
+		status = "TENTATIVE"
+	}
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("PRODID", icsProductId)
+	w.line("VERSION", "2.0")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", method)
+	if !event.AllDay {
+		writeVTimezone(&w, loc, event.Start, event.End)
+	}
+
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", eventUID(event))
+	w.line("DTSTAMP", now.UTC().Format(icsUTC))
+	w.line("SEQUENCE", fmt.Sprint(event.Sequence))
+	if event.AllDay {
+		// all day events end on their last day, while DTEND is exclusive
+		w.line("DTSTART;VALUE=DATE", event.Start.Format(icsDate))
+		w.line("DTEND;VALUE=DATE", event.End.AddDate(0, 0, 1).Format(icsDate))
+	} else {
+		w.line("DTSTART;TZID="+loc.String(), event.Start.In(loc).Format(icsDateTime))
+		w.line("DTEND;TZID="+loc.String(), event.End.In(loc).Format(icsDateTime))
+	}
+	w.line("SUMMARY", icsEscape(eventTitle(event)))
+	if !isBlank(event.Location) {
+		w.line("LOCATION", icsEscape(event.Location))
+	}
+	w.line("STATUS", status)
+	if event.Transparent {
+		w.line("TRANSP", "TRANSPARENT")
+	}
+	if event.Creator != "" {
+		w.line("ORGANIZER", "mailto:"+event.Creator)
+	}
+	for _, attendee := range event.Attendees {
+		w.line("ATTENDEE;RSVP=FALSE", "mailto:"+attendee)
+	}
+	w.line("END", "VEVENT")
+	w.line("END", "VCALENDAR")
+
+	return []byte(w.String())
+}
+
+// icsMethod returns the METHOD of the event's invitation, which emails also
+// carry in their Content-Type.
+func icsMethod(event CalendarEvent) string {
+	if event.Status == StatusCanceled {
+		return "CANCEL"
+	}
+	return "REQUEST"
+}
+
+// eventUID returns the event's iCalendar UID, as Google shares it with other
+// calendars.
+func eventUID(event CalendarEvent) string {
+	if event.ICalUID != "" {
+		return event.ICalUID
+	}
+	return event.Id + "@google.com"
+}
+
+// writeVTimezone describes the offsets of loc around the event, as found by
+// scanning for the transitions in the years surrounding it. Each transition
+// is written as its own observance rather than as a recurrence rule, which
+// Go doesn't expose.
+func writeVTimezone(w *icsWriter, loc *time.Location, start, end time.Time) {
+	from := time.Date(start.In(loc).Year()-1, time.January, 1, 0, 0, 0, 0, loc)
+	to := time.Date(end.In(loc).Year()+2, time.January, 1, 0, 0, 0, 0, loc)
+
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", loc.String())
+
+	name, offset := from.Zone()
+	writeObservance(w, from, offset, offset, name)
+	for _, t := range zoneTransitions(from, to) {
+		newName, newOffset := t.Zone()
+		writeObservance(w, t, offset, newOffset, newName)
+		offset = newOffset
+	}
+
+	w.line("END", "VTIMEZONE")
+}
+
+// writeObservance writes the period starting at t, whose DTSTART is in the
+// local time that was in effect before it.
+func writeObservance(w *icsWriter, t time.Time, fromOffset, toOffset int, name string) {
+	kind := "STANDARD"
+	if t.IsDST() {
+		kind = "DAYLIGHT"
+	}
+
+	w.line("BEGIN", kind)
+	w.line("DTSTART", t.UTC().Add(time.Duration(fromOffset)*time.Second).Format(icsDateTime))
+	w.line("TZOFFSETFROM", icsOffset(fromOffset))
+	w.line("TZOFFSETTO", icsOffset(toOffset))
+	w.line("TZNAME", name)
+	w.line("END", kind)
+}
+
+// zoneTransitions finds the times between from and to at which the offset of
+// their location changes, to the second.
+func zoneTransitions(from, to time.Time) []time.Time {
+	var transitions []time.Time
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, before := day.Zone()
+		if _, after := next.Zone(); before == after {
+			continue
+		}
+
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, offset := mid.Zone(); offset == before {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transitions = append(transitions, hi)
+	}
+
+	return transitions
+}
+
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+var icsReplacer = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// icsEscape escapes a TEXT value.
+func icsEscape(s string) string {
+	return icsReplacer.Replace(sanitizeText(s))
+}
+
+// icsWriter writes content lines, folded at 75 octets without splitting
+// UTF-8 sequences, and terminated by CRLF.
+type icsWriter struct {
+	strings.Builder
+}
+
+func (w *icsWriter) line(name, value string) {
+	line := name + ":" + value
+	// continuation lines start with a space, which counts towards their length
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for !isRuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	w.WriteString(line + "\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// GoogleCalendarLink returns a link opening Google Calendar with the event
+// ready to be added.
+func GoogleCalendarLink(event CalendarEvent) string {
+	var dates string
+	if event.AllDay {
+		dates = event.Start.Format(icsDate) + "/" + event.End.AddDate(0, 0, 1).Format(icsDate)
+	} else {
+		dates = event.Start.UTC().Format(icsUTC) + "/" + event.End.UTC().Format(icsUTC)
+	}
+
+	q := url.Values{}
+	q.Set("action", "TEMPLATE")
+	q.Set("text", eventTitle(event))
+	q.Set("dates", dates)
+	if !isBlank(event.Location) {
+		q.Set("location", event.Location)
+	}
+	return "https://calendar.google.com/calendar/render?" + q.Encode()
+}
+
+// OutlookCalendarLink returns a link opening Outlook on the web with the
+// event ready to be added.
+func OutlookCalendarLink(event CalendarEvent) string {
+	q := url.Values{}
+	q.Set("path", "/calendar/action/compose")
+	q.Set("rru", "addevent")
+	q.Set("subject", eventTitle(event))
+	if !isBlank(event.Location) {
+		q.Set("location", event.Location)
+	}
+	if event.AllDay {
+		q.Set("startdt", event.Start.Format(time.DateOnly))
+		q.Set("enddt", event.End.AddDate(0, 0, 1).Format(time.DateOnly))
+		q.Set("allday", "true")
+	} else {
+		q.Set("startdt", event.Start.UTC().Format(time.RFC3339))
+		q.Set("enddt", event.End.UTC().Format(time.RFC3339))
+	}
+	return "https://outlook.live.com/calendar/0/deeplink/compose?" + q.Encode()
+}
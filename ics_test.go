@@ -0,0 +1,274 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalICSRoundTrip(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	now := time.Date(2024, time.June, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		status EventStatus
+		method string
+		state  string
+	}{
+		{
+			name:   "created in summer time",
+			start:  time.Date(2024, time.June, 12, 20, 0, 0, 0, loc),
+			status: StatusCreated,
+			method: "REQUEST",
+			state:  "CONFIRMED",
+		},
+		{
+			name:   "updated in winter time",
+			start:  time.Date(2024, time.December, 26, 19, 30, 0, 0, loc),
+			status: StatusUpdated,
+			method: "REQUEST",
+			state:  "CONFIRMED",
+		},
+		{
+			name:   "canceled on the day summer time starts",
+			start:  time.Date(2025, time.March, 28, 10, 0, 0, 0, loc),
+			status: StatusCanceled,
+			method: "CANCEL",
+			state:  "CANCELLED",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := CalendarEvent{
+				Id:        "abc123",
+				ICalUID:   "abc123@google.com",
+				Sequence:  2,
+				Title:     "Dinner; with \\ friends, at\nhome",
+				Location:  "Herzl 5, Haifa",
+				Start:     test.start.In(time.UTC),
+				End:       test.start.Add(90 * time.Minute).In(time.UTC),
+				Creator:   "mom@example.com",
+				Attendees: []string{"dad@example.com"},
+				Status:    test.status,
+			}
+
+			cal := parseICS(t, MarshalICS(event, loc, now))
+
+			assert.Equal(t, test.method, cal.prop("METHOD").value)
+			assert.Equal(t, "2.0", cal.prop("VERSION").value)
+
+			vevent := cal.child("VEVENT")
+			assert.Equal(t, "abc123@google.com", vevent.prop("UID").value)
+			assert.Equal(t, "2", vevent.prop("SEQUENCE").value)
+			assert.Equal(t, "20240601T090000Z", vevent.prop("DTSTAMP").value)
+			assert.Equal(t, test.state, vevent.prop("STATUS").value)
+			assert.Equal(t, event.Title, icsUnescape(vevent.prop("SUMMARY").value))
+			assert.Equal(t, "Herzl 5, Haifa", icsUnescape(vevent.prop("LOCATION").value))
+			assert.Equal(t, "mailto:mom@example.com", vevent.prop("ORGANIZER").value)
+			assert.Equal(t, "mailto:dad@example.com", vevent.prop("ATTENDEE").value)
+
+			vtimezone := cal.child("VTIMEZONE")
+			assert.Equal(t, "Asia/Jerusalem", vtimezone.prop("TZID").value)
+			assert.True(t, resolveICSTime(t, vtimezone, vevent.prop("DTSTART")).Equal(event.Start))
+			assert.True(t, resolveICSTime(t, vtimezone, vevent.prop("DTEND")).Equal(event.End))
+		})
+	}
+}
+
+func TestMarshalICSAllDay(t *testing.T) {
+	event := CalendarEvent{
+		Id:     "abc123",
+		Title:  "Trip",
+		Start:  time.Date(2024, time.June, 12, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2024, time.June, 14, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+		Status: StatusCreated,
+	}
+
+	cal := parseICS(t, MarshalICS(event, time.UTC, time.Now()))
+
+	vevent := cal.child("VEVENT")
+	assert.Equal(t, "abc123@google.com", vevent.prop("UID").value)
+	assert.Equal(t, "DATE", vevent.prop("DTSTART").params["VALUE"])
+	assert.Equal(t, "20240612", vevent.prop("DTSTART").value)
+	assert.Equal(t, "20240615", vevent.prop("DTEND").value)
+	assert.Nil(t, cal.child("VTIMEZONE"))
+}
+
+func TestMarshalICSFoldsLongLines(t *testing.T) {
+	event := CalendarEvent{
+		Id:     "abc123",
+		Title:  strings.Repeat("ארוחת ערב משפחתית ", 10),
+		Start:  time.Date(2024, time.June, 12, 17, 0, 0, 0, time.UTC),
+		End:    time.Date(2024, time.June, 12, 18, 0, 0, 0, time.UTC),
+		Status: StatusCreated,
+	}
+
+	ics := MarshalICS(event, time.UTC, time.Now())
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(ics), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+		assert.True(t, utf8.ValidString(line))
+	}
+	cal := parseICS(t, ics)
+	assert.Equal(t, event.Title, icsUnescape(cal.child("VEVENT").prop("SUMMARY").value))
+}
+
+func TestCalendarLinks(t *testing.T) {
+	event := CalendarEvent{
+		Title: "Dinner & drinks",
+		Start: time.Date(2024, time.June, 12, 20, 0, 0, 0, time.FixedZone("IDT", 3*60*60)),
+		End:   time.Date(2024, time.June, 12, 21, 0, 0, 0, time.FixedZone("IDT", 3*60*60)),
+	}
+
+	google, err := url.Parse(GoogleCalendarLink(event))
+	require.NoError(t, err)
+	assert.Equal(t, "Dinner & drinks", google.Query().Get("text"))
+	assert.Equal(t, "20240612T170000Z/20240612T180000Z", google.Query().Get("dates"))
+
+	outlook, err := url.Parse(OutlookCalendarLink(event))
+	require.NoError(t, err)
+	assert.Equal(t, "Dinner & drinks", outlook.Query().Get("subject"))
+	assert.Equal(t, "2024-06-12T17:00:00Z", outlook.Query().Get("startdt"))
+	assert.Equal(t, "2024-06-12T18:00:00Z", outlook.Query().Get("enddt"))
+
+	event.AllDay = true
+	event.Start = time.Date(2024, time.June, 12, 0, 0, 0, 0, time.UTC)
+	event.End = event.Start
+
+	google, err = url.Parse(GoogleCalendarLink(event))
+	require.NoError(t, err)
+	assert.Equal(t, "20240612/20240613", google.Query().Get("dates"))
+
+	outlook, err = url.Parse(OutlookCalendarLink(event))
+	require.NoError(t, err)
+	assert.Equal(t, "true", outlook.Query().Get("allday"))
+	assert.Equal(t, "2024-06-13", outlook.Query().Get("enddt"))
+}
+
+// icsComponent is a parsed iCalendar component, enough to check what
+// MarshalICS writes.
+type icsComponent struct {
+	name     string
+	props    []icsProperty
+	children []*icsComponent
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func (c *icsComponent) prop(name string) icsProperty {
+	for _, p := range c.props {
+		if p.name == name {
+			return p
+		}
+	}
+	return icsProperty{}
+}
+
+func (c *icsComponent) child(name string) *icsComponent {
+	for _, child := range c.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func parseICS(t *testing.T, data []byte) *icsComponent {
+	t.Helper()
+	text := string(data)
+	require.True(t, strings.HasSuffix(text, "\r\n"))
+	unfolded := strings.ReplaceAll(text, "\r\n ", "")
+
+	var stack []*icsComponent
+	var root *icsComponent
+	for _, line := range strings.Split(strings.TrimSuffix(unfolded, "\r\n"), "\r\n") {
+		head, value, ok := strings.Cut(line, ":")
+		require.True(t, ok, "line without a value: %q", line)
+		parts := strings.Split(head, ";")
+		prop := icsProperty{name: parts[0], params: map[string]string{}, value: value}
+		for _, param := range parts[1:] {
+			k, v, _ := strings.Cut(param, "=")
+			prop.params[k] = v
+		}
+
+		switch prop.name {
+		case "BEGIN":
+			c := &icsComponent{name: value}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, c)
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			require.NotEmpty(t, stack)
+			require.Equal(t, stack[len(stack)-1].name, value)
+			stack = stack[:len(stack)-1]
+		default:
+			require.NotEmpty(t, stack)
+			c := stack[len(stack)-1]
+			c.props = append(c.props, prop)
+		}
+	}
+	require.Empty(t, stack)
+	require.NotNil(t, root)
+	require.Equal(t, "VCALENDAR", root.name)
+
+	return root
+}
+
+// resolveICSTime finds the instant of a local date-time by the offset of the
+// latest observance of the timezone starting before it.
+func resolveICSTime(t *testing.T, vtimezone *icsComponent, prop icsProperty) time.Time {
+	t.Helper()
+	require.Equal(t, vtimezone.prop("TZID").value, prop.params["TZID"])
+	local, err := time.Parse(icsDateTime, prop.value)
+	require.NoError(t, err)
+
+	offset, found := 0, false
+	var latest time.Time
+	for _, observance := range vtimezone.children {
+		start, err := time.Parse(icsDateTime, observance.prop("DTSTART").value)
+		require.NoError(t, err)
+		if start.After(local) || (found && start.Before(latest)) {
+			continue
+		}
+		latest, found = start, true
+		offset = parseICSOffset(t, observance.prop("TZOFFSETTO").value)
+	}
+	require.True(t, found, "no observance before %s", prop.value)
+
+	return local.Add(-time.Duration(offset) * time.Second)
+}
+
+func parseICSOffset(t *testing.T, s string) int {
+	require.Len(t, s, 5)
+	hours, err := strconv.Atoi(s[1:3])
+	require.NoError(t, err)
+	minutes, err := strconv.Atoi(s[3:5])
+	require.NoError(t, err)
+	offset := hours*3600 + minutes*60
+	if s[0] == '-' {
+		return -offset
+	}
+	return offset
+}
+
+func icsUnescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
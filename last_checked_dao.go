@@ -9,6 +9,8 @@ import (
 type LastCheckedDao interface {
 	GetLastChecked() (time.Time, bool, error)
 	SetLastChecked(t time.Time) error
+	// ClearLastChecked forgets the last check, as if the bot never ran.
+	ClearLastChecked() error
 }
 
 func NewLastCheckedDao(cfg Config) LastCheckedDao {
@@ -41,9 +43,18 @@ func (l *lastCheckedDao) GetLastChecked() (time.Time, bool, error) {
 }
 
 func (l *lastCheckedDao) SetLastChecked(t time.Time) error {
-	// Convert the time to a string
-	timeString := t.Format(time.RFC3339)
+	// Convert the time to a string, keeping fractions of a second so the
+	// next run resumes exactly where this one stopped
+	timeString := t.Format(time.RFC3339Nano)
 
 	// Write the time string to the file
 	return os.WriteFile(l.cfg.LastCheckedFile, []byte(timeString), fs.FileMode(0644))
 }
+
+func (l *lastCheckedDao) ClearLastChecked() error {
+	if err := os.Remove(l.cfg.LastCheckedFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
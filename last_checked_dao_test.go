@@ -47,3 +47,25 @@ func (s *LastCheckedDaoSuite) TestSetGet() {
 	s.Assert().True(isExist)
 	s.Assert().Equal(expected, actual)
 }
+
+func (s *LastCheckedDaoSuite) TestSetGetFractionalSeconds() {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	s.Require().NoError(err)
+	expected := time.Date(2024, time.October, 27, 1, 30, 15, 250000000, tz)
+	s.Require().NoError(s.dao.SetLastChecked(expected))
+
+	actual, isExist, err := s.dao.GetLastChecked()
+	s.Assert().NoError(err)
+	s.Assert().True(isExist)
+	s.Assert().True(expected.Equal(actual))
+}
+
+func (s *LastCheckedDaoSuite) TestClear() {
+	s.Require().NoError(s.dao.SetLastChecked(time.Now()))
+	s.Require().NoError(s.dao.ClearLastChecked())
+
+	_, isExist, err := s.dao.GetLastChecked()
+	s.Assert().NoError(err)
+	s.Assert().False(isExist)
+	s.Assert().NoError(s.dao.ClearLastChecked(), "clearing twice is fine")
+}
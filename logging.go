@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// ConfigureLogging sets the format and the level of the log.
+func ConfigureLogging(cfg Config) error {
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.LogFormat {
+	case logFormatText:
+		log.SetFormatter(&log.TextFormatter{})
+	case logFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unsupported log format: %q", cfg.LogFormat)
+	}
+	log.SetLevel(level)
+
+	return nil
+}
+
+// newCycleId identifies a cycle of the engine, so that the lines it logs can
+// be told apart from those of other cycles.
+func newCycleId() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
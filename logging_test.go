@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog collects the JSON lines logged during the test.
+func captureLog(t *testing.T, level string) *bytes.Buffer {
+	formatter, out, lvl := log.StandardLogger().Formatter, log.StandardLogger().Out, log.GetLevel()
+	t.Cleanup(func() {
+		log.SetFormatter(formatter)
+		log.SetOutput(out)
+		log.SetLevel(lvl)
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, ConfigureLogging(Config{LogFormat: logFormatJSON, LogLevel: level}))
+	log.SetOutput(&buf)
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &fields), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestConfigureLogging(t *testing.T) {
+	buf := captureLog(t, "warning")
+
+	log.Info("hidden")
+	log.WithField("chatId", 42).Warn("shown")
+
+	lines := logLines(t, buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "shown", lines[0]["msg"])
+	assert.Equal(t, "warning", lines[0]["level"])
+	assert.Equal(t, 42.0, lines[0]["chatId"])
+}
+
+func TestConfigureLoggingInvalid(t *testing.T) {
+	assert.Error(t, ConfigureLogging(Config{LogFormat: "xml", LogLevel: "info"}))
+	assert.Error(t, ConfigureLogging(Config{LogFormat: logFormatText, LogLevel: "loud"}))
+}
+
+func TestEngineLogsFilterDecisions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	buf := captureLog(t, "info")
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetRecentEvents", ctx, "family", mock.Anything).Return([]CalendarEvent{
+		{Id: "mine", Start: clock.Now().Add(time.Hour), Creator: "family"},
+		{Id: "past", Start: clock.Now().Add(-time.Hour), Creator: "someone else", Status: StatusUpdated},
+		{Id: "nobody", Start: clock.Now().Add(time.Hour), Creator: "someone else"},
+	}, nil)
+	engine := Engine{
+		cfg:         Config{CalendarId: "family", FirstRunLookBack: time.Hour},
+		calSvc:      calSvcMock,
+		lastChkdDao: NewLastCheckedDao(Config{LastCheckedFile: filepath.Join(dir, "last_checked.txt")}),
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   NewOutboxDao(Config{OutboxFile: filepath.Join(dir, "outbox.json")}),
+		clock:       clock,
+	}
+
+	require.NoError(t, engine.Work(ctx))
+
+	lines := logLines(t, buf)
+	require.Len(t, lines, 3)
+	cycleId := lines[0]["cycleId"]
+	assert.NotEmpty(t, cycleId)
+	expected := []struct{ eventId, reason string }{
+		{"mine", filterReasonOwner},
+		{"past", filterReasonOutdated},
+		{"nobody", filterReasonNoRecipients},
+	}
+	for i, line := range lines {
+		assert.Equal(t, cycleId, line["cycleId"], "same cycle")
+		assert.Equal(t, "family", line["calendarId"])
+		assert.Equal(t, expected[i].eventId, line["eventId"])
+		assert.Equal(t, expected[i].reason, line["reason"])
+	}
+	assert.Equal(t, "updated", lines[1]["status"])
+}
@@ -2,39 +2,23 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sethvargo/go-envconfig"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	if err := LoadDotEnv(); err != nil {
 		log.WithError(err).Fatal("error loading .env file")
 	}
-	cfg, err := ReadConfigFromEnv(ctx)
-	if err != nil {
-		log.WithError(err).Fatal("error reading config")
-	}
 
-	calSvc := NewCalendarService(cfg)
-	if err := calSvc.Init(ctx); err != nil {
-		log.WithError(err).Fatal("error initializing calendar service")
-	}
-
-	telcli := NewTelegram(cfg)
-	if err := telcli.Init(); err != nil {
-		log.WithError(err).Fatal("error initializing telegram client")
-	}
-
-	lastChkdDao := NewLastCheckedDao(cfg)
-	engine := Engine{
-		cfg:         cfg,
-		calSvc:      calSvc,
-		telcli:      telcli,
-		lastChkdDao: lastChkdDao,
-	}
-
-	if err := engine.Work(ctx); err != nil {
-		log.WithError(err).Fatal("engine failed")
-	}
+	c := cli{lookuper: envconfig.OsLookuper(), clock: NewSystemClock(), stdout: os.Stdout, stderr: os.Stderr}
+	code := c.Run(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// markup renders text for one of Telegram's parse modes. Every method
+// receives raw (unescaped) content and is responsible for escaping it.
+type markup interface {
+	ParseMode() string
+	Escape(s string) string
+	Bold(s string) string
+	Mention(name string, userId int64) string
+	Link(text string, url string) string
+}
+
+func newMarkup(parseMode string) (markup, error) {
+	switch parseMode {
+	case tgbotapi.ModeMarkdownV2:
+		return markdownV2Markup{}, nil
+	case tgbotapi.ModeHTML:
+		return htmlMarkup{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported parse mode: %q", parseMode)
+	}
+}
+
+// markdownV2Replacer escapes every character Telegram reserves in MarkdownV2,
+// including the backslash itself which tgbotapi.EscapeText misses.
+var markdownV2Replacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
+	"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+)
+
+type markdownV2Markup struct{}
+
+func (markdownV2Markup) ParseMode() string {
+	return tgbotapi.ModeMarkdownV2
+}
+
+func (markdownV2Markup) Escape(s string) string {
+	return markdownV2Replacer.Replace(sanitizeText(s))
+}
+
+func (m markdownV2Markup) Bold(s string) string {
+	return "*" + m.Escape(s) + "*"
+}
+
+func (m markdownV2Markup) Mention(name string, userId int64) string {
+	return fmt.Sprintf("[%s](tg://user?id=%d)", m.Escape(name), userId)
+}
+
+// markdownV2URLReplacer escapes the characters Telegram reserves inside the
+// URL part of a MarkdownV2 link.
+var markdownV2URLReplacer = strings.NewReplacer("\\", "\\\\", ")", "\\)")
+
+func (m markdownV2Markup) Link(text string, url string) string {
+	return fmt.Sprintf("[%s](%s)", m.Escape(text), markdownV2URLReplacer.Replace(sanitizeText(url)))
+}
+
+var htmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+type htmlMarkup struct{}
+
+func (htmlMarkup) ParseMode() string {
+	return tgbotapi.ModeHTML
+}
+
+func (htmlMarkup) Escape(s string) string {
+	return htmlReplacer.Replace(sanitizeText(s))
+}
+
+func (h htmlMarkup) Bold(s string) string {
+	return "<b>" + h.Escape(s) + "</b>"
+}
+
+func (h htmlMarkup) Mention(name string, userId int64) string {
+	return fmt.Sprintf("<a href=\"tg://user?id=%d\">%s</a>", userId, h.Escape(name))
+}
+
+func (h htmlMarkup) Link(text string, url string) string {
+	return fmt.Sprintf("<a href=\"%s\">%s</a>", h.Escape(url), h.Escape(text))
+}
+
+// plainMarkup renders plain text, for where there's no formatting at all.
+type plainMarkup struct{}
+
+func (plainMarkup) ParseMode() string {
+	return ""
+}
+
+func (plainMarkup) Escape(s string) string {
+	return sanitizeText(s)
+}
+
+func (p plainMarkup) Bold(s string) string {
+	return p.Escape(s)
+}
+
+func (p plainMarkup) Mention(name string, _ int64) string {
+	return p.Escape(name)
+}
+
+func (p plainMarkup) Link(text string, url string) string {
+	return fmt.Sprintf("%s <%s>", p.Escape(text), p.Escape(url))
+}
+
+// sanitizeText makes sure user content is valid UTF-8 without NUL characters,
+// both of which Telegram rejects regardless of the parse mode.
+func sanitizeText(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "�"), "\x00", "")
+}
+
+// isBlank reports whether s renders as nothing once sanitized.
+func isBlank(s string) bool {
+	return strings.TrimSpace(sanitizeText(s)) == ""
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMarkup(t *testing.T) {
+	m, err := newMarkup("MarkdownV2")
+	require.NoError(t, err)
+	assert.Equal(t, "MarkdownV2", m.ParseMode())
+
+	m, err = newMarkup("HTML")
+	require.NoError(t, err)
+	assert.Equal(t, "HTML", m.ParseMode())
+
+	_, err = newMarkup("markdown")
+	assert.Error(t, err)
+}
+
+func TestMarkupEscape(t *testing.T) {
+	tests := []struct {
+		name     string
+		markup   markup
+		input    string
+		expected string
+	}{
+		{"markdown v2 plain", markdownV2Markup{}, "שלום", "שלום"},
+		{"markdown v2 reserved", markdownV2Markup{}, "a_b*c[d]e(f)g~h`i>j#k+l-m=n|o{p}q.r!", "a\\_b\\*c\\[d\\]e\\(f\\)g\\~h\\`i\\>j\\#k\\+l\\-m\\=n\\|o\\{p\\}q\\.r\\!"},
+		{"markdown v2 backslash", markdownV2Markup{}, "a\\*", "a\\\\\\*"},
+		{"html", htmlMarkup{}, "<a href=\"x\">&</a>", "&lt;a href=&quot;x&quot;&gt;&amp;&lt;/a&gt;"},
+		{"invalid utf8", htmlMarkup{}, "a\xffb\x00", "a�b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.markup.Escape(test.input))
+		})
+	}
+}
+
+func TestMarkupMention(t *testing.T) {
+	tests := []struct {
+		name     string
+		markup   markup
+		expected string
+	}{
+		{"markdown v2", markdownV2Markup{}, "[Dad \\(Avi\\)](tg://user?id=42)"},
+		{"html", htmlMarkup{}, "<a href=\"tg://user?id=42\">Dad (Avi)</a>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.markup.Mention("Dad (Avi)", 42))
+		})
+	}
+}
+
+func TestMarkupLink(t *testing.T) {
+	url := "https://example.com/a_(b)?x=1&y=\\"
+	tests := []struct {
+		name     string
+		markup   markup
+		expected string
+	}{
+		{"markdown v2", markdownV2Markup{}, "[Google \\(web\\)](https://example.com/a_(b\\)?x=1&y=\\\\)"},
+		{"html", htmlMarkup{}, "<a href=\"https://example.com/a_(b)?x=1&amp;y=\\\">Google (web)</a>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.markup.Link("Google (web)", url))
+		})
+	}
+}
+
+// validateMarkdownV2 checks a message against the subset of Telegram's
+// MarkdownV2 grammar the bot produces: escapes, bold entities and inline links.
+func validateMarkdownV2(s string) error {
+	if err := validateText(s); err != nil {
+		return err
+	}
+
+	const reserved = "_()~`>#+-=|{}.!"
+	bold, boldLen := false, 0
+	link, linkLen := false, 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == '\\':
+			next, nextSize := utf8.DecodeRuneInString(s[i:])
+			if nextSize == 0 || next < 1 || next > 126 {
+				return fmt.Errorf("invalid escape at offset %d", i)
+			}
+			i += nextSize
+			boldLen++
+			linkLen++
+		case r == '*':
+			if bold && boldLen == 0 {
+				return fmt.Errorf("empty bold entity at offset %d", i)
+			}
+			bold, boldLen = !bold, 0
+		case r == '[':
+			if link {
+				return fmt.Errorf("nested link at offset %d", i)
+			}
+			link, linkLen = true, 0
+		case r == ']':
+			if !link || linkLen == 0 {
+				return fmt.Errorf("unexpected link end at offset %d", i)
+			}
+			if !strings.HasPrefix(s[i:], "(") {
+				return fmt.Errorf("missing link url at offset %d", i)
+			}
+			end := strings.IndexByte(s[i:], ')')
+			if end < 0 {
+				return fmt.Errorf("unterminated link url at offset %d", i)
+			}
+			if strings.ContainsAny(s[i+1:i+end], "\\()") {
+				return fmt.Errorf("unsupported link url at offset %d", i)
+			}
+			i += end + 1
+			link = false
+			boldLen++
+		case strings.ContainsRune(reserved, r):
+			return fmt.Errorf("unescaped %q at offset %d", r, i)
+		default:
+			boldLen++
+			linkLen++
+		}
+	}
+	if bold || link {
+		return fmt.Errorf("unterminated entity")
+	}
+
+	return nil
+}
+
+// validateHTML checks a message against the subset of Telegram's HTML grammar
+// the bot produces: bold tags, links and the supported named entities.
+func validateHTML(s string) error {
+	if err := validateText(s); err != nil {
+		return err
+	}
+
+	var open []string
+	for i := 0; i < len(s); {
+		switch rest := s[i:]; {
+		case strings.HasPrefix(rest, "<b>"), strings.HasPrefix(rest, "<a href=\""):
+			tag := rest[1:2]
+			for _, o := range open {
+				if o == tag {
+					return fmt.Errorf("nested %s tag at offset %d", tag, i)
+				}
+			}
+			open = append(open, tag)
+			end := strings.IndexByte(rest, '>')
+			if end < 0 || strings.ContainsAny(rest[1:end], "<&") {
+				return fmt.Errorf("malformed tag at offset %d", i)
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "</b>"), strings.HasPrefix(rest, "</a>"):
+			tag := rest[2:3]
+			if len(open) == 0 || open[len(open)-1] != tag {
+				return fmt.Errorf("unexpected closing tag at offset %d", i)
+			}
+			open = open[:len(open)-1]
+			i += len("</b>")
+		case rest[0] == '<' || rest[0] == '>':
+			return fmt.Errorf("unescaped %q at offset %d", rest[0], i)
+		case rest[0] == '&':
+			entity := ""
+			for _, e := range []string{"&lt;", "&gt;", "&amp;", "&quot;"} {
+				if strings.HasPrefix(rest, e) {
+					entity = e
+				}
+			}
+			if entity == "" {
+				return fmt.Errorf("unsupported entity at offset %d", i)
+			}
+			i += len(entity)
+		default:
+			i++
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unterminated %s tag", open[len(open)-1])
+	}
+
+	return nil
+}
+
+func validateText(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("invalid utf-8")
+	}
+	if strings.ContainsRune(s, 0) {
+		return fmt.Errorf("contains NUL character")
+	}
+
+	return nil
+}
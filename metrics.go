@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Metrics are the bot's counters, exposed in the Prometheus text format. The
+// methods recording them can be called on a nil *Metrics, which records
+// nothing.
+type Metrics struct {
+	registry         *prometheus.Registry
+	calendarRequests *prometheus.CounterVec
+	calendarLatency  *prometheus.HistogramVec
+	eventsFetched    *prometheus.CounterVec
+	eventsFiltered   *prometheus.CounterVec
+	eventsNotified   *prometheus.CounterVec
+	telegramSends    *prometheus.CounterVec
+	telegramErrors   *prometheus.CounterVec
+	lastCycle        prometheus.Gauge
+}
+
+// calendarLatencyBuckets are the upper bounds, in seconds, of the calendar
+// API latency histogram.
+var calendarLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewMetrics(outboxDao OutboxDao) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		calendarRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_calendar_requests_total",
+			Help: "Calls to the Google Calendar API.",
+		}, []string{"method", "result"}),
+		calendarLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "calendarbot_calendar_request_duration_seconds",
+			Help:    "Latency of the calls to the Google Calendar API.",
+			Buckets: calendarLatencyBuckets,
+		}, []string{"method"}),
+		eventsFetched: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_events_fetched_total",
+			Help: "Changed events read from the calendars.",
+		}, []string{"status"}),
+		eventsFiltered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_events_filtered_total",
+			Help: "Changed events nobody was notified about.",
+		}, []string{"reason"}),
+		eventsNotified: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_events_notified_total",
+			Help: "Events chats were notified about, once per chat.",
+		}, []string{"status"}),
+		telegramSends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_telegram_requests_total",
+			Help: "Requests sent to Telegram.",
+		}, []string{"method"}),
+		telegramErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendarbot_telegram_errors_total",
+			Help: "Requests Telegram failed, by the error code it returned.",
+		}, []string{"method", "code"}),
+		lastCycle: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "calendarbot_last_successful_cycle_timestamp_seconds",
+			Help: "When the calendars were last checked successfully.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.calendarRequests,
+		m.calendarLatency,
+		m.eventsFetched,
+		m.eventsFiltered,
+		m.eventsNotified,
+		m.telegramSends,
+		m.telegramErrors,
+		m.lastCycle,
+		outboxCollector{
+			desc:      prometheus.NewDesc("calendarbot_outbox_entries", "Notifications held back during quiet hours.", nil, nil),
+			outboxDao: outboxDao,
+		},
+	)
+	return m
+}
+
+func (m *Metrics) observeCalendarRequest(method string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.calendarRequests.WithLabelValues(method, result).Inc()
+	m.calendarLatency.WithLabelValues(method).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) eventFetched(event CalendarEvent) {
+	if m == nil {
+		return
+	}
+	m.eventsFetched.WithLabelValues(event.Status.String()).Inc()
+}
+
+func (m *Metrics) eventFiltered(reason string) {
+	if m == nil {
+		return
+	}
+	m.eventsFiltered.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) eventsSent(events ...CalendarEvent) {
+	if m == nil {
+		return
+	}
+	for _, event := range events {
+		m.eventsNotified.WithLabelValues(event.Status.String()).Inc()
+	}
+}
+
+func (m *Metrics) observeTelegramRequest(method string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.telegramSends.WithLabelValues(method).Inc()
+	if err != nil {
+		m.telegramErrors.WithLabelValues(method, telegramErrorCode(err)).Inc()
+	}
+}
+
+// telegramErrorCode is the error code Telegram returned, or "network" if the
+// request didn't get an answer.
+func telegramErrorCode(err error) string {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return strconv.Itoa(apiErr.Code)
+	}
+	return "network"
+}
+
+func (m *Metrics) cycleSucceeded(at time.Time) {
+	if m == nil {
+		return
+	}
+	m.lastCycle.Set(float64(at.UnixNano()) / float64(time.Second))
+}
+
+// Handler serves the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{ErrorLog: log.StandardLogger()})
+}
+
+// outboxCollector reports the depth of the outbox, read when the metrics are
+// scraped. The sample is left out if the outbox can't be read.
+type outboxCollector struct {
+	desc      *prometheus.Desc
+	outboxDao OutboxDao
+}
+
+func (c outboxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c outboxCollector) Collect(ch chan<- prometheus.Metric) {
+	entries, err := c.outboxDao.GetOutbox()
+	if err != nil {
+		log.WithError(err).WithField("metric", "calendarbot_outbox_entries").Warn("error reading metric")
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(len(entries)))
+}
+
+// instrumentedCalendarService counts the calls to the calendar API and
+// measures their latency.
+type instrumentedCalendarService struct {
+	CalendarService
+	metrics *Metrics
+	clock   Clock
+}
+
+func (s instrumentedCalendarService) GetRecentEvents(ctx context.Context, calendarId string, since time.Time) ([]CalendarEvent, error) {
+	start := s.clock.Now()
+	events, err := s.CalendarService.GetRecentEvents(ctx, calendarId, since)
+	s.metrics.observeCalendarRequest("GetRecentEvents", s.clock.Now().Sub(start), err)
+	return events, err
+}
+
+func (s instrumentedCalendarService) GetEvents(ctx context.Context, calendarId string, from, to time.Time) ([]CalendarEvent, error) {
+	start := s.clock.Now()
+	events, err := s.CalendarService.GetEvents(ctx, calendarId, from, to)
+	s.metrics.observeCalendarRequest("GetEvents", s.clock.Now().Sub(start), err)
+	return events, err
+}
+
+func (s instrumentedCalendarService) GetBusy(ctx context.Context, calendarIds []string, from, to time.Time) ([]TimeRange, error) {
+	start := s.clock.Now()
+	busy, err := s.CalendarService.GetBusy(ctx, calendarIds, from, to)
+	s.metrics.observeCalendarRequest("GetBusy", s.clock.Now().Sub(start), err)
+	return busy, err
+}
+
+func (s instrumentedCalendarService) CreateEvent(ctx context.Context, calendarId string, event CalendarEvent) (CalendarEvent, error) {
+	start := s.clock.Now()
+	created, err := s.CalendarService.CreateEvent(ctx, calendarId, event)
+	s.metrics.observeCalendarRequest("CreateEvent", s.clock.Now().Sub(start), err)
+	return created, err
+}
+
+func (s instrumentedCalendarService) DeleteEvent(ctx context.Context, calendarId string, eventId string) error {
+	start := s.clock.Now()
+	err := s.CalendarService.DeleteEvent(ctx, calendarId, eventId)
+	s.metrics.observeCalendarRequest("DeleteEvent", s.clock.Now().Sub(start), err)
+	return err
+}
+
+func (s instrumentedCalendarService) DeclineEvent(ctx context.Context, calendarId string, eventId string) error {
+	start := s.clock.Now()
+	err := s.CalendarService.DeclineEvent(ctx, calendarId, eventId)
+	s.metrics.observeCalendarRequest("DeclineEvent", s.clock.Now().Sub(start), err)
+	return err
+}
+
+// instrumentedTelegram counts the messages sent to Telegram and the errors
+// it returned.
+type instrumentedTelegram struct {
+	Telegram
+	metrics *Metrics
+}
+
+func (t instrumentedTelegram) NotifyEvent(chatId int64, event CalendarEvent) (int, error) {
+	messageId, err := t.Telegram.NotifyEvent(chatId, event)
+	t.metrics.observeTelegramRequest("NotifyEvent", err)
+	return messageId, err
+}
+
+func (t instrumentedTelegram) NotifyDigest(chatId int64, events []CalendarEvent) (int, error) {
+	messageId, err := t.Telegram.NotifyDigest(chatId, events)
+	t.metrics.observeTelegramRequest("NotifyDigest", err)
+	return messageId, err
+}
+
+func (t instrumentedTelegram) NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error) {
+	messageId, err := t.Telegram.NotifyCatchUp(chatId, since, events)
+	t.metrics.observeTelegramRequest("NotifyCatchUp", err)
+	return messageId, err
+}
+
+func (t instrumentedTelegram) SendText(chatId int64, text string) error {
+	err := t.Telegram.SendText(chatId, text)
+	t.metrics.observeTelegramRequest("SendText", err)
+	return err
+}
+
+func (t instrumentedTelegram) SendChoices(chatId int64, text string, choices []Choice) error {
+	err := t.Telegram.SendChoices(chatId, text, choices)
+	t.metrics.observeTelegramRequest("SendChoices", err)
+	return err
+}
+
+func (t instrumentedTelegram) AnswerCallback(callbackId string, text string) error {
+	err := t.Telegram.AnswerCallback(callbackId, text)
+	t.metrics.observeTelegramRequest("AnswerCallback", err)
+	return err
+}
+
+func (t instrumentedTelegram) RemoveChoices(chatId int64, messageId int) error {
+	err := t.Telegram.RemoveChoices(chatId, messageId)
+	t.metrics.observeTelegramRequest("RemoveChoices", err)
+	return err
+}
+
+func (t instrumentedTelegram) SendPoll(chatId int64, question string, options []string) (string, int, error) {
+	pollId, messageId, err := t.Telegram.SendPoll(chatId, question, options)
+	t.metrics.observeTelegramRequest("SendPoll", err)
+	return pollId, messageId, err
+}
+
+func (t instrumentedTelegram) StopPoll(chatId int64, messageId int) error {
+	err := t.Telegram.StopPoll(chatId, messageId)
+	t.metrics.observeTelegramRequest("StopPoll", err)
+	return err
+}
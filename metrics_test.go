@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	pkgerrors "github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMetrics(t *testing.T) (*Metrics, OutboxDao) {
+	outboxDao := NewOutboxDao(Config{OutboxFile: filepath.Join(t.TempDir(), "outbox.json")})
+	return NewMetrics(outboxDao), outboxDao
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestMetricsFormat(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	m.observeCalendarRequest("GetEvents", 50*time.Millisecond, nil)
+	m.observeCalendarRequest("GetEvents", 500*time.Millisecond, nil)
+	m.observeCalendarRequest("GetEvents", 30*time.Second, errors.New("timeout"))
+	m.observeTelegramRequest("SendText", errors.New("connection refused"))
+
+	families, err := new(expfmt.TextParser).TextToMetricFamilies(strings.NewReader(scrape(t, m)))
+
+	require.NoError(t, err)
+	latency := families["calendarbot_calendar_request_duration_seconds"]
+	require.NotNil(t, latency)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, latency.GetType())
+	require.Len(t, latency.GetMetric(), 1)
+	histogram := latency.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(3), histogram.GetSampleCount())
+	assert.InDelta(t, 30.55, histogram.GetSampleSum(), 1e-9)
+	buckets := histogram.GetBucket()
+	require.Len(t, buckets, len(calendarLatencyBuckets)+1)
+	assert.Equal(t, uint64(1), buckets[0].GetCumulativeCount(), "le=0.05")
+	assert.Equal(t, uint64(2), buckets[3].GetCumulativeCount(), "le=0.5")
+	assert.Equal(t, uint64(2), buckets[len(buckets)-2].GetCumulativeCount(), "le=10")
+	assert.Equal(t, uint64(3), buckets[len(buckets)-1].GetCumulativeCount(), "le=+Inf")
+	requests := families["calendarbot_calendar_requests_total"]
+	require.NotNil(t, requests)
+	assert.Equal(t, dto.MetricType_COUNTER, requests.GetType())
+	assert.Len(t, requests.GetMetric(), 2)
+	assert.Equal(t, dto.MetricType_GAUGE, families["calendarbot_outbox_entries"].GetType())
+}
+
+func TestMetricsOutboxDepth(t *testing.T) {
+	m, outboxDao := newTestMetrics(t)
+	require.NoError(t, outboxDao.Enqueue(OutboxEntry{ChatId: 1}))
+	require.NoError(t, outboxDao.Enqueue(OutboxEntry{ChatId: 2}))
+
+	assert.Contains(t, scrape(t, m), "\ncalendarbot_outbox_entries 2\n")
+}
+
+func TestInstrumentedTelegram(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(1), "ok").Return(nil)
+	telCliMock.On("SendText", int64(2), "blocked").Return(pkgerrors.Wrap(&tgbotapi.Error{Code: 403, Message: "Forbidden"}, "error sending"))
+	telCliMock.On("SendText", int64(3), "offline").Return(errors.New("connection refused"))
+	telcli := instrumentedTelegram{Telegram: telCliMock, metrics: m}
+
+	assert.NoError(t, telcli.SendText(1, "ok"))
+	assert.Error(t, telcli.SendText(2, "blocked"))
+	assert.Error(t, telcli.SendText(3, "offline"))
+
+	metrics := scrape(t, m)
+	assert.Contains(t, metrics, `calendarbot_telegram_requests_total{method="SendText"} 3`)
+	assert.Contains(t, metrics, `calendarbot_telegram_errors_total{code="403",method="SendText"} 1`)
+	assert.Contains(t, metrics, `calendarbot_telegram_errors_total{code="network",method="SendText"} 1`)
+}
+
+func TestInstrumentedCalendarService(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMetrics(t)
+	calSvcMock := &CalendarServiceMock{}
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	calSvcMock.On("DeleteEvent", ctx, "family", "gone").Run(func(mock.Arguments) { clock.Advance(2 * time.Second) }).Return(errors.New("not found"))
+	calSvc := instrumentedCalendarService{CalendarService: calSvcMock, metrics: m, clock: clock}
+
+	assert.Error(t, calSvc.DeleteEvent(ctx, "family", "gone"))
+
+	metrics := scrape(t, m)
+	assert.Contains(t, metrics, `calendarbot_calendar_requests_total{method="DeleteEvent",result="error"} 1`)
+	assert.Contains(t, metrics, `calendarbot_calendar_request_duration_seconds_count{method="DeleteEvent"} 1`)
+	assert.Contains(t, metrics, `calendarbot_calendar_request_duration_seconds_sum{method="DeleteEvent"} 2`)
+}
+
+func TestEngineMetrics(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, outboxDao := newTestMetrics(t)
+	clock := NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	upcoming := clock.Now().Add(24 * time.Hour)
+	events := []CalendarEvent{
+		{Title: "notified", Start: upcoming, Creator: "someone else", Status: StatusCreated},
+		{Title: "canceled", Start: upcoming, Creator: "someone else", Status: StatusCanceled},
+		{Title: "by the owner", Start: upcoming, Creator: "family", Status: StatusCreated},
+		{Title: "outdated", Start: clock.Now().Add(-time.Hour), Creator: "someone else", Status: StatusUpdated},
+	}
+	calSvcMock := &CalendarServiceMock{}
+	calSvcMock.On("GetRecentEvents", ctx, "family", mock.Anything).Return(events, nil)
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("NotifyEvent", mock.Anything, mock.Anything).Return(nil)
+	engine := Engine{
+		cfg:         Config{CalendarId: "family", TelegramChatId: 42, FirstRunLookBack: time.Hour},
+		calSvc:      calSvcMock,
+		telcli:      telCliMock,
+		lastChkdDao: NewLastCheckedDao(Config{LastCheckedFile: filepath.Join(dir, "last_checked.txt")}),
+		subsDao:     NewSubscriptionDao(Config{SubscriptionsFile: filepath.Join(dir, "subscriptions.json")}),
+		outboxDao:   outboxDao,
+		clock:       clock,
+		metrics:     m,
+	}
+
+	require.NoError(t, engine.Work(ctx))
+
+	metrics := scrape(t, m)
+	assert.Contains(t, metrics, `calendarbot_events_fetched_total{status="created"} 2`)
+	assert.Contains(t, metrics, `calendarbot_events_fetched_total{status="updated"} 1`)
+	assert.Contains(t, metrics, `calendarbot_events_filtered_total{reason="outdated"} 1`)
+	assert.Contains(t, metrics, `calendarbot_events_filtered_total{reason="owner"} 1`)
+	assert.Contains(t, metrics, `calendarbot_events_notified_total{status="canceled"} 1`)
+	assert.Contains(t, metrics, `calendarbot_events_notified_total{status="created"} 1`)
+	assert.Contains(t, metrics, "\ncalendarbot_last_successful_cycle_timestamp_seconds 1.7174088e+09\n")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"), rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "# TYPE calendarbot_outbox_entries gauge\ncalendarbot_outbox_entries 0\n")
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	assert.NotPanics(t, func() {
+		m.eventFetched(CalendarEvent{})
+		m.eventFiltered("owner")
+		m.eventsSent(CalendarEvent{})
+		m.observeTelegramRequest("SendText", nil)
+		m.observeCalendarRequest("GetEvents", time.Second, nil)
+		m.cycleSucceeded(time.Now())
+	})
+}
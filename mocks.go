@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"github.com/stretchr/testify/mock"
+	"sync"
 	"time"
 )
 
@@ -15,11 +16,55 @@ func (c *CalendarServiceMock) Init(ctx context.Context) error {
 	return args.Error(0)
 }
 
-func (c *CalendarServiceMock) GetRecentEvents(ctx context.Context, since time.Time) ([]CalendarEvent, error) {
-	args := c.Called(ctx, since)
+func (c *CalendarServiceMock) GetRecentEvents(ctx context.Context, calendarId string, since time.Time) ([]CalendarEvent, error) {
+	args := c.Called(ctx, calendarId, since)
 	return args.Get(0).([]CalendarEvent), args.Error(1)
 }
 
+func (c *CalendarServiceMock) GetEvents(ctx context.Context, calendarId string, from, to time.Time) ([]CalendarEvent, error) {
+	args := c.Called(ctx, calendarId, from, to)
+	return args.Get(0).([]CalendarEvent), args.Error(1)
+}
+
+func (c *CalendarServiceMock) GetBusy(ctx context.Context, calendarIds []string, from, to time.Time) ([]TimeRange, error) {
+	args := c.Called(ctx, calendarIds, from, to)
+	return args.Get(0).([]TimeRange), args.Error(1)
+}
+
+func (c *CalendarServiceMock) CreateEvent(ctx context.Context, calendarId string, event CalendarEvent) (CalendarEvent, error) {
+	args := c.Called(ctx, calendarId, event)
+	return args.Get(0).(CalendarEvent), args.Error(1)
+}
+
+func (c *CalendarServiceMock) DeleteEvent(ctx context.Context, calendarId string, eventId string) error {
+	args := c.Called(ctx, calendarId, eventId)
+	return args.Error(0)
+}
+
+func (c *CalendarServiceMock) DeclineEvent(ctx context.Context, calendarId string, eventId string) error {
+	args := c.Called(ctx, calendarId, eventId)
+	return args.Error(0)
+}
+
+type MailerMock struct {
+	mock.Mock
+}
+
+func (m *MailerMock) Init() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MailerMock) NotifyEvent(to EmailRecipient, event CalendarEvent) error {
+	args := m.Called(to, event)
+	return args.Error(0)
+}
+
+func (m *MailerMock) NotifyDigest(to EmailRecipient, events []CalendarEvent) error {
+	args := m.Called(to, events)
+	return args.Error(0)
+}
+
 type TelegramClientMock struct {
 	mock.Mock
 }
@@ -29,7 +74,96 @@ func (t *TelegramClientMock) Init() error {
 	return args.Error(0)
 }
 
-func (t *TelegramClientMock) NotifyEvent(event CalendarEvent) error {
-	args := t.Called(event)
+func (t *TelegramClientMock) NotifyEvent(chatId int64, event CalendarEvent) (int, error) {
+	return messageIdResult(t.Called(chatId, event))
+}
+
+func (t *TelegramClientMock) NotifyDigest(chatId int64, events []CalendarEvent) (int, error) {
+	return messageIdResult(t.Called(chatId, events))
+}
+
+func (t *TelegramClientMock) NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error) {
+	return messageIdResult(t.Called(chatId, since, events))
+}
+
+// messageIdResult lets expectations return just an error, for tests that
+// don't care about the id of the message sent.
+func messageIdResult(args mock.Arguments) (int, error) {
+	if len(args) == 1 {
+		return 0, args.Error(0)
+	}
+	return args.Int(0), args.Error(1)
+}
+
+func (t *TelegramClientMock) SendText(chatId int64, text string) error {
+	args := t.Called(chatId, text)
 	return args.Error(0)
 }
+
+func (t *TelegramClientMock) SendChoices(chatId int64, text string, choices []Choice) error {
+	args := t.Called(chatId, text, choices)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) RemoveChoices(chatId int64, messageId int) error {
+	args := t.Called(chatId, messageId)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) AnswerCallback(callbackId string, text string) error {
+	args := t.Called(callbackId, text)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) SendPoll(chatId int64, question string, options []string) (string, int, error) {
+	args := t.Called(chatId, question, options)
+	return args.String(0), args.Int(1), args.Error(2)
+}
+
+func (t *TelegramClientMock) StopPoll(chatId int64, messageId int) error {
+	args := t.Called(chatId, messageId)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) ListenUpdates(ctx context.Context, handler UpdateHandler) error {
+	args := t.Called(ctx, handler)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) SetWebhook(url string, secret string) error {
+	args := t.Called(url, secret)
+	return args.Error(0)
+}
+
+func (t *TelegramClientMock) DeleteWebhook() error {
+	args := t.Called()
+	return args.Error(0)
+}
+
+// FakeClock is a Clock that only moves when told to.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ModerationRequest is an event created by someone other than the calendar
+// owner, awaiting a decision in the moderation chat.
+type ModerationRequest struct {
+	Id          int           `json:"id"`
+	Event       CalendarEvent `json:"event"`
+	RequestedAt time.Time     `json:"requestedAt"`
+}
+
+// ModerationDecision is an entry in the audit trail of moderation.
+type ModerationDecision struct {
+	Request   ModerationRequest `json:"request"`
+	Approved  bool              `json:"approved"`
+	By        string            `json:"by"`
+	DecidedAt time.Time         `json:"decidedAt"`
+}
+
+type ModerationDao interface {
+	AddRequest(event CalendarEvent) (ModerationRequest, error)
+	GetRequest(id int) (ModerationRequest, bool, error)
+	// IsPending reports whether the event already awaits a decision.
+	IsPending(calendarId string, eventId string) (bool, error)
+	// Decide moves the request to the audit trail. It reports false if the
+	// request was already decided.
+	Decide(id int, approved bool, by string) (ModerationDecision, bool, error)
+	GetDecisions() ([]ModerationDecision, error)
+}
+
+func NewModerationDao(cfg Config, clock Clock) ModerationDao {
+	return &moderationDao{
+		cfg:   cfg,
+		clock: clock,
+	}
+}
+
+type moderationDao struct {
+	cfg   Config
+	clock Clock
+	mu    sync.Mutex
+}
+
+type moderationState struct {
+	NextId    int                  `json:"nextId"`
+	Pending   []ModerationRequest  `json:"pending"`
+	Decisions []ModerationDecision `json:"decisions"`
+}
+
+func (d *moderationDao) AddRequest(event CalendarEvent) (ModerationRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.read()
+	if err != nil {
+		return ModerationRequest{}, err
+	}
+
+	state.NextId++
+	req := ModerationRequest{Id: state.NextId, Event: event, RequestedAt: d.clock.Now()}
+	state.Pending = append(state.Pending, req)
+
+	return req, d.write(state)
+}
+
+func (d *moderationDao) GetRequest(id int) (ModerationRequest, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.read()
+	if err != nil {
+		return ModerationRequest{}, false, err
+	}
+
+	i := slices.IndexFunc(state.Pending, func(r ModerationRequest) bool { return r.Id == id })
+	if i < 0 {
+		return ModerationRequest{}, false, nil
+	}
+
+	return state.Pending[i], true, nil
+}
+
+func (d *moderationDao) IsPending(calendarId string, eventId string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.read()
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(state.Pending, func(r ModerationRequest) bool {
+		return r.Event.CalendarId == calendarId && r.Event.Id == eventId
+	}), nil
+}
+
+func (d *moderationDao) Decide(id int, approved bool, by string) (ModerationDecision, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.read()
+	if err != nil {
+		return ModerationDecision{}, false, err
+	}
+
+	i := slices.IndexFunc(state.Pending, func(r ModerationRequest) bool { return r.Id == id })
+	if i < 0 {
+		return ModerationDecision{}, false, nil
+	}
+
+	decision := ModerationDecision{Request: state.Pending[i], Approved: approved, By: by, DecidedAt: d.clock.Now()}
+	state.Pending = slices.Delete(state.Pending, i, i+1)
+	state.Decisions = append(state.Decisions, decision)
+
+	return decision, true, d.write(state)
+}
+
+func (d *moderationDao) GetDecisions() ([]ModerationDecision, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Decisions, nil
+}
+
+func (d *moderationDao) read() (moderationState, error) {
+	var state moderationState
+	if _, err := readJSONFile(d.cfg.ModerationFile, &state); err != nil {
+		return moderationState{}, errors.Wrap(err, "error reading moderation file")
+	}
+
+	return state, nil
+}
+
+func (d *moderationDao) write(state moderationState) error {
+	return errors.Wrap(writeJSONFile(d.cfg.ModerationFile, state), "error writing moderation file")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	moderationApprove = "approve"
+	moderationReject  = "reject"
+
+	rejectActionDelete  = "delete"
+	rejectActionDecline = "decline"
+)
+
+// moderationText describes the event awaiting a decision.
+func moderationText(req ModerationRequest, people peopleDirectory, now time.Time) string {
+	event := req.Event
+	creator := event.Creator
+	if person, ok := people.Lookup(creator); ok && !isBlank(person.Name) {
+		creator = person.Name
+	}
+
+	return fmt.Sprintf("🛡️ אירוע חדש ממתין לאישור\n\n%s\n%s\nנוצר על ידי: %s",
+		eventTitle(event), FormatWhen(event.Start, event.End, event.AllDay, now), creator)
+}
+
+func moderationChoices(req ModerationRequest) []Choice {
+	return []Choice{
+		{Text: "✅ אישור", Data: fmt.Sprintf("mod:%s:%d", moderationApprove, req.Id)},
+		{Text: "❌ דחייה", Data: fmt.Sprintf("mod:%s:%d", moderationReject, req.Id)},
+	}
+}
+
+// moderationCommands handles the decisions made in the moderation chat.
+// Rejected events are deleted, or declined, and their creator is told so.
+type moderationCommands struct {
+	// config returns the current configuration, which changes on reload.
+	config func() Config
+	calSvc CalendarService
+	telcli Telegram
+	modDao ModerationDao
+	people peopleDirectory
+	clock  Clock
+}
+
+func (c *moderationCommands) Register(d *Dispatcher) {
+	d.HandleCallback("mod", c.decide)
+}
+
+func (c *moderationCommands) decide(ctx context.Context, query *tgbotapi.CallbackQuery) error {
+	if query.Message == nil || query.Message.Chat == nil || query.Message.Chat.ID != c.config().ModerationChatId {
+		return c.telcli.AnswerCallback(query.ID, "אין הרשאה")
+	}
+	chatId, messageId := query.Message.Chat.ID, query.Message.MessageID
+
+	action, id, err := parseModerationData(query.Data)
+	if err != nil {
+		return err
+	}
+
+	req, ok, err := c.modDao.GetRequest(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.removeChoices(chatId, messageId)
+		return c.telcli.AnswerCallback(query.ID, "כבר התקבלה החלטה")
+	}
+
+	approved := action == moderationApprove
+	if !approved {
+		if err := c.reject(ctx, req.Event); err != nil {
+			_ = c.telcli.AnswerCallback(query.ID, "הדחייה נכשלה")
+			return errors.Wrap(err, "error rejecting event")
+		}
+	}
+
+	by := ""
+	if query.From != nil {
+		by = strings.TrimSpace(query.From.FirstName + " " + query.From.LastName)
+	}
+	decision, ok, err := c.modDao.Decide(id, approved, by)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.removeChoices(chatId, messageId)
+		return c.telcli.AnswerCallback(query.ID, "כבר התקבלה החלטה")
+	}
+
+	c.removeChoices(chatId, messageId)
+	title := eventTitle(req.Event)
+	if approved {
+		if err := c.telcli.AnswerCallback(query.ID, "אושר"); err != nil {
+			return err
+		}
+		return c.telcli.SendText(chatId, fmt.Sprintf("✅ %s אושר על ידי %s", title, decision.By))
+	}
+
+	if err := c.telcli.AnswerCallback(query.ID, "נדחה"); err != nil {
+		return err
+	}
+	if err := c.telcli.SendText(chatId, fmt.Sprintf("❌ %s נדחה על ידי %s", title, decision.By)); err != nil {
+		return err
+	}
+	return c.notifyCreator(req.Event)
+}
+
+func (c *moderationCommands) reject(ctx context.Context, event CalendarEvent) error {
+	switch c.config().ModerationRejectAction {
+	case rejectActionDelete:
+		return c.calSvc.DeleteEvent(ctx, event.CalendarId, event.Id)
+	case rejectActionDecline:
+		return c.calSvc.DeclineEvent(ctx, event.CalendarId, event.Id)
+	default:
+		return fmt.Errorf("unknown reject action: %q", c.config().ModerationRejectAction)
+	}
+}
+
+// notifyCreator lets the creator of a rejected event know about it, if they
+// can be reached on Telegram.
+func (c *moderationCommands) notifyCreator(event CalendarEvent) error {
+	person, ok := c.people.Lookup(event.Creator)
+	if !ok || person.TelegramId == 0 {
+		log.WithField("creator", event.Creator).Info("can't notify creator of rejected event")
+		return nil
+	}
+
+	removed := "הוסר מהיומן"
+	if c.config().ModerationRejectAction == rejectActionDecline {
+		removed = "ההזמנה אליו סורבה"
+	}
+	return c.telcli.SendText(person.TelegramId,
+		fmt.Sprintf("האירוע \"%s\" שהוספת ליומן לא אושר ו%s.", eventTitle(event), removed))
+}
+
+func (c *moderationCommands) removeChoices(chatId int64, messageId int) {
+	if err := c.telcli.RemoveChoices(chatId, messageId); err != nil {
+		log.WithError(err).Warn("error removing moderation buttons")
+	}
+}
+
+func parseModerationData(data string) (string, int, error) {
+	fields := strings.Split(data, ":")
+	if len(fields) != 3 || (fields[1] != moderationApprove && fields[1] != moderationReject) {
+		return "", 0, fmt.Errorf("invalid callback data: %q", data)
+	}
+	id, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "invalid callback data: %q", data)
+	}
+
+	return fields[1], id, nil
+}
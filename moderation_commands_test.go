@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ModerationCommandsSuite struct {
+	suite.Suite
+	filename   string
+	now        time.Time
+	event      CalendarEvent
+	cfg        Config
+	calSvcMock *CalendarServiceMock
+	telCliMock *TelegramClientMock
+	modDao     ModerationDao
+	cmds       *moderationCommands
+	dispatcher *Dispatcher
+}
+
+func TestModerationCommandsSuite(t *testing.T) {
+	suite.Run(t, new(ModerationCommandsSuite))
+}
+
+func (s *ModerationCommandsSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_moderation_commands", time.Now().Unix())
+	s.now = time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	s.event = CalendarEvent{
+		Id:         "event",
+		CalendarId: "family",
+		Title:      "Party",
+		Start:      s.now.Add(24 * time.Hour),
+		End:        s.now.Add(26 * time.Hour),
+		Creator:    "kid@example.com",
+	}
+}
+
+func (s *ModerationCommandsSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+	s.cfg = Config{ModerationChatId: -500, ModerationRejectAction: rejectActionDelete, ModerationFile: s.filename}
+	s.calSvcMock = &CalendarServiceMock{}
+	s.telCliMock = &TelegramClientMock{}
+	s.telCliMock.On("SendText", mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("AnswerCallback", mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("RemoveChoices", mock.Anything, mock.Anything).Return(nil)
+	clock := NewFakeClock(s.now)
+	s.modDao = NewModerationDao(s.cfg, clock)
+	s.cmds = &moderationCommands{
+		config: func() Config { return s.cfg },
+		calSvc: s.calSvcMock,
+		telcli: s.telCliMock,
+		modDao: s.modDao,
+		people: newPeopleDirectory([]Person{{Email: "kid@example.com", Name: "Kid", TelegramId: 33}}),
+		clock:  clock,
+	}
+	s.dispatcher = NewDispatcher()
+	s.cmds.Register(s.dispatcher)
+}
+
+func (s *ModerationCommandsSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *ModerationCommandsSuite) TestModerationText() {
+	req := ModerationRequest{Id: 7, Event: s.event}
+
+	s.Assert().Equal("🛡️ אירוע חדש ממתין לאישור\n\nParty\nמחר ב-10:00–12:00 (שעתיים)\nנוצר על ידי: Kid",
+		moderationText(req, s.cmds.people, s.now))
+	s.Assert().Equal([]Choice{{Text: "✅ אישור", Data: "mod:approve:7"}, {Text: "❌ דחייה", Data: "mod:reject:7"}},
+		moderationChoices(req))
+}
+
+func (s *ModerationCommandsSuite) TestApprove() {
+	req, err := s.modDao.AddRequest(s.event)
+	s.Require().NoError(err)
+
+	// SUT
+	s.press(-500, fmt.Sprintf("mod:approve:%d", req.Id))
+
+	s.calSvcMock.AssertNotCalled(s.T(), "DeleteEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "אושר")
+	s.telCliMock.AssertCalled(s.T(), "RemoveChoices", int64(-500), 9)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-500), "✅ Party אושר על ידי Mom")
+	s.telCliMock.AssertNotCalled(s.T(), "SendText", int64(33), mock.Anything)
+	decisions, err := s.modDao.GetDecisions()
+	s.Require().NoError(err)
+	s.Assert().Equal([]ModerationDecision{{Request: req, Approved: true, By: "Mom", DecidedAt: s.now}}, decisions)
+}
+
+func (s *ModerationCommandsSuite) TestReject() {
+	ctx := context.Background()
+	req, err := s.modDao.AddRequest(s.event)
+	s.Require().NoError(err)
+	s.calSvcMock.On("DeleteEvent", ctx, "family", "event").Return(nil)
+
+	// SUT
+	s.press(-500, fmt.Sprintf("mod:reject:%d", req.Id))
+
+	s.calSvcMock.AssertCalled(s.T(), "DeleteEvent", ctx, "family", "event")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-500), "❌ Party נדחה על ידי Mom")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(33), "האירוע \"Party\" שהוספת ליומן לא אושר והוסר מהיומן.")
+	decisions, err := s.modDao.GetDecisions()
+	s.Require().NoError(err)
+	s.Assert().Equal([]ModerationDecision{{Request: req, Approved: false, By: "Mom", DecidedAt: s.now}}, decisions)
+
+	// SUT - pressing again
+	s.press(-500, fmt.Sprintf("mod:reject:%d", req.Id))
+
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "DeleteEvent", 1)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "כבר התקבלה החלטה")
+}
+
+func (s *ModerationCommandsSuite) TestDecline() {
+	ctx := context.Background()
+	s.cfg.ModerationRejectAction = rejectActionDecline
+	req, err := s.modDao.AddRequest(s.event)
+	s.Require().NoError(err)
+	s.calSvcMock.On("DeclineEvent", ctx, "family", "event").Return(nil)
+
+	// SUT
+	s.press(-500, fmt.Sprintf("mod:reject:%d", req.Id))
+
+	s.calSvcMock.AssertCalled(s.T(), "DeclineEvent", ctx, "family", "event")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(33), "האירוע \"Party\" שהוספת ליומן לא אושר וההזמנה אליו סורבה.")
+}
+
+func (s *ModerationCommandsSuite) TestRejectFailure() {
+	req, err := s.modDao.AddRequest(s.event)
+	s.Require().NoError(err)
+	s.calSvcMock.On("DeleteEvent", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("forbidden"))
+
+	// SUT
+	s.press(-500, fmt.Sprintf("mod:reject:%d", req.Id))
+
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "הדחייה נכשלה")
+	// still pending, so it can be retried
+	_, ok, err := s.modDao.GetRequest(req.Id)
+	s.Require().NoError(err)
+	s.Assert().True(ok)
+}
+
+func (s *ModerationCommandsSuite) TestOtherChat() {
+	req, err := s.modDao.AddRequest(s.event)
+	s.Require().NoError(err)
+
+	// SUT
+	s.press(-100, fmt.Sprintf("mod:reject:%d", req.Id))
+
+	s.calSvcMock.AssertNotCalled(s.T(), "DeleteEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "AnswerCallback", "query", "אין הרשאה")
+}
+
+func (s *ModerationCommandsSuite) press(chatId int64, data string) {
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "query",
+		From:    &tgbotapi.User{ID: 1, FirstName: "Mom"},
+		Message: &tgbotapi.Message{MessageID: 9, Chat: &tgbotapi.Chat{ID: chatId, Type: "group"}},
+		Data:    data,
+	}})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ModerationDaoSuite struct {
+	suite.Suite
+	filename string
+	clock    *FakeClock
+	dao      ModerationDao
+}
+
+func TestModerationDaoSuite(t *testing.T) {
+	suite.Run(t, new(ModerationDaoSuite))
+}
+
+func (s *ModerationDaoSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_moderation", time.Now().Unix())
+	s.clock = NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+	s.dao = NewModerationDao(Config{ModerationFile: s.filename}, s.clock)
+}
+
+func (s *ModerationDaoSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *ModerationDaoSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *ModerationDaoSuite) TestDecide() {
+	at := s.clock.Now()
+	first, err := s.dao.AddRequest(CalendarEvent{Id: "first", Title: "First"})
+	s.Require().NoError(err)
+	second, err := s.dao.AddRequest(CalendarEvent{Id: "second", Title: "Second"})
+	s.Require().NoError(err)
+	s.Assert().NotEqual(first.Id, second.Id)
+	s.Assert().True(at.Equal(second.RequestedAt))
+
+	s.clock.Advance(time.Minute)
+	decision, ok, err := s.dao.Decide(second.Id, false, "Dana")
+	s.Require().NoError(err)
+	s.Require().True(ok)
+	s.Assert().Equal(ModerationDecision{Request: second, Approved: false, By: "Dana", DecidedAt: at.Add(time.Minute)}, decision)
+
+	// decided once only
+	_, ok, err = s.dao.Decide(second.Id, true, "Yoni")
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+	_, ok, err = s.dao.GetRequest(second.Id)
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+
+	isPending, err := s.dao.IsPending("", "second")
+	s.Require().NoError(err)
+	s.Assert().False(isPending)
+	isPending, err = s.dao.IsPending("", "first")
+	s.Require().NoError(err)
+	s.Assert().True(isPending)
+
+	pending, ok, err := s.dao.GetRequest(first.Id)
+	s.Require().NoError(err)
+	s.Assert().True(ok)
+	s.Assert().Equal(first, pending)
+
+	decisions, err := s.dao.GetDecisions()
+	s.Require().NoError(err)
+	s.Assert().Equal([]ModerationDecision{decision}, decisions)
+}
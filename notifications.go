@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxRecentNotifications is how many of the notifications sent lately are
+// kept for the admin API.
+const maxRecentNotifications = 100
+
+const (
+	notificationEvent   = "event"
+	notificationDigest  = "digest"
+	notificationCatchUp = "catch-up"
+)
+
+// SentNotification is a notification about events the bot sent to a chat.
+type SentNotification struct {
+	Id        int             `json:"id"`
+	ChatId    int64           `json:"chatId"`
+	MessageId int             `json:"messageId"`
+	Kind      string          `json:"kind"`
+	Events    []CalendarEvent `json:"events"`
+	// Since is the start of the changes a catch-up summarizes.
+	Since  time.Time `json:"since"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// recentNotifications keeps, in memory, the latest notifications sent
+// through it, so that they can be inspected and resent.
+type recentNotifications struct {
+	Telegram
+	clock Clock
+
+	mu      sync.Mutex
+	nextId  int
+	entries []SentNotification
+}
+
+func newRecentNotifications(telcli Telegram, clock Clock) *recentNotifications {
+	return &recentNotifications{
+		Telegram: telcli,
+		clock:    clock,
+	}
+}
+
+func (r *recentNotifications) NotifyEvent(chatId int64, event CalendarEvent) (int, error) {
+	sent, err := r.send(SentNotification{ChatId: chatId, Kind: notificationEvent, Events: []CalendarEvent{event}})
+	return sent.MessageId, err
+}
+
+func (r *recentNotifications) NotifyDigest(chatId int64, events []CalendarEvent) (int, error) {
+	sent, err := r.send(SentNotification{ChatId: chatId, Kind: notificationDigest, Events: events})
+	return sent.MessageId, err
+}
+
+func (r *recentNotifications) NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error) {
+	sent, err := r.send(SentNotification{ChatId: chatId, Kind: notificationCatchUp, Events: events, Since: since})
+	return sent.MessageId, err
+}
+
+// send sends the notification, and records it once sent.
+func (r *recentNotifications) send(n SentNotification) (SentNotification, error) {
+	var err error
+	switch n.Kind {
+	case notificationEvent:
+		n.MessageId, err = r.Telegram.NotifyEvent(n.ChatId, n.Events[0])
+	case notificationDigest:
+		n.MessageId, err = r.Telegram.NotifyDigest(n.ChatId, n.Events)
+	case notificationCatchUp:
+		n.MessageId, err = r.Telegram.NotifyCatchUp(n.ChatId, n.Since, n.Events)
+	default:
+		err = fmt.Errorf("unknown notification kind: %q", n.Kind)
+	}
+	if err != nil {
+		return SentNotification{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextId++
+	n.Id = r.nextId
+	n.SentAt = r.clock.Now()
+	r.entries = append(r.entries, n)
+	if len(r.entries) > maxRecentNotifications {
+		r.entries = slices.Delete(r.entries, 0, len(r.entries)-maxRecentNotifications)
+	}
+	return n, nil
+}
+
+// Recent returns the notifications sent lately, the latest first.
+func (r *recentNotifications) Recent() []SentNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recent := slices.Clone(r.entries)
+	slices.Reverse(recent)
+	return recent
+}
+
+// Resend sends the notification again, to the same chat. It reports false if
+// the notification isn't among the recent ones.
+func (r *recentNotifications) Resend(id int) (SentNotification, bool, error) {
+	r.mu.Lock()
+	i := slices.IndexFunc(r.entries, func(n SentNotification) bool { return n.Id == id })
+	var n SentNotification
+	if i >= 0 {
+		n = r.entries[i]
+	}
+	r.mu.Unlock()
+	if i < 0 {
+		return SentNotification{}, false, nil
+	}
+
+	sent, err := r.send(n)
+	return sent, true, err
+}
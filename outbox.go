@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OutboxEntry is a notification held back until its chat's quiet hours end,
+// or an email that failed to send, to be retried on the next cycle.
+type OutboxEntry struct {
+	ChatId int64 `json:"chatId"`
+	// Email is the recipient of an email, in which case ChatId is unset.
+	Email    string        `json:"email,omitempty"`
+	Event    CalendarEvent `json:"event"`
+	QueuedAt time.Time     `json:"queuedAt"`
+}
+
+type OutboxDao interface {
+	GetOutbox() ([]OutboxEntry, error)
+	Enqueue(entry OutboxEntry) error
+	RemoveChat(chatId int64) error
+	RemoveEmail(email string) error
+}
+
+func NewOutboxDao(cfg Config) OutboxDao {
+	return &outboxDao{
+		cfg: cfg,
+	}
+}
+
+type outboxDao struct {
+	cfg Config
+	mu  sync.Mutex
+}
+
+func (d *outboxDao) GetOutbox() ([]OutboxEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.read()
+}
+
+func (d *outboxDao) Enqueue(entry OutboxEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	return d.write(append(entries, entry))
+}
+
+// RemoveChat drops all the entries queued for the chat, once they have been
+// delivered.
+func (d *outboxDao) RemoveChat(chatId int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	return d.write(slices.DeleteFunc(entries, func(e OutboxEntry) bool { return e.Email == "" && e.ChatId == chatId }))
+}
+
+// RemoveEmail drops all the emails queued for the recipient, once they have
+// been sent.
+func (d *outboxDao) RemoveEmail(email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	return d.write(slices.DeleteFunc(entries, func(e OutboxEntry) bool { return e.Email == email }))
+}
+
+func (d *outboxDao) read() ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	if _, err := readJSONFile(d.cfg.OutboxFile, &entries); err != nil {
+		return nil, errors.Wrap(err, "error reading outbox file")
+	}
+
+	return entries, nil
+}
+
+func (d *outboxDao) write(entries []OutboxEntry) error {
+	return errors.Wrap(writeJSONFile(d.cfg.OutboxFile, entries), "error writing outbox file")
+}
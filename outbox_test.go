@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OutboxDaoSuite struct {
+	suite.Suite
+	filename string
+	dao      OutboxDao
+}
+
+func TestOutboxDaoSuite(t *testing.T) {
+	suite.Run(t, new(OutboxDaoSuite))
+}
+
+func (s *OutboxDaoSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_outbox", time.Now().Unix())
+	s.dao = NewOutboxDao(Config{OutboxFile: s.filename})
+}
+
+func (s *OutboxDaoSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *OutboxDaoSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *OutboxDaoSuite) TestGetBeforeEnqueue() {
+	entries, err := s.dao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Empty(entries)
+}
+
+func (s *OutboxDaoSuite) TestEnqueueRemove() {
+	queuedAt := time.Date(2024, time.June, 3, 23, 0, 0, 0, time.UTC)
+	event := CalendarEvent{
+		CalendarId: "family",
+		Title:      "Dentist",
+		Start:      queuedAt.Add(24 * time.Hour),
+		End:        queuedAt.Add(25 * time.Hour),
+		Status:     StatusUpdated,
+	}
+	first := OutboxEntry{ChatId: 1, Event: event, QueuedAt: queuedAt}
+	second := OutboxEntry{ChatId: 2, Event: event, QueuedAt: queuedAt}
+	third := OutboxEntry{ChatId: 1, Event: event, QueuedAt: queuedAt.Add(time.Minute)}
+	s.Require().NoError(s.dao.Enqueue(first))
+	s.Require().NoError(s.dao.Enqueue(second))
+	s.Require().NoError(s.dao.Enqueue(third))
+
+	entries, err := s.dao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Equal([]OutboxEntry{first, second, third}, entries)
+
+	s.Require().NoError(s.dao.RemoveChat(1))
+	entries, err = s.dao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Equal([]OutboxEntry{second}, entries)
+}
+
+func (s *OutboxDaoSuite) TestRemoveEmail() {
+	queuedAt := time.Date(2024, time.June, 3, 23, 0, 0, 0, time.UTC)
+	event := CalendarEvent{CalendarId: "family", Title: "Dentist", Start: queuedAt.Add(24 * time.Hour)}
+	message := OutboxEntry{ChatId: 0, Event: event, QueuedAt: queuedAt}
+	email := OutboxEntry{Email: "grandma@example.com", Event: event, QueuedAt: queuedAt}
+	s.Require().NoError(s.dao.Enqueue(message))
+	s.Require().NoError(s.dao.Enqueue(email))
+
+	// emails aren't any chat's
+	s.Require().NoError(s.dao.RemoveChat(0))
+	entries, err := s.dao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Equal([]OutboxEntry{email}, entries)
+
+	s.Require().NoError(s.dao.RemoveEmail("grandma@example.com"))
+	entries, err = s.dao.GetOutbox()
+	s.Require().NoError(err)
+	s.Assert().Empty(entries)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PausedCalendarsDao keeps the calendars the engine skips. Changes made to a
+// calendar while it's paused are never announced.
+type PausedCalendarsDao interface {
+	GetPaused() ([]string, error)
+	SetPaused(calendarId string, paused bool) error
+}
+
+func NewPausedCalendarsDao(cfg Config) PausedCalendarsDao {
+	return &pausedCalendarsDao{
+		cfg: cfg,
+	}
+}
+
+type pausedCalendarsDao struct {
+	cfg Config
+	mu  sync.Mutex
+}
+
+func (d *pausedCalendarsDao) GetPaused() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.read()
+}
+
+func (d *pausedCalendarsDao) SetPaused(calendarId string, paused bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	calendars, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(calendars, calendarId) == paused {
+		return nil
+	}
+	if paused {
+		calendars = append(calendars, calendarId)
+	} else {
+		calendars = slices.DeleteFunc(calendars, func(id string) bool { return id == calendarId })
+	}
+
+	return d.write(calendars)
+}
+
+func (d *pausedCalendarsDao) read() ([]string, error) {
+	var calendars []string
+	if _, err := readJSONFile(d.cfg.PausedCalendarsFile, &calendars); err != nil {
+		return nil, errors.Wrap(err, "error reading paused calendars file")
+	}
+
+	return calendars, nil
+}
+
+func (d *pausedCalendarsDao) write(calendars []string) error {
+	return errors.Wrap(writeJSONFile(d.cfg.PausedCalendarsFile, calendars), "error writing paused calendars file")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Person maps a Google account to its Telegram identity.
+type Person struct {
+	Email            string `json:"email"`
+	Name             string `json:"name"`
+	TelegramId       int64  `json:"telegramId"`
+	TelegramUsername string `json:"telegramUsername"`
+}
+
+// peopleDirectory looks up people by their Google email. The zero value is an
+// empty directory.
+type peopleDirectory struct {
+	byEmail map[string]Person
+}
+
+func newPeopleDirectory(people []Person) peopleDirectory {
+	byEmail := make(map[string]Person, len(people))
+	for _, p := range people {
+		byEmail[normalizeEmail(p.Email)] = p
+	}
+
+	return peopleDirectory{byEmail: byEmail}
+}
+
+// LoadPeople reads a JSON array of people from the given file. An empty path
+// yields an empty directory.
+func LoadPeople(path string) (peopleDirectory, error) {
+	if path == "" {
+		return peopleDirectory{}, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return peopleDirectory{}, errors.Wrap(err, "error reading people file")
+	}
+
+	var people []Person
+	if err := json.Unmarshal(b, &people); err != nil {
+		return peopleDirectory{}, errors.Wrap(err, "error parsing people file")
+	}
+
+	return newPeopleDirectory(people), nil
+}
+
+func (d peopleDirectory) Lookup(email string) (Person, bool) {
+	p, ok := d.byEmail[normalizeEmail(email)]
+	return p, ok
+}
+
+// TelegramIds returns the Telegram user ids of the given emails, skipping
+// people that are unknown or have no Telegram id.
+func (d peopleDirectory) TelegramIds(emails []string) []int64 {
+	var ids []int64
+	for _, email := range emails {
+		if p, ok := d.Lookup(email); ok && p.TelegramId != 0 {
+			ids = append(ids, p.TelegramId)
+		}
+	}
+
+	return ids
+}
+
+// HasTelegramId reports whether someone in the directory has the given
+// Telegram user id.
+func (d peopleDirectory) HasTelegramId(id int64) bool {
+	for _, p := range d.byEmail {
+		if id != 0 && p.TelegramId == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mention renders a reference to the person behind the given email, notifying
+// them on Telegram when their identity is known.
+func (d peopleDirectory) Mention(email string, m markup) string {
+	p, ok := d.Lookup(email)
+	if !ok {
+		return m.Escape(email)
+	}
+
+	name := p.Name
+	if isBlank(name) {
+		name = p.Email
+	}
+	username := strings.TrimPrefix(p.TelegramUsername, "@")
+
+	switch {
+	case p.TelegramId != 0:
+		return m.Mention(name, p.TelegramId)
+	case !isBlank(username):
+		return m.Escape("@" + username)
+	default:
+		return m.Escape(name)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
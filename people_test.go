@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPeople(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "people.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"email": "Mom@Example.com", "name": "Mom", "telegramId": 11},
+		{"email": "dad@example.com", "name": "Dad", "telegramUsername": "@dad_1"}
+	]`), 0644))
+
+	people, err := LoadPeople(path)
+	require.NoError(t, err)
+
+	mom, ok := people.Lookup("mom@example.com")
+	require.True(t, ok)
+	assert.Equal(t, int64(11), mom.TelegramId)
+	assert.Equal(t, []int64{11}, people.TelegramIds([]string{"dad@example.com", " MOM@example.com", "nobody@example.com"}))
+	assert.Equal(t, "@dad\\_1", people.Mention("dad@example.com", markdownV2Markup{}))
+	assert.True(t, people.HasTelegramId(11))
+	assert.False(t, people.HasTelegramId(0))
+}
+
+func TestLoadPeopleEmptyPath(t *testing.T) {
+	people, err := LoadPeople("")
+	require.NoError(t, err)
+
+	_, ok := people.Lookup("mom@example.com")
+	assert.False(t, ok)
+	assert.Equal(t, "mom@example\\.com", people.Mention("mom@example.com", markdownV2Markup{}))
+}
+
+func TestLoadPeopleInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "people.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"email": "mom@example.com"}`), 0644))
+
+	_, err := LoadPeople(path)
+	assert.Error(t, err)
+
+	_, err = LoadPeople(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Place is a location that can be navigated to. Aliases are other names the
+// place goes by in event locations.
+type Place struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Address   string   `json:"address"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+}
+
+// addressBook looks up places by their name, for event locations that don't
+// include coordinates. The zero value is an empty address book.
+type addressBook struct {
+	byName map[string]Place
+}
+
+func newAddressBook(places []Place) addressBook {
+	byName := make(map[string]Place, len(places))
+	for _, p := range places {
+		for _, name := range append([]string{p.Name}, p.Aliases...) {
+			if !isBlank(name) {
+				byName[normalizePlaceName(name)] = p
+			}
+		}
+	}
+
+	return addressBook{byName: byName}
+}
+
+// LoadAddressBook reads a JSON array of places from the given file. An empty
+// path yields an empty address book.
+func LoadAddressBook(path string) (addressBook, error) {
+	if path == "" {
+		return addressBook{}, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return addressBook{}, errors.Wrap(err, "error reading address book file")
+	}
+
+	var places []Place
+	if err := json.Unmarshal(b, &places); err != nil {
+		return addressBook{}, errors.Wrap(err, "error parsing address book file")
+	}
+
+	for _, p := range places {
+		if !validCoordinates(p.Latitude, p.Longitude) {
+			return addressBook{}, errors.Errorf("invalid coordinates for place %q", p.Name)
+		}
+	}
+
+	return newAddressBook(places), nil
+}
+
+var (
+	geoURIPattern      = regexp.MustCompile(`(?i)\bgeo:(-?\d+(?:\.\d+)?),(-?\d+(?:\.\d+)?)`)
+	coordinatesPattern = regexp.MustCompile(`(-?\d{1,2}\.\d+)\s*,\s*(-?\d{1,3}\.\d+)`)
+)
+
+// Resolve finds the place an event location refers to: a geo URI, a pair of
+// decimal coordinates (as in map links), or the name of a place in the
+// address book, either alone or before the first comma.
+func (b addressBook) Resolve(location string) (Place, bool) {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return Place{}, false
+	}
+
+	for _, pattern := range []*regexp.Regexp{geoURIPattern, coordinatesPattern} {
+		if p, ok := matchCoordinates(pattern, location); ok {
+			return p, true
+		}
+	}
+
+	name, _, _ := strings.Cut(location, ",")
+	for _, candidate := range []string{location, name} {
+		if p, ok := b.byName[normalizePlaceName(candidate)]; ok {
+			return p, true
+		}
+	}
+
+	return Place{}, false
+}
+
+func matchCoordinates(pattern *regexp.Regexp, location string) (Place, bool) {
+	match := pattern.FindStringSubmatch(location)
+	if match == nil {
+		return Place{}, false
+	}
+
+	lat, latErr := strconv.ParseFloat(match[1], 64)
+	lon, lonErr := strconv.ParseFloat(match[2], 64)
+	if latErr != nil || lonErr != nil || !validCoordinates(lat, lon) {
+		return Place{}, false
+	}
+
+	return Place{Latitude: lat, Longitude: lon}, true
+}
+
+func validCoordinates(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+func normalizePlaceName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
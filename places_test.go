@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressBookResolve(t *testing.T) {
+	grandma := Place{Name: "Grandma", Aliases: []string{"סבתא"}, Address: "Herzl 5, Haifa", Latitude: 32.79, Longitude: 34.99}
+	book := newAddressBook([]Place{grandma})
+
+	tests := []struct {
+		name     string
+		location string
+		expected Place
+		found    bool
+	}{
+		{
+			name:     "geo uri",
+			location: "geo:32.0853,34.7818;u=35",
+			expected: Place{Latitude: 32.0853, Longitude: 34.7818},
+			found:    true,
+		},
+		{
+			name:     "coordinates",
+			location: "Beach (32.0853, 34.7818)",
+			expected: Place{Latitude: 32.0853, Longitude: 34.7818},
+			found:    true,
+		},
+		{
+			name:     "map link",
+			location: "https://www.google.com/maps/@31.7683,35.2137,15z",
+			expected: Place{Latitude: 31.7683, Longitude: 35.2137},
+			found:    true,
+		},
+		{
+			name:     "out of range coordinates",
+			location: "95.5, 34.7",
+		},
+		{
+			name:     "address book name",
+			location: "  grandma ",
+			expected: grandma,
+			found:    true,
+		},
+		{
+			name:     "address book alias before a comma",
+			location: "סבתא, חדר האוכל",
+			expected: grandma,
+			found:    true,
+		},
+		{
+			name:     "street address with numbers",
+			location: "Herzl 12, 5th floor",
+		},
+		{
+			name:     "unknown place",
+			location: "Somewhere",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, found := book.Resolve(test.location)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestLoadAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "places.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "Grandma", "address": "Herzl 5, Haifa", "latitude": 32.79, "longitude": 34.99}
+	]`), 0644))
+
+	book, err := LoadAddressBook(path)
+	require.NoError(t, err)
+
+	p, ok := book.Resolve("GRANDMA")
+	require.True(t, ok)
+	assert.Equal(t, "Herzl 5, Haifa", p.Address)
+
+	empty, err := LoadAddressBook("")
+	require.NoError(t, err)
+	_, ok = empty.Resolve("Grandma")
+	assert.False(t, ok)
+}
+
+func TestLoadAddressBookInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "places.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name": "Nowhere", "latitude": 132, "longitude": 0}]`), 0644))
+
+	_, err := LoadAddressBook(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const pollHelp = `הצבעה על מועד לפעילות, מבין הזמנים שבהם כל היומנים פנויים:
+
+/poll <כותרת> | [משך] [אחרי HH:MM] [לפני HH:MM] [היום|השבוע]
+למשל: /poll ארוחת ערב משפחתית | 2h אחרי 19:00`
+
+// maxPollOptions is the most options Telegram allows in a poll.
+const maxPollOptions = 10
+
+// pollCommands schedules activities by polling the chat over candidate
+// slots, and creates the event once enough people agree on one.
+type pollCommands struct {
+	// config returns the current configuration, which changes on reload.
+	config  func() Config
+	calSvc  CalendarService
+	telcli  Telegram
+	pollDao PollDao
+	clock   Clock
+	loc     *time.Location
+	people  peopleDirectory
+}
+
+func (c *pollCommands) Register(d *Dispatcher) {
+	d.HandleCommand("poll", c.poll)
+	d.HandlePollAnswer(c.vote)
+}
+
+func (c *pollCommands) poll(ctx context.Context, msg *tgbotapi.Message) error {
+	if !allowedChat(c.config(), c.people, msg.Chat, msg.From) {
+		return c.telcli.SendText(msg.Chat.ID, "אין הרשאה")
+	}
+
+	title, args, _ := strings.Cut(msg.CommandArguments(), "|")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return c.telcli.SendText(msg.Chat.ID, pollHelp)
+	}
+	q, err := parseFreeQuery(args)
+	if err != nil {
+		return c.telcli.SendText(msg.Chat.ID, err.Error()+"\n\n"+pollHelp)
+	}
+
+	now := c.clock.Now().In(c.loc)
+	busy, err := c.calSvc.GetBusy(ctx, c.config().Calendars(), now, now.AddDate(0, 0, q.days))
+	if err != nil {
+		return errors.Wrap(err, "error getting free/busy")
+	}
+
+	slots := findFreeSlots(q, busy, now, maxPollOptions)
+	if len(slots) < 2 {
+		return c.telcli.SendText(msg.Chat.ID, "לא מצאתי מספיק זמנים שבהם כולם פנויים כדי להצביע 😕")
+	}
+
+	options := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		options = append(options, slot.String())
+	}
+	pollId, messageId, err := c.telcli.SendPoll(msg.Chat.ID, fmt.Sprintf("מתי נקבע את %s?", title), options)
+	if err != nil {
+		return errors.Wrap(err, "error sending poll")
+	}
+
+	return c.pollDao.SavePoll(Poll{
+		Id:        pollId,
+		ChatId:    msg.Chat.ID,
+		MessageId: messageId,
+		Title:     title,
+		Slots:     slots,
+		Deadline:  now.Add(c.config().PollDuration),
+	})
+}
+
+func (c *pollCommands) vote(ctx context.Context, answer *tgbotapi.PollAnswer) error {
+	poll, ok, err := c.pollDao.Vote(answer.PollID, answer.User.ID, answer.OptionIDs)
+	if err != nil || !ok {
+		return err
+	}
+
+	return c.decide(ctx, poll)
+}
+
+// CloseExpired decides the polls whose deadline has passed. It's meant to
+// run periodically. A poll that fails to close doesn't hold up the others.
+func (c *pollCommands) CloseExpired(ctx context.Context) error {
+	polls, err := c.pollDao.GetPolls()
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		if err := c.decide(ctx, poll); err != nil {
+			log.WithError(err).WithField("pollId", poll.Id).Error("error deciding poll")
+		}
+	}
+
+	return nil
+}
+
+// decide closes the poll if it's decided, creating the winning event and
+// announcing it.
+func (c *pollCommands) decide(ctx context.Context, poll Poll) error {
+	winner, decided := poll.Winner(c.config().PollQuorum, c.clock.Now())
+	if !decided {
+		return nil
+	}
+
+	// a vote coming in right as the deadline passes might try closing it too
+	if _, ok, err := c.pollDao.Close(poll.Id); err != nil || !ok {
+		return err
+	}
+	if err := c.telcli.StopPoll(poll.ChatId, poll.MessageId); err != nil {
+		log.WithError(err).WithField("pollId", poll.Id).Warn("error stopping poll")
+	}
+
+	if winner < 0 {
+		return c.telcli.SendText(poll.ChatId, fmt.Sprintf("ההצבעה על %s הסתיימה בלי מועד מוסכם", poll.Title))
+	}
+
+	slot := TimeRange{Start: poll.Slots[winner].Start.In(c.loc), End: poll.Slots[winner].End.In(c.loc)}
+	event := CalendarEvent{Title: poll.Title, Start: slot.Start, End: slot.End}
+	if _, err := c.calSvc.CreateEvent(ctx, c.config().CalendarId, event); err != nil {
+		_ = c.telcli.SendText(poll.ChatId, fmt.Sprintf("לא הצלחתי ליצור ביומן את %s ב%s", poll.Title, slot))
+		return errors.Wrap(err, "error creating event")
+	}
+
+	votes := poll.Tally()[winner]
+	return c.telcli.SendText(poll.ChatId, fmt.Sprintf("🎉 נקבע: %s ב%s (%s)", poll.Title, slot,
+		hebrewCount(votes, "קול אחד", "2 קולות", "קולות")))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type PollCommandsSuite struct {
+	suite.Suite
+	filename   string
+	tz         *time.Location
+	clock      *FakeClock
+	calSvcMock *CalendarServiceMock
+	telCliMock *TelegramClientMock
+	pollDao    PollDao
+	dispatcher *Dispatcher
+	cmds       *pollCommands
+}
+
+func TestPollCommandsSuite(t *testing.T) {
+	suite.Run(t, new(PollCommandsSuite))
+}
+
+func (s *PollCommandsSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_poll_commands", time.Now().Unix())
+	var err error
+	s.tz, err = time.LoadLocation("Asia/Jerusalem")
+	s.Require().NoError(err)
+}
+
+func (s *PollCommandsSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+	cfg := Config{CalendarId: "family", TelegramChatId: -100, PollsFile: s.filename, PollQuorum: 2, PollDuration: 24 * time.Hour}
+	s.clock = NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, s.tz))
+	s.calSvcMock = &CalendarServiceMock{}
+	s.telCliMock = &TelegramClientMock{}
+	s.telCliMock.On("SendText", mock.Anything, mock.Anything).Return(nil)
+	s.telCliMock.On("SendPoll", mock.Anything, mock.Anything, mock.Anything).Return("poll", 42, nil)
+	s.telCliMock.On("StopPoll", mock.Anything, mock.Anything).Return(nil)
+	s.pollDao = NewPollDao(cfg)
+	s.dispatcher = NewDispatcher()
+	s.cmds = &pollCommands{config: func() Config { return cfg }, calSvc: s.calSvcMock, telcli: s.telCliMock, pollDao: s.pollDao, clock: s.clock, loc: s.tz}
+	s.cmds.Register(s.dispatcher)
+}
+
+func (s *PollCommandsSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *PollCommandsSuite) TestQuorum() {
+	ctx := context.Background()
+	s.startPoll()
+	s.telCliMock.AssertCalled(s.T(), "SendPoll", int64(-100), "מתי נקבע את ארוחת ערב?", []string{
+		"יום שני 3.6 19:00–21:00",
+		"יום שלישי 4.6 19:00–21:00",
+		"יום רביעי 5.6 19:00–21:00",
+		"יום חמישי 6.6 19:00–21:00",
+		"יום שישי 7.6 19:00–21:00",
+		"שבת 8.6 19:00–21:00",
+		"יום ראשון 9.6 19:00–21:00",
+	})
+
+	winner := CalendarEvent{
+		Title: "ארוחת ערב",
+		Start: time.Date(2024, time.June, 4, 19, 0, 0, 0, s.tz),
+		End:   time.Date(2024, time.June, 4, 21, 0, 0, 0, s.tz),
+	}
+	s.calSvcMock.On("CreateEvent", ctx, "family", winner).Return(winner, nil)
+
+	// SUT
+	s.answer(1, 0, 1)
+	s.calSvcMock.AssertNotCalled(s.T(), "CreateEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.answer(2, 1)
+
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "CreateEvent", 1)
+	s.telCliMock.AssertCalled(s.T(), "StopPoll", int64(-100), 42)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "🎉 נקבע: ארוחת ערב ביום שלישי 4.6 19:00–21:00 (2 קולות)")
+	polls, err := s.pollDao.GetPolls()
+	s.Require().NoError(err)
+	s.Assert().Empty(polls)
+
+	// late votes are ignored
+	s.answer(3, 1)
+	s.calSvcMock.AssertNumberOfCalls(s.T(), "CreateEvent", 1)
+}
+
+func (s *PollCommandsSuite) TestDeadline() {
+	ctx := context.Background()
+	s.startPoll()
+	s.answer(1, 0)
+
+	// SUT - before the deadline, a single vote isn't enough
+	s.clock.Advance(8 * time.Hour)
+	s.Require().NoError(s.cmds.CloseExpired(ctx))
+	s.calSvcMock.AssertNotCalled(s.T(), "CreateEvent", mock.Anything, mock.Anything, mock.Anything)
+
+	// SUT - once the deadline passes the leading slot wins
+	winner := CalendarEvent{
+		Title: "ארוחת ערב",
+		Start: time.Date(2024, time.June, 3, 19, 0, 0, 0, s.tz),
+		End:   time.Date(2024, time.June, 3, 21, 0, 0, 0, s.tz),
+	}
+	s.calSvcMock.On("CreateEvent", ctx, "family", winner).Return(winner, nil)
+	s.Require().NoError(s.pollDao.SavePoll(s.withDeadline(s.clock.Now())))
+	s.Require().NoError(s.cmds.CloseExpired(ctx))
+
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "🎉 נקבע: ארוחת ערב ביום שני 3.6 19:00–21:00 (קול אחד)")
+	s.telCliMock.AssertCalled(s.T(), "StopPoll", int64(-100), 42)
+}
+
+func (s *PollCommandsSuite) TestDeadlineWithoutVotes() {
+	s.startPoll()
+
+	// SUT - nobody voted by the deadline
+	s.clock.Advance(24 * time.Hour)
+	s.Require().NoError(s.cmds.CloseExpired(context.Background()))
+
+	s.calSvcMock.AssertNotCalled(s.T(), "CreateEvent", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "ההצבעה על ארוחת ערב הסתיימה בלי מועד מוסכם")
+	s.telCliMock.AssertCalled(s.T(), "StopPoll", int64(-100), 42)
+}
+
+func (s *PollCommandsSuite) TestDeadlineFailureDoesNotBlockOtherPolls() {
+	ctx := context.Background()
+	slot := TimeRange{Start: time.Date(2024, time.June, 3, 19, 0, 0, 0, s.tz), End: time.Date(2024, time.June, 3, 21, 0, 0, 0, s.tz)}
+	expired := s.clock.Now()
+	s.Require().NoError(s.pollDao.SavePoll(Poll{Id: "failing", ChatId: -100, MessageId: 1, Title: "טיול", Slots: []TimeRange{slot}, Votes: map[int64][]int{1: {0}}, Deadline: expired}))
+	s.Require().NoError(s.pollDao.SavePoll(Poll{Id: "other", ChatId: -100, MessageId: 2, Title: "ארוחת ערב", Slots: []TimeRange{slot}, Deadline: expired}))
+	s.calSvcMock.On("CreateEvent", ctx, "family", mock.Anything).Return(CalendarEvent{}, errors.New("calendar is down"))
+
+	// SUT
+	s.Require().NoError(s.cmds.CloseExpired(ctx))
+
+	polls, err := s.pollDao.GetPolls()
+	s.Require().NoError(err)
+	s.Assert().Empty(polls)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "לא הצלחתי ליצור ביומן את טיול ביום שני 3.6 19:00–21:00")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "ההצבעה על ארוחת ערב הסתיימה בלי מועד מוסכם")
+}
+
+func (s *PollCommandsSuite) TestUnknownChat() {
+	// SUT
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{Message: commandMessage(-200, "group", "/poll ארוחת ערב | 2h")})
+
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-200), "אין הרשאה")
+	s.calSvcMock.AssertNotCalled(s.T(), "GetBusy", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertNotCalled(s.T(), "SendPoll", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *PollCommandsSuite) withDeadline(deadline time.Time) Poll {
+	polls, err := s.pollDao.GetPolls()
+	s.Require().NoError(err)
+	s.Require().Len(polls, 1)
+	polls[0].Deadline = deadline
+	return polls[0]
+}
+
+func (s *PollCommandsSuite) TestNotEnoughSlots() {
+	s.calSvcMock.On("GetBusy", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]TimeRange{}, nil)
+
+	// SUT
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{Message: commandMessage(-100, "group", "/poll ארוחת ערב | 2h אחרי 19:00 לפני 21:00 היום")})
+
+	s.telCliMock.AssertNotCalled(s.T(), "SendPoll", mock.Anything, mock.Anything, mock.Anything)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), "לא מצאתי מספיק זמנים שבהם כולם פנויים כדי להצביע 😕")
+}
+
+func (s *PollCommandsSuite) TestHelp() {
+	// SUT
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{Message: commandMessage(-100, "group", "/poll")})
+
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), pollHelp)
+	s.calSvcMock.AssertNotCalled(s.T(), "GetBusy", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *PollCommandsSuite) startPoll() {
+	s.calSvcMock.On("GetBusy", mock.Anything, []string{"family"}, mock.Anything, mock.Anything).Return([]TimeRange{}, nil)
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{Message: commandMessage(-100, "group", "/poll ארוחת ערב | 2h אחרי 19:00 לפני 21:00")})
+	polls, err := s.pollDao.GetPolls()
+	s.Require().NoError(err)
+	s.Require().Len(polls, 1)
+}
+
+func (s *PollCommandsSuite) answer(userId int64, options ...int) {
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{PollAnswer: &tgbotapi.PollAnswer{
+		PollID:    "poll",
+		User:      tgbotapi.User{ID: userId},
+		OptionIDs: options,
+	}})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Poll is a Telegram poll asking when to schedule an activity, one option
+// per candidate slot.
+type Poll struct {
+	Id        string          `json:"id"`
+	ChatId    int64           `json:"chatId"`
+	MessageId int             `json:"messageId"`
+	Title     string          `json:"title"`
+	Slots     []TimeRange     `json:"slots"`
+	Votes     map[int64][]int `json:"votes,omitempty"`
+	Deadline  time.Time       `json:"deadline"`
+}
+
+// Tally counts the votes for each of the slots.
+func (p Poll) Tally() []int {
+	counts := make([]int, len(p.Slots))
+	for _, options := range p.Votes {
+		for _, option := range options {
+			if option >= 0 && option < len(counts) {
+				counts[option]++
+			}
+		}
+	}
+
+	return counts
+}
+
+// Winner decides the poll once a slot has the quorum's votes, or else once
+// the deadline passes, in favor of the slot with the most votes. Ties go to
+// the earliest slot, and slots that have already started can't win. The
+// index is -1 for a poll decided with no winner.
+func (p Poll) Winner(quorum int, now time.Time) (int, bool) {
+	winner, best := -1, 0
+	for i, count := range p.Tally() {
+		if count > best && p.Slots[i].Start.After(now) {
+			winner, best = i, count
+		}
+	}
+
+	if quorum > 0 && best >= quorum {
+		return winner, true
+	}
+	if now.Before(p.Deadline) {
+		return -1, false
+	}
+	return winner, true
+}
+
+type PollDao interface {
+	GetPolls() ([]Poll, error)
+	SavePoll(poll Poll) error
+	// Vote replaces the user's votes in the poll. It reports false if the
+	// poll isn't open.
+	Vote(pollId string, userId int64, options []int) (Poll, bool, error)
+	// Close removes the poll, reporting false if it was already closed, so
+	// only one caller gets to act on the result.
+	Close(pollId string) (Poll, bool, error)
+}
+
+func NewPollDao(cfg Config) PollDao {
+	return &pollDao{
+		cfg: cfg,
+	}
+}
+
+type pollDao struct {
+	cfg Config
+	mu  sync.Mutex
+}
+
+func (d *pollDao) GetPolls() ([]Poll, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.read()
+}
+
+func (d *pollDao) SavePoll(poll Poll) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	polls, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	if i := slices.IndexFunc(polls, func(p Poll) bool { return p.Id == poll.Id }); i >= 0 {
+		polls[i] = poll
+	} else {
+		polls = append(polls, poll)
+	}
+
+	return d.write(polls)
+}
+
+func (d *pollDao) Vote(pollId string, userId int64, options []int) (Poll, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	polls, err := d.read()
+	if err != nil {
+		return Poll{}, false, err
+	}
+
+	i := slices.IndexFunc(polls, func(p Poll) bool { return p.Id == pollId })
+	if i < 0 {
+		return Poll{}, false, nil
+	}
+
+	if polls[i].Votes == nil {
+		polls[i].Votes = make(map[int64][]int)
+	}
+	if len(options) == 0 {
+		delete(polls[i].Votes, userId)
+	} else {
+		polls[i].Votes[userId] = options
+	}
+
+	return polls[i], true, d.write(polls)
+}
+
+func (d *pollDao) Close(pollId string) (Poll, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	polls, err := d.read()
+	if err != nil {
+		return Poll{}, false, err
+	}
+
+	i := slices.IndexFunc(polls, func(p Poll) bool { return p.Id == pollId })
+	if i < 0 {
+		return Poll{}, false, nil
+	}
+
+	poll := polls[i]
+	return poll, true, d.write(slices.Delete(polls, i, i+1))
+}
+
+func (d *pollDao) read() ([]Poll, error) {
+	var polls []Poll
+	if _, err := readJSONFile(d.cfg.PollsFile, &polls); err != nil {
+		return nil, errors.Wrap(err, "error reading polls file")
+	}
+
+	return polls, nil
+}
+
+func (d *pollDao) write(polls []Poll) error {
+	return errors.Wrap(writeJSONFile(d.cfg.PollsFile, polls), "error writing polls file")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPollWinner(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC)
+	slots := []TimeRange{
+		{Start: now.Add(-time.Hour), End: now},
+		{Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour)},
+		{Start: now.Add(48 * time.Hour), End: now.Add(49 * time.Hour)},
+	}
+	deadline := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		votes    map[int64][]int
+		now      time.Time
+		winner   int
+		decided  bool
+		expected []int
+	}{
+		{"no votes", nil, now, -1, false, []int{0, 0, 0}},
+		{"below quorum", map[int64][]int{1: {1}, 2: {1, 2}}, now, -1, false, []int{0, 2, 1}},
+		{"quorum", map[int64][]int{1: {1, 2}, 2: {2}, 3: {2}}, now, 2, true, []int{0, 1, 3}},
+		{"started slots can't win", map[int64][]int{1: {0}, 2: {0}, 3: {0}}, now, -1, false, []int{3, 0, 0}},
+		{"deadline", map[int64][]int{1: {1}, 2: {2}}, deadline, 1, true, []int{0, 1, 1}},
+		{"deadline without votes", map[int64][]int{}, deadline, -1, true, []int{0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			poll := Poll{Slots: slots, Votes: test.votes, Deadline: deadline}
+			winner, decided := poll.Winner(3, test.now)
+			assert.Equal(t, test.expected, poll.Tally())
+			assert.Equal(t, test.decided, decided)
+			assert.Equal(t, test.winner, winner)
+		})
+	}
+}
+
+type PollDaoSuite struct {
+	suite.Suite
+	filename string
+	dao      PollDao
+}
+
+func TestPollDaoSuite(t *testing.T) {
+	suite.Run(t, new(PollDaoSuite))
+}
+
+func (s *PollDaoSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_polls", time.Now().Unix())
+	s.dao = NewPollDao(Config{PollsFile: s.filename})
+}
+
+func (s *PollDaoSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *PollDaoSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *PollDaoSuite) TestVoteAndClose() {
+	start := time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)
+	poll := Poll{
+		Id:       "poll",
+		ChatId:   -100,
+		Title:    "Dinner",
+		Slots:    []TimeRange{{Start: start, End: start.Add(time.Hour)}},
+		Deadline: start,
+	}
+	s.Require().NoError(s.dao.SavePoll(poll))
+
+	_, ok, err := s.dao.Vote("other", 1, []int{0})
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+
+	_, _, err = s.dao.Vote("poll", 1, []int{0})
+	s.Require().NoError(err)
+	voted, ok, err := s.dao.Vote("poll", 2, []int{0})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+	s.Assert().Equal(map[int64][]int{1: {0}, 2: {0}}, voted.Votes)
+
+	// retracted
+	voted, _, err = s.dao.Vote("poll", 1, nil)
+	s.Require().NoError(err)
+	s.Assert().Equal(map[int64][]int{2: {0}}, voted.Votes)
+
+	closed, ok, err := s.dao.Close("poll")
+	s.Require().NoError(err)
+	s.Require().True(ok)
+	s.Assert().Equal(voted, closed)
+
+	_, ok, err = s.dao.Close("poll")
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+	polls, err := s.dao.GetPolls()
+	s.Require().NoError(err)
+	s.Assert().Empty(polls)
+}
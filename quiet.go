@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// quietWindow is a recurring period during which a chat shouldn't be
+// disturbed.
+type quietWindow interface {
+	// QuietUntil reports whether t falls within the window, and if so when
+	// the window ends.
+	QuietUntil(t time.Time) (time.Time, bool)
+}
+
+type clockTime struct {
+	hour   int
+	minute int
+}
+
+func (c clockTime) on(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), c.hour, c.minute, 0, 0, t.Location())
+}
+
+func (c clockTime) before(other clockTime) bool {
+	return c.hour < other.hour || c.hour == other.hour && c.minute < other.minute
+}
+
+func (c clockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c.hour, c.minute)
+}
+
+// dailyWindow is quiet between the same two times of every day. It may wrap
+// around midnight, e.g. 23:00-07:00.
+type dailyWindow struct {
+	start clockTime
+	end   clockTime
+	loc   *time.Location
+}
+
+func (w dailyWindow) QuietUntil(t time.Time) (time.Time, bool) {
+	t = t.In(w.loc)
+	start, end := w.start.on(t), w.end.on(t)
+
+	if !start.After(end) {
+		return end, !t.Before(start) && t.Before(end)
+	}
+
+	switch {
+	case t.Before(end):
+		return end, true
+	case !t.Before(start):
+		return w.end.on(t.AddDate(0, 0, 1)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// shabbatWindow is quiet from candle lighting before Shabbat or a festival
+// until havdalah after it, with times computed locally from the sunset.
+type shabbatWindow struct {
+	geo            GeoLocation
+	candleLighting time.Duration
+	havdalah       time.Duration
+	diaspora       bool
+}
+
+func (w shabbatWindow) QuietUntil(t time.Time) (time.Time, bool) {
+	t = t.In(w.geo.Location)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	// the rest period that's either ongoing or about to start tonight
+	first := today.AddDate(0, 0, 1)
+	if w.isRestDay(today) && t.Before(w.sunset(today).Add(w.havdalah)) {
+		first = today
+	} else if !w.isRestDay(first) || t.Before(w.sunset(today).Add(-w.candleLighting)) {
+		return time.Time{}, false
+	}
+
+	last := first
+	for w.isRestDay(last.AddDate(0, 0, 1)) {
+		last = last.AddDate(0, 0, 1)
+	}
+
+	return w.sunset(last).Add(w.havdalah), true
+}
+
+func (w shabbatWindow) isRestDay(date time.Time) bool {
+	return date.Weekday() == time.Saturday || IsYomTov(HebrewDateOf(date), w.diaspora)
+}
+
+// sunset falls back to 18:00 on days the sun doesn't set, which is as good a
+// guess as any near the poles.
+func (w shabbatWindow) sunset(date time.Time) time.Time {
+	if t, ok := w.geo.Sunset(date); ok {
+		return t
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 18, 0, 0, 0, w.geo.Location)
+}
+
+// quietSchedule combines several windows. Overlapping or adjacent windows
+// are treated as a single quiet period.
+type quietSchedule []quietWindow
+
+func (s quietSchedule) QuietUntil(t time.Time) (time.Time, bool) {
+	end, quiet := t, false
+	for extended := true; extended; {
+		extended = false
+		for _, w := range s {
+			if until, ok := w.QuietUntil(end); ok && until.After(end) {
+				end, quiet, extended = until, true, true
+			}
+		}
+	}
+
+	return end, quiet
+}
+
+// quietHours holds the quiet schedule of every chat. Chats without their own
+// schedule use the default one.
+type quietHours struct {
+	defaults quietSchedule
+	chats    map[int64]quietSchedule
+}
+
+func NewQuietHours(cfg Config) (quietHours, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return quietHours{}, errors.Wrap(err, "error loading timezone")
+	}
+
+	shabbat := shabbatWindow{
+		geo: GeoLocation{
+			Latitude:  cfg.ShabbatLatitude,
+			Longitude: cfg.ShabbatLongitude,
+			Location:  loc,
+		},
+		candleLighting: cfg.CandleLightingOffset,
+		havdalah:       cfg.HavdalahOffset,
+		diaspora:       cfg.HolidaysDiaspora,
+	}
+
+	q := quietHours{chats: make(map[int64]quietSchedule, len(cfg.ChatQuietHours))}
+	if q.defaults, err = parseQuietSchedule(cfg.QuietHours, loc, shabbat); err != nil {
+		return quietHours{}, errors.Wrap(err, "error parsing quiet hours")
+	}
+	for chatId, spec := range cfg.ChatQuietHours {
+		if q.chats[chatId], err = parseQuietSchedule(spec, loc, shabbat); err != nil {
+			return quietHours{}, errors.Wrapf(err, "error parsing quiet hours of chat %d", chatId)
+		}
+	}
+
+	return q, nil
+}
+
+func (q quietHours) QuietUntil(chatId int64, t time.Time) (time.Time, bool) {
+	schedule, ok := q.chats[chatId]
+	if !ok {
+		schedule = q.defaults
+	}
+
+	return schedule.QuietUntil(t)
+}
+
+// parseQuietSchedule parses a comma separated list of windows, each being
+// either "HH:MM-HH:MM" or "shabbat". "none" or an empty spec disables quiet
+// hours.
+func parseQuietSchedule(spec string, loc *time.Location, shabbat shabbatWindow) (quietSchedule, error) {
+	var schedule quietSchedule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "" || strings.EqualFold(part, "none"):
+			continue
+		case strings.EqualFold(part, "shabbat"):
+			schedule = append(schedule, shabbat)
+		default:
+			from, to, ok := strings.Cut(part, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid quiet window: %q", part)
+			}
+			start, err := parseClockTime(from)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseClockTime(to)
+			if err != nil {
+				return nil, err
+			}
+			schedule = append(schedule, dailyWindow{start: start, end: end, loc: loc})
+		}
+	}
+
+	return schedule, nil
+}
+
+func parseClockTime(s string) (clockTime, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return clockTime{}, fmt.Errorf("invalid time of day: %q", s)
+	}
+
+	return clockTime{hour: t.Hour(), minute: t.Minute()}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailyWindow(t *testing.T) {
+	night := dailyWindow{start: clockTime{23, 0}, end: clockTime{7, 0}, loc: time.UTC}
+	noon := dailyWindow{start: clockTime{12, 0}, end: clockTime{14, 30}, loc: time.UTC}
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, time.June, 3, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name          string
+		window        dailyWindow
+		t             time.Time
+		expectedQuiet bool
+		expectedUntil time.Time
+	}{
+		{"before night", night, day(22, 59), false, time.Time{}},
+		{"night start", night, day(23, 0), true, day(31, 0)},
+		{"after midnight", night, day(3, 0), true, day(7, 0)},
+		{"night end", night, day(7, 0), false, time.Time{}},
+		{"noon", noon, day(13, 0), true, day(14, 30)},
+		{"after noon", noon, day(14, 30), false, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			until, quiet := test.window.QuietUntil(test.t)
+			assert.Equal(t, test.expectedQuiet, quiet)
+			if test.expectedQuiet {
+				assert.Equal(t, test.expectedUntil, until)
+			}
+		})
+	}
+}
+
+func TestShabbatWindow(t *testing.T) {
+	jerusalemTz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	shabbat := shabbatWindow{
+		geo:            GeoLocation{Latitude: 31.778, Longitude: 35.235, Location: jerusalemTz},
+		candleLighting: 40 * time.Minute,
+		havdalah:       42 * time.Minute,
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, jerusalemTz)
+	}
+
+	tests := []struct {
+		name          string
+		t             time.Time
+		expectedQuiet bool
+		expectedUntil time.Time
+	}{
+		{"thursday", at(time.June, 13, 21, 0), false, time.Time{}},
+		{"friday before candle lighting", at(time.June, 14, 19, 0), false, time.Time{}},
+		{"friday after candle lighting", at(time.June, 14, 19, 10), true, at(time.June, 15, 20, 30)},
+		{"saturday morning", at(time.June, 15, 9, 0), true, at(time.June, 15, 20, 30)},
+		{"saturday after havdalah", at(time.June, 15, 20, 35), false, time.Time{}},
+		// Rosh Hashana 5785 started on Wednesday evening, October 2nd, and was
+		// immediately followed by Shabbat, making a single quiet period
+		{"before rosh hashana", at(time.October, 2, 17, 0), false, time.Time{}},
+		{"rosh hashana eve", at(time.October, 2, 18, 0), true, at(time.October, 5, 19, 1)},
+		{"rosh hashana turning into shabbat", at(time.October, 4, 18, 50), true, at(time.October, 5, 19, 1)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			until, quiet := shabbat.QuietUntil(test.t)
+			assert.Equal(t, test.expectedQuiet, quiet)
+			if test.expectedQuiet {
+				assert.WithinDuration(t, test.expectedUntil, until, 3*time.Minute)
+			}
+		})
+	}
+}
+
+func TestQuietScheduleChainsWindows(t *testing.T) {
+	schedule := quietSchedule{
+		dailyWindow{start: clockTime{22, 0}, end: clockTime{6, 0}, loc: time.UTC},
+		dailyWindow{start: clockTime{5, 0}, end: clockTime{8, 0}, loc: time.UTC},
+	}
+
+	until, quiet := schedule.QuietUntil(time.Date(2024, time.June, 3, 23, 0, 0, 0, time.UTC))
+	assert.True(t, quiet)
+	assert.Equal(t, time.Date(2024, time.June, 4, 8, 0, 0, 0, time.UTC), until)
+}
+
+func TestNewQuietHours(t *testing.T) {
+	cfg := Config{
+		Timezone:       "UTC",
+		QuietHours:     "23:00-07:00",
+		ChatQuietHours: map[int64]string{1: "none", 2: "13:00-16:00, shabbat"},
+	}
+	q, err := NewQuietHours(cfg)
+	require.NoError(t, err)
+
+	night := time.Date(2024, time.June, 3, 23, 30, 0, 0, time.UTC)
+	_, quiet := q.QuietUntil(3, night)
+	assert.True(t, quiet)
+	_, quiet = q.QuietUntil(1, night)
+	assert.False(t, quiet)
+	_, quiet = q.QuietUntil(2, night)
+	assert.False(t, quiet)
+	_, quiet = q.QuietUntil(2, time.Date(2024, time.June, 3, 14, 0, 0, 0, time.UTC))
+	assert.True(t, quiet)
+}
+
+func TestNewQuietHoursInvalid(t *testing.T) {
+	for _, spec := range []string{"23:00", "25:00-07:00", "sabbath"} {
+		_, err := NewQuietHours(Config{Timezone: "UTC", QuietHours: spec})
+		assert.Error(t, err, spec)
+	}
+
+	_, err := NewQuietHours(Config{Timezone: "Mars/Olympus_Mons"})
+	assert.Error(t, err)
+}
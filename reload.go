@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// configReloader watches the config file, and the process for SIGHUP, and
+// hands the daemon every new configuration that passes validation. The
+// outcome is reported to the admin chat; on failure the running
+// configuration stays in place.
+type configReloader struct {
+	lookuper envconfig.Lookuper
+	telcli   Telegram
+	signals  <-chan os.Signal
+	// reloads holds the latest valid configuration, until the daemon applies
+	// it between cycles.
+	reloads chan Config
+	// stamp tells whether the config file changed since it was last read.
+	stamp fileStamp
+
+	mu  sync.Mutex
+	cfg Config
+}
+
+func newConfigReloader(cfg Config, lookuper envconfig.Lookuper, telcli Telegram, signals <-chan os.Signal) *configReloader {
+	path, _ := configFilePath(lookuper)
+	return &configReloader{
+		cfg:      cfg,
+		lookuper: lookuper,
+		telcli:   telcli,
+		signals:  signals,
+		reloads:  make(chan Config, 1),
+		stamp:    stampFile(path),
+	}
+}
+
+// fileStamp tells whether a file changed, without reading it.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// Watch polls the config file for changes and listens for signals until the
+// context is done.
+func (r *configReloader) Watch(ctx context.Context) {
+	path, _ := configFilePath(r.lookuper)
+	ticker := time.NewTicker(r.current().ConfigWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.signals:
+			log.Info("received SIGHUP, reloading config")
+		case <-ticker.C:
+			stamp := stampFile(path)
+			if stamp == r.stamp {
+				continue
+			}
+			r.stamp = stamp
+			log.WithField("path", path).Info("config file changed, reloading config")
+		}
+
+		r.load(ctx)
+	}
+}
+
+func (r *configReloader) load(ctx context.Context) {
+	cfg, sources, err := ReadConfig(ctx, r.lookuper)
+	if err == nil {
+		err = ValidateConfig(cfg, sources)
+	}
+	if err != nil {
+		r.reportFailure(err)
+		return
+	}
+
+	// a configuration the daemon hasn't applied yet is outdated
+	select {
+	case <-r.reloads:
+	default:
+	}
+	r.reloads <- cfg
+}
+
+// Apply switches the engine to the reloadable settings of the configuration.
+// It must be called between cycles.
+func (r *configReloader) Apply(engine *Engine, loaded Config) {
+	next, changed, restart := mergeReloadable(r.current(), loaded)
+	if len(changed) == 0 && len(restart) == 0 {
+		log.Info("config unchanged")
+		return
+	}
+
+	if len(changed) > 0 {
+		if err := engine.Reconfigure(next); err != nil {
+			r.reportFailure(err)
+			return
+		}
+		r.mu.Lock()
+		r.cfg = next
+		r.mu.Unlock()
+	}
+
+	log.WithField("changed", changed).WithField("restart", restart).Info("config reloaded")
+	text := "🔄 ההגדרות נטענו מחדש."
+	if len(changed) > 0 {
+		text += "\nשונו: " + strings.Join(changed, ", ")
+	}
+	if len(restart) > 0 {
+		text += "\nיחולו רק אחרי הפעלה מחדש: " + strings.Join(restart, ", ")
+	}
+	r.report(text)
+}
+
+func (r *configReloader) current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+func (r *configReloader) reportFailure(err error) {
+	log.WithError(err).Error("error reloading config, keeping the current one")
+	r.report("⚠️ טעינת ההגדרות נכשלה, ההגדרות הקודמות נשארות בתוקף:\n" + err.Error())
+}
+
+func (r *configReloader) report(text string) {
+	chatId := r.current().AdminChatId
+	if chatId == 0 {
+		return
+	}
+	if err := r.telcli.SendText(chatId, text); err != nil {
+		log.WithError(err).Error("error reporting config reload")
+	}
+}
+
+// mergeReloadable takes the reloadable settings of the loaded configuration
+// into the current one. It returns the keys of the settings that changed, and
+// of those that changed but take a restart.
+func mergeReloadable(current, loaded Config) (Config, []string, []string) {
+	var changed, restart []string
+	next := current
+	dst, src := reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded)
+	for _, field := range configFields() {
+		if reflect.DeepEqual(dst.Field(field.index).Interface(), src.Field(field.index).Interface()) {
+			continue
+		}
+		if !field.reloadable {
+			restart = append(restart, field.key)
+			continue
+		}
+		dst.Field(field.index).Set(src.Field(field.index))
+		changed = append(changed, field.key)
+	}
+
+	return next, changed, restart
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// reloadableConfigFile writes a valid config file with the given extra
+// settings, returning its path.
+func reloadableConfigFile(t *testing.T, dir string, extra string) string {
+	credentials := filepath.Join(dir, "credentials.json")
+	require.NoError(t, os.WriteFile(credentials, []byte("{}"), 0644))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`calendar_id: family
+telegram_token: token
+google_service_account_file: `+credentials+`
+admin_chat_id: 99
+config_watch_interval: 10ms
+`+extra), 0644))
+
+	return path
+}
+
+func newTestReloader(t *testing.T, path string, telcli Telegram, signals <-chan os.Signal) *configReloader {
+	lookuper := envconfig.MapLookuper(map[string]string{"CONFIG_FILE": path})
+	cfg, _, err := ReadConfig(context.Background(), lookuper)
+	require.NoError(t, err)
+
+	return newConfigReloader(cfg, lookuper, telcli, signals)
+}
+
+func TestConfigReloaderWatchesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := reloadableConfigFile(t, dir, "telegram_chat_id: 1\n")
+	reloader := newTestReloader(t, path, &TelegramClientMock{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx)
+
+	reloadableConfigFile(t, dir, "telegram_chat_id: 2\n")
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+
+	select {
+	case cfg := <-reloader.reloads:
+		assert.Equal(t, int64(2), cfg.TelegramChatId)
+	case <-time.After(time.Second):
+		t.Fatal("config wasn't reloaded")
+	}
+}
+
+func TestConfigReloaderReportsInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := reloadableConfigFile(t, dir, "telegram_chat_id: 1\n")
+	telCliMock := &TelegramClientMock{}
+	reported := make(chan string, 1)
+	telCliMock.On("SendText", int64(99), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		reported <- args.String(1)
+	})
+	signals := make(chan os.Signal, 1)
+	reloader := newTestReloader(t, path, telCliMock, signals)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx)
+
+	require.NoError(t, os.WriteFile(path, []byte("telegram_chat: 2\n"), 0644))
+	signals <- os.Interrupt
+
+	select {
+	case text := <-reported:
+		assert.Equal(t, "⚠️ טעינת ההגדרות נכשלה, ההגדרות הקודמות נשארות בתוקף:\n"+path+`:1: unknown key "telegram_chat"`, text)
+	case <-time.After(time.Second):
+		t.Fatal("failure wasn't reported")
+	}
+	assert.Empty(t, reloader.reloads)
+}
+
+func TestConfigReloaderApply(t *testing.T) {
+	dir := t.TempDir()
+	path := reloadableConfigFile(t, dir, "telegram_chat_id: 1\n")
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(99), mock.Anything).Return(nil)
+	reloader := newTestReloader(t, path, telCliMock, nil)
+	engine := Engine{cfg: reloader.current()}
+	loaded := reloader.current()
+	loaded.TelegramChatId = 2
+	loaded.QuietHours = "22:00-07:00"
+	loaded.Timezone = "Europe/London"
+
+	// SUT
+	reloader.Apply(&engine, loaded)
+
+	assert.Equal(t, int64(2), engine.cfg.TelegramChatId)
+	assert.Equal(t, "22:00-07:00", engine.cfg.QuietHours)
+	assert.Equal(t, "Asia/Jerusalem", engine.cfg.Timezone, "the timezone takes a restart")
+	telCliMock.AssertCalled(t, "SendText", int64(99),
+		"🔄 ההגדרות נטענו מחדש.\nשונו: telegram_chat_id, quiet_hours\nיחולו רק אחרי הפעלה מחדש: timezone")
+
+	// quiet hours are only checked once applied
+	broken := reloader.current()
+	broken.QuietHours = "late"
+	reloader.Apply(&engine, broken)
+
+	assert.Equal(t, "22:00-07:00", engine.cfg.QuietHours)
+	assert.Equal(t, "22:00-07:00", reloader.current().QuietHours)
+	telCliMock.AssertNumberOfCalls(t, "SendText", 2)
+}
+
+func TestDaemonAppliesReloadsBetweenCycles(t *testing.T) {
+	dir := t.TempDir()
+	path := reloadableConfigFile(t, dir, "telegram_chat_id: 1\n")
+	telCliMock := &TelegramClientMock{}
+	telCliMock.On("SendText", int64(99), mock.Anything).Return(nil)
+	reloader := newTestReloader(t, path, telCliMock, nil)
+	cfg := reloader.current()
+	cfg.LastCheckedFile = filepath.Join(dir, "last_checked.txt")
+	cfg.SubscriptionsFile = filepath.Join(dir, "subscriptions.json")
+	cfg.OutboxFile = filepath.Join(dir, "outbox.json")
+	cfg.DaemonInterval = 10 * time.Millisecond
+	reloader.cfg = cfg
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calSvcMock := &CalendarServiceMock{}
+	telCliMock.On("ListenUpdates", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	})
+	engine := Engine{
+		cfg:         cfg,
+		calSvc:      calSvcMock,
+		telcli:      telCliMock,
+		lastChkdDao: NewLastCheckedDao(cfg),
+		subsDao:     NewSubscriptionDao(cfg),
+		outboxDao:   NewOutboxDao(cfg),
+		clock:       NewSystemClock(),
+	}
+	var chatIds []int64
+	calSvcMock.On("GetRecentEvents", mock.Anything, "family", mock.Anything).Return([]CalendarEvent{}, nil).Run(func(mock.Arguments) {
+		if chatIds = append(chatIds, engine.cfg.TelegramChatId); len(chatIds) == 2 {
+			cancel()
+		}
+	})
+	loaded := cfg
+	loaded.TelegramChatId = 2
+	reloader.reloads <- loaded
+	daemon := Daemon{cfg: cfg, engine: &engine, updates: telCliMock, dispatcher: NewDispatcher(), reloader: reloader}
+
+	// SUT
+	require.NoError(t, daemon.Run(ctx))
+
+	assert.Equal(t, []int64{1, 2}, chatIds)
+}
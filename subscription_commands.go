@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const subscriptionHelp = `אפשר לקבל כאן עדכונים אישיים על אירועים ביומן.
+
+/subscribe calendar <מספר> - רק אירועים מיומן מסוים (ראו /calendars)
+/subscribe status <created|updated|canceled> - רק סוג עדכון מסוים
+/subscribe keyword <מילה> - רק אירועים שהכותרת שלהם מכילה את המילה
+/unsubscribe <calendar|status|keyword> <ערך> - הסרת סינון
+/subscriptions - ההגדרות הנוכחיות
+/stop - הפסקת העדכונים`
+
+// subscriptionCommands lets users manage private subscriptions by chatting
+// with the bot.
+type subscriptionCommands struct {
+	// config returns the current configuration, which changes on reload.
+	config  func() Config
+	subsDao SubscriptionDao
+	telcli  Telegram
+	people  peopleDirectory
+}
+
+func (c *subscriptionCommands) Register(d *Dispatcher) {
+	d.HandleCommand("start", c.privateOnly(c.start))
+	d.HandleCommand("stop", c.privateOnly(c.stop))
+	d.HandleCommand("calendars", c.privateOnly(c.calendars))
+	d.HandleCommand("subscribe", c.privateOnly(c.subscribe))
+	d.HandleCommand("unsubscribe", c.privateOnly(c.unsubscribe))
+	d.HandleCommand("subscriptions", c.privateOnly(c.show))
+}
+
+// privateOnly serves the allowed users in private chats only, since the
+// updates reveal the family's events.
+func (c *subscriptionCommands) privateOnly(handler CommandHandler) CommandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) error {
+		if !msg.Chat.IsPrivate() {
+			return c.telcli.SendText(msg.Chat.ID, "כדי לנהל עדכונים אישיים יש לשלוח לי הודעה פרטית")
+		}
+		if !allowedUser(c.config(), c.people, msg.From) {
+			log.WithField("chatId", msg.Chat.ID).WithField("command", msg.Command()).Warn("rejecting command from unknown user")
+			return c.telcli.SendText(msg.Chat.ID, fmt.Sprintf("אין לך הרשאה לקבל עדכונים. מספר המשתמש שלך: %d", msg.Chat.ID))
+		}
+
+		return handler(ctx, msg)
+	}
+}
+
+func (c *subscriptionCommands) start(_ context.Context, msg *tgbotapi.Message) error {
+	if _, err := c.getOrCreate(msg); err != nil {
+		return err
+	}
+
+	return c.telcli.SendText(msg.Chat.ID, "ההרשמה לעדכונים הושלמה! כרגע יישלחו לכאן כל העדכונים.\n\n"+subscriptionHelp)
+}
+
+func (c *subscriptionCommands) stop(_ context.Context, msg *tgbotapi.Message) error {
+	if err := c.subsDao.DeleteSubscription(msg.Chat.ID); err != nil {
+		return err
+	}
+
+	return c.telcli.SendText(msg.Chat.ID, "העדכונים האישיים הופסקו. אפשר לחזור בכל עת עם /start")
+}
+
+func (c *subscriptionCommands) calendars(_ context.Context, msg *tgbotapi.Message) error {
+	lines := make([]string, 0, len(c.config().Calendars()))
+	for i, calendarId := range c.config().Calendars() {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, calendarId))
+	}
+
+	return c.telcli.SendText(msg.Chat.ID, "היומנים הזמינים:\n"+strings.Join(lines, "\n"))
+}
+
+func (c *subscriptionCommands) subscribe(_ context.Context, msg *tgbotapi.Message) error {
+	return c.updateFilter(msg, func(sub *Subscription, kind, value string) error {
+		switch kind {
+		case "calendar":
+			calendarId, err := c.parseCalendar(value)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(sub.Calendars, calendarId) {
+				sub.Calendars = append(sub.Calendars, calendarId)
+			}
+		case "status":
+			status, err := ParseEventStatus(value)
+			if err != nil || status == StatusUnknown {
+				return fmt.Errorf("סוג עדכון לא מוכר: %s", value)
+			}
+			if !slices.Contains(sub.Statuses, status) {
+				sub.Statuses = append(sub.Statuses, status)
+			}
+		case "keyword":
+			if !slices.Contains(sub.Keywords, value) {
+				sub.Keywords = append(sub.Keywords, value)
+			}
+		default:
+			return fmt.Errorf("סינון לא מוכר: %s", kind)
+		}
+		return nil
+	})
+}
+
+func (c *subscriptionCommands) unsubscribe(_ context.Context, msg *tgbotapi.Message) error {
+	return c.updateFilter(msg, func(sub *Subscription, kind, value string) error {
+		switch kind {
+		case "calendar":
+			calendarId, err := c.parseCalendar(value)
+			if err != nil {
+				return err
+			}
+			sub.Calendars = slices.DeleteFunc(sub.Calendars, func(id string) bool { return id == calendarId })
+		case "status":
+			status, err := ParseEventStatus(value)
+			if err != nil {
+				return fmt.Errorf("סוג עדכון לא מוכר: %s", value)
+			}
+			sub.Statuses = slices.DeleteFunc(sub.Statuses, func(s EventStatus) bool { return s == status })
+		case "keyword":
+			sub.Keywords = slices.DeleteFunc(sub.Keywords, func(k string) bool { return strings.EqualFold(k, value) })
+		default:
+			return fmt.Errorf("סינון לא מוכר: %s", kind)
+		}
+		return nil
+	})
+}
+
+func (c *subscriptionCommands) show(_ context.Context, msg *tgbotapi.Message) error {
+	sub, isExist, err := c.subsDao.GetSubscription(msg.Chat.ID)
+	if err != nil {
+		return err
+	}
+	if !isExist {
+		return c.telcli.SendText(msg.Chat.ID, "אינך רשום/ה לעדכונים. אפשר להירשם עם /start")
+	}
+
+	return c.telcli.SendText(msg.Chat.ID, describeSubscription(sub))
+}
+
+// updateFilter parses "<kind> <value>" from the command arguments, applies the
+// change to the user's subscription and replies with the result.
+func (c *subscriptionCommands) updateFilter(msg *tgbotapi.Message, apply func(sub *Subscription, kind, value string) error) error {
+	kind, value, _ := strings.Cut(strings.TrimSpace(msg.CommandArguments()), " ")
+	value = strings.TrimSpace(value)
+	if kind == "" || value == "" {
+		return c.telcli.SendText(msg.Chat.ID, subscriptionHelp)
+	}
+
+	sub, err := c.getOrCreate(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := apply(&sub, strings.ToLower(kind), value); err != nil {
+		return c.telcli.SendText(msg.Chat.ID, err.Error())
+	}
+
+	if err := c.subsDao.SaveSubscription(sub); err != nil {
+		return err
+	}
+
+	return c.telcli.SendText(msg.Chat.ID, describeSubscription(sub))
+}
+
+func (c *subscriptionCommands) getOrCreate(msg *tgbotapi.Message) (Subscription, error) {
+	sub, isExist, err := c.subsDao.GetSubscription(msg.Chat.ID)
+	if err != nil {
+		return Subscription{}, errors.Wrap(err, "error reading subscription")
+	}
+	if isExist {
+		return sub, nil
+	}
+
+	sub = Subscription{ChatId: msg.Chat.ID}
+	if msg.From != nil {
+		sub.Name = strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName)
+	}
+
+	return sub, errors.Wrap(c.subsDao.SaveSubscription(sub), "error saving subscription")
+}
+
+// parseCalendar accepts either the calendar's number in /calendars or its id.
+func (c *subscriptionCommands) parseCalendar(value string) (string, error) {
+	calendars := c.config().Calendars()
+	if i, err := strconv.Atoi(value); err == nil && i >= 1 && i <= len(calendars) {
+		return calendars[i-1], nil
+	}
+	if slices.Contains(calendars, value) {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("יומן לא מוכר: %s", value)
+}
+
+func describeSubscription(sub Subscription) string {
+	all := func(values []string) string {
+		if len(values) == 0 {
+			return "הכל"
+		}
+		return strings.Join(values, ", ")
+	}
+
+	statuses := make([]string, 0, len(sub.Statuses))
+	for _, status := range sub.Statuses {
+		statuses = append(statuses, status.String())
+	}
+
+	return fmt.Sprintf("ההגדרות שלך:\nיומנים: %s\nסוגי עדכונים: %s\nמילות מפתח: %s",
+		all(sub.Calendars), all(statuses), all(sub.Keywords))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type SubscriptionCommandsSuite struct {
+	suite.Suite
+	filename   string
+	telCliMock *TelegramClientMock
+	subsDao    SubscriptionDao
+	dispatcher *Dispatcher
+}
+
+func TestSubscriptionCommandsSuite(t *testing.T) {
+	suite.Run(t, new(SubscriptionCommandsSuite))
+}
+
+func (s *SubscriptionCommandsSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_subscription_commands", time.Now().Unix())
+}
+
+func (s *SubscriptionCommandsSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+	cfg := Config{
+		CalendarId:        "family",
+		ExtraCalendarIds:  []string{"kids"},
+		SubscriptionsFile: s.filename,
+		AllowedUsers:      []int64{7},
+	}
+	s.telCliMock = &TelegramClientMock{}
+	s.telCliMock.On("SendText", mock.Anything, mock.Anything).Return(nil)
+	s.subsDao = NewSubscriptionDao(cfg)
+	s.dispatcher = NewDispatcher()
+	people := newPeopleDirectory([]Person{{Email: "grandma@example.com", TelegramId: 8}})
+	cmds := subscriptionCommands{config: func() Config { return cfg }, subsDao: s.subsDao, telcli: s.telCliMock, people: people}
+	cmds.Register(s.dispatcher)
+}
+
+func (s *SubscriptionCommandsSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *SubscriptionCommandsSuite) TestStartAndFilter() {
+	s.send(7, "private", "/start")
+	s.send(7, "private", "/subscribe calendar 2")
+	s.send(7, "private", "/subscribe status canceled")
+	s.send(7, "private", "/subscribe keyword חוג ציור")
+	s.send(7, "private", "/subscribe keyword שחייה")
+	s.send(7, "private", "/unsubscribe keyword שחייה")
+
+	sub, isExist, err := s.subsDao.GetSubscription(7)
+	s.Require().NoError(err)
+	s.Require().True(isExist)
+	s.Assert().Equal(Subscription{
+		ChatId:    7,
+		Name:      "Dana",
+		Calendars: []string{"kids"},
+		Statuses:  []EventStatus{StatusCanceled},
+		Keywords:  []string{"חוג ציור"},
+	}, sub)
+}
+
+func (s *SubscriptionCommandsSuite) TestInvalidFilter() {
+	s.send(7, "private", "/subscribe calendar 3")
+	s.send(7, "private", "/subscribe status moved")
+
+	sub, isExist, err := s.subsDao.GetSubscription(7)
+	s.Require().NoError(err)
+	s.Require().True(isExist)
+	s.Assert().Empty(sub.Calendars)
+	s.Assert().Empty(sub.Statuses)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(7), "יומן לא מוכר: 3")
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(7), "סוג עדכון לא מוכר: moved")
+}
+
+func (s *SubscriptionCommandsSuite) TestStop() {
+	s.send(7, "private", "/start")
+	s.send(7, "private", "/stop")
+
+	_, isExist, err := s.subsDao.GetSubscription(7)
+	s.Require().NoError(err)
+	s.Assert().False(isExist)
+}
+
+func (s *SubscriptionCommandsSuite) TestPersonInDirectory() {
+	s.send(8, "private", "/start")
+
+	_, isExist, err := s.subsDao.GetSubscription(8)
+	s.Require().NoError(err)
+	s.Assert().True(isExist)
+}
+
+func (s *SubscriptionCommandsSuite) TestUnknownUser() {
+	s.send(9, "private", "/start")
+	s.send(9, "private", "/subscribe keyword חוג ציור")
+
+	subs, err := s.subsDao.GetSubscriptions()
+	s.Require().NoError(err)
+	s.Assert().Empty(subs)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(9), "אין לך הרשאה לקבל עדכונים. מספר המשתמש שלך: 9")
+}
+
+func (s *SubscriptionCommandsSuite) TestGroupChat() {
+	s.send(-100, "group", "/start")
+
+	subs, err := s.subsDao.GetSubscriptions()
+	s.Require().NoError(err)
+	s.Assert().Empty(subs)
+	s.telCliMock.AssertCalled(s.T(), "SendText", int64(-100), mock.Anything)
+}
+
+func (s *SubscriptionCommandsSuite) send(chatId int64, chatType string, text string) {
+	s.dispatcher.HandleUpdate(context.Background(), tgbotapi.Update{Message: commandMessage(chatId, chatType, text)})
+}
+
+func commandMessage(chatId int64, chatType string, text string) *tgbotapi.Message {
+	command, _, _ := strings.Cut(text, " ")
+	return &tgbotapi.Message{
+		Text:     text,
+		Chat:     &tgbotapi.Chat{ID: chatId, Type: chatType},
+		From:     &tgbotapi.User{ID: chatId, FirstName: "Dana"},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
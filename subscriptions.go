@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Subscription holds the preferences of a user who asked the bot to notify
+// them privately. An empty filter list matches everything.
+type Subscription struct {
+	ChatId    int64         `json:"chatId"`
+	Name      string        `json:"name"`
+	Calendars []string      `json:"calendars,omitempty"`
+	Statuses  []EventStatus `json:"statuses,omitempty"`
+	Keywords  []string      `json:"keywords,omitempty"`
+}
+
+func (s Subscription) Matches(event CalendarEvent) bool {
+	if len(s.Calendars) > 0 && !slices.Contains(s.Calendars, event.CalendarId) {
+		return false
+	}
+
+	if len(s.Statuses) > 0 && !slices.Contains(s.Statuses, event.Status) {
+		return false
+	}
+
+	if len(s.Keywords) > 0 {
+		title := strings.ToLower(event.Title)
+		return slices.ContainsFunc(s.Keywords, func(keyword string) bool {
+			return strings.Contains(title, strings.ToLower(keyword))
+		})
+	}
+
+	return true
+}
+
+type SubscriptionDao interface {
+	GetSubscriptions() ([]Subscription, error)
+	GetSubscription(chatId int64) (Subscription, bool, error)
+	SaveSubscription(sub Subscription) error
+	DeleteSubscription(chatId int64) error
+}
+
+func NewSubscriptionDao(cfg Config) SubscriptionDao {
+	return &subscriptionDao{
+		cfg: cfg,
+	}
+}
+
+type subscriptionDao struct {
+	cfg Config
+	mu  sync.Mutex
+}
+
+func (d *subscriptionDao) GetSubscriptions() ([]Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.read()
+}
+
+func (d *subscriptionDao) GetSubscription(chatId int64) (Subscription, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs, err := d.read()
+	if err != nil {
+		return Subscription{}, false, err
+	}
+
+	i := slices.IndexFunc(subs, func(s Subscription) bool { return s.ChatId == chatId })
+	if i < 0 {
+		return Subscription{}, false, nil
+	}
+
+	return subs[i], true, nil
+}
+
+func (d *subscriptionDao) SaveSubscription(sub Subscription) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	if i := slices.IndexFunc(subs, func(s Subscription) bool { return s.ChatId == sub.ChatId }); i >= 0 {
+		subs[i] = sub
+	} else {
+		subs = append(subs, sub)
+	}
+
+	return d.write(subs)
+}
+
+func (d *subscriptionDao) DeleteSubscription(chatId int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs, err := d.read()
+	if err != nil {
+		return err
+	}
+
+	return d.write(slices.DeleteFunc(subs, func(s Subscription) bool { return s.ChatId == chatId }))
+}
+
+func (d *subscriptionDao) read() ([]Subscription, error) {
+	var subs []Subscription
+	if _, err := readJSONFile(d.cfg.SubscriptionsFile, &subs); err != nil {
+		return nil, errors.Wrap(err, "error reading subscriptions file")
+	}
+
+	return subs, nil
+}
+
+func (d *subscriptionDao) write(subs []Subscription) error {
+	return errors.Wrap(writeJSONFile(d.cfg.SubscriptionsFile, subs), "error writing subscriptions file")
+}
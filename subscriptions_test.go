@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	event := CalendarEvent{
+		CalendarId: "family",
+		Title:      "Football Practice",
+		Status:     StatusUpdated,
+	}
+
+	tests := []struct {
+		name     string
+		sub      Subscription
+		expected bool
+	}{
+		{"no filters", Subscription{}, true},
+		{"matching calendar", Subscription{Calendars: []string{"kids", "family"}}, true},
+		{"other calendar", Subscription{Calendars: []string{"kids"}}, false},
+		{"matching status", Subscription{Statuses: []EventStatus{StatusCreated, StatusUpdated}}, true},
+		{"other status", Subscription{Statuses: []EventStatus{StatusCanceled}}, false},
+		{"matching keyword", Subscription{Keywords: []string{"dentist", "football"}}, true},
+		{"other keyword", Subscription{Keywords: []string{"dentist"}}, false},
+		{"all filters must match", Subscription{Calendars: []string{"family"}, Keywords: []string{"dentist"}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.sub.Matches(event))
+		})
+	}
+}
+
+type SubscriptionDaoSuite struct {
+	suite.Suite
+	filename string
+	dao      SubscriptionDao
+}
+
+func TestSubscriptionDaoSuite(t *testing.T) {
+	suite.Run(t, new(SubscriptionDaoSuite))
+}
+
+func (s *SubscriptionDaoSuite) SetupSuite() {
+	s.filename = fmt.Sprintf("%s_%d.json", "test_subscriptions", time.Now().Unix())
+	s.dao = NewSubscriptionDao(Config{SubscriptionsFile: s.filename})
+}
+
+func (s *SubscriptionDaoSuite) SetupTest() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *SubscriptionDaoSuite) TearDownSuite() {
+	_ = os.Remove(s.filename)
+}
+
+func (s *SubscriptionDaoSuite) TestGetBeforeSave() {
+	subs, err := s.dao.GetSubscriptions()
+	s.Require().NoError(err)
+	s.Assert().Empty(subs)
+
+	_, isExist, err := s.dao.GetSubscription(1)
+	s.Require().NoError(err)
+	s.Assert().False(isExist)
+}
+
+func (s *SubscriptionDaoSuite) TestSaveGetDelete() {
+	first := Subscription{ChatId: 1, Name: "Dana", Statuses: []EventStatus{StatusCanceled}}
+	second := Subscription{ChatId: 2, Keywords: []string{"חוג"}}
+	s.Require().NoError(s.dao.SaveSubscription(first))
+	s.Require().NoError(s.dao.SaveSubscription(second))
+
+	first.Calendars = []string{"family"}
+	s.Require().NoError(s.dao.SaveSubscription(first))
+
+	actual, isExist, err := s.dao.GetSubscription(1)
+	s.Require().NoError(err)
+	s.Assert().True(isExist)
+	s.Assert().Equal(first, actual)
+
+	s.Require().NoError(s.dao.DeleteSubscription(1))
+	subs, err := s.dao.GetSubscriptions()
+	s.Require().NoError(err)
+	s.Assert().Equal([]Subscription{second}, subs)
+}
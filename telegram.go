@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
 var weekdayDict = map[time.Weekday]string{
@@ -19,22 +25,67 @@ var weekdayDict = map[time.Weekday]string{
 
 type Telegram interface {
 	Init() error
-	NotifyEvent(event CalendarEvent) error
+	// NotifyEvent, NotifyDigest and NotifyCatchUp return the id of the
+	// message they sent.
+	NotifyEvent(chatId int64, event CalendarEvent) (int, error)
+	NotifyDigest(chatId int64, events []CalendarEvent) (int, error)
+	NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error)
+	SendText(chatId int64, text string) error
+	SendChoices(chatId int64, text string, choices []Choice) error
+	AnswerCallback(callbackId string, text string) error
+	// RemoveChoices removes the buttons from a message sent by SendChoices.
+	RemoveChoices(chatId int64, messageId int) error
+	// SendPoll sends a non-anonymous poll allowing several answers, and
+	// returns the ids of the poll and of the message holding it.
+	SendPoll(chatId int64, question string, options []string) (string, int, error)
+	StopPoll(chatId int64, messageId int) error
+	ListenUpdates(ctx context.Context, handler UpdateHandler) error
+	// SetWebhook tells Telegram to post updates to the URL, along with the
+	// secret in the X-Telegram-Bot-Api-Secret-Token header.
+	SetWebhook(url string, secret string) error
+	DeleteWebhook() error
 }
 
-func NewTelegram(cfg Config) Telegram {
+// Choice is an inline button under a message. Pressing it sends Data back to
+// the bot as a callback query.
+type Choice struct {
+	Text string
+	Data string
+}
+
+// UpdateHandler processes a single update received from Telegram.
+type UpdateHandler func(ctx context.Context, update tgbotapi.Update)
+
+func NewTelegram(cfg Config, people peopleDirectory, places addressBook, clock Clock) Telegram {
 	return &telegram{
-		cfg: cfg,
+		cfg:    cfg,
+		people: people,
+		places: places,
+		clock:  clock,
 	}
 }
 
 type telegram struct {
-	cfg Config
-	bot *tgbotapi.BotAPI
+	cfg      Config
+	bot      *tgbotapi.BotAPI
+	people   peopleDirectory
+	places   addressBook
+	clock    Clock
+	renderer renderer
+	// loc is the timezone of the attached .ics files.
+	loc *time.Location
 }
 
 func (t *telegram) Init() error {
-	bot, err := tgbotapi.NewBotAPI(t.cfg.TelegramToken)
+	if err := t.initRendering(); err != nil {
+		return err
+	}
+
+	endpoint := t.cfg.TelegramAPIEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(t.cfg.TelegramToken, endpoint)
 	if err != nil {
 		return err
 	}
@@ -43,49 +94,459 @@ func (t *telegram) Init() error {
 	return nil
 }
 
-func (t *telegram) NotifyEvent(event CalendarEvent) error {
-	msgBody, err := prepareMessageBody(event)
+// initRendering prepares what rendering messages takes, without connecting
+// to Telegram.
+func (t *telegram) initRendering() error {
+	m, err := newMarkup(t.cfg.TelegramParseMode)
+	if err != nil {
+		return err
+	}
+	t.renderer = newRenderer(t.cfg, m, t.people, t.clock)
+
+	loc, err := time.LoadLocation(t.cfg.Timezone)
+	if err != nil {
+		return errors.Wrap(err, "error loading timezone")
+	}
+	t.loc = loc
+
+	return nil
+}
+
+func (t *telegram) NotifyEvent(chatId int64, event CalendarEvent) (int, error) {
+	msgBody, err := t.renderer.prepareMessageBody(event)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := tgbotapi.NewMessage(chatId, msgBody)
+	msg.ParseMode = t.renderer.markup.ParseMode()
+	sent, err := t.bot.Send(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	// the attachments follow the message, so a failure to send them must not
+	// get the message sent again
+	for _, attachment := range t.attachments(chatId, event) {
+		if _, err := t.bot.Send(attachment); err != nil {
+			log.WithError(err).WithField("chatId", chatId).Error("error sending event attachment")
+		}
+	}
+
+	return sent.MessageID, nil
+}
+
+// attachments returns the messages following the notification of the event:
+// its .ics file, and a pin of its location.
+func (t *telegram) attachments(chatId int64, event CalendarEvent) []tgbotapi.Chattable {
+	var attachments []tgbotapi.Chattable
+	if t.cfg.CalendarAttachments {
+		attachments = append(attachments, tgbotapi.NewDocument(chatId, tgbotapi.FileBytes{
+			Name:  "event.ics",
+			Bytes: MarshalICS(event, t.loc, t.clock.Now()),
+		}))
+	}
+
+	if event.Status != StatusCanceled {
+		if place, ok := t.places.Resolve(event.Location); ok {
+			attachments = append(attachments, newVenue(chatId, event, place))
+		}
+	}
+
+	return attachments
+}
+
+// newVenue pins the place of the event, titled by the place's name and falling
+// back to the event's title and location.
+func newVenue(chatId int64, event CalendarEvent, place Place) tgbotapi.VenueConfig {
+	title := place.Name
+	if isBlank(title) {
+		title = eventTitle(event)
+	}
+	address := place.Address
+	if isBlank(address) {
+		address = strings.TrimSpace(event.Location)
+	}
+
+	return tgbotapi.NewVenue(chatId, sanitizeText(title), sanitizeText(address), place.Latitude, place.Longitude)
+}
+
+// NotifyDigest sends a single message summarizing several events.
+func (t *telegram) NotifyDigest(chatId int64, events []CalendarEvent) (int, error) {
+	msgBody, err := t.renderer.prepareDigestBody(events)
 	if err != nil {
+		return 0, err
+	}
+
+	return t.sendMarkup(chatId, msgBody)
+}
+
+// NotifyCatchUp sends a single message summarizing the events that changed
+// since the given time, instead of flooding the chat after a long outage.
+func (t *telegram) NotifyCatchUp(chatId int64, since time.Time, events []CalendarEvent) (int, error) {
+	msgBody, err := t.renderer.prepareCatchUpBody(since, events)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.sendMarkup(chatId, msgBody)
+}
+
+// sendMarkup sends a message rendered in the configured parse mode, and
+// returns its id.
+func (t *telegram) sendMarkup(chatId int64, msgBody string) (int, error) {
+	msg := tgbotapi.NewMessage(chatId, msgBody)
+	msg.ParseMode = t.renderer.markup.ParseMode()
+	sent, err := t.bot.Send(msg)
+	if err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// SendText sends a plain text message, without any formatting.
+func (t *telegram) SendText(chatId int64, text string) error {
+	_, err := t.bot.Send(tgbotapi.NewMessage(chatId, text))
+	return err
+}
+
+// SendChoices sends a plain text message with a button for each of the
+// choices, one under the other.
+func (t *telegram) SendChoices(chatId int64, text string, choices []Choice) error {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(choices))
+	for _, choice := range choices {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(choice.Text, choice.Data)))
+	}
+
+	msg := tgbotapi.NewMessage(chatId, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err := t.bot.Send(msg)
+	return err
+}
+
+func (t *telegram) RemoveChoices(chatId int64, messageId int) error {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatId, messageId, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	_, err := t.bot.Request(edit)
+	return err
+}
+
+// AnswerCallback acknowledges a pressed button, briefly showing the text to
+// the user who pressed it.
+func (t *telegram) AnswerCallback(callbackId string, text string) error {
+	_, err := t.bot.Request(tgbotapi.NewCallback(callbackId, text))
+	return err
+}
+
+func (t *telegram) SendPoll(chatId int64, question string, options []string) (string, int, error) {
+	poll := tgbotapi.NewPoll(chatId, question, options...)
+	poll.IsAnonymous = false
+	poll.AllowsMultipleAnswers = true
+	msg, err := t.bot.Send(poll)
+	if err != nil {
+		return "", 0, err
+	}
+	if msg.Poll == nil {
+		return "", 0, errors.New("poll missing from the sent message")
+	}
+
+	return msg.Poll.ID, msg.MessageID, nil
+}
+
+func (t *telegram) StopPoll(chatId int64, messageId int) error {
+	_, err := t.bot.Request(tgbotapi.NewStopPoll(chatId, messageId))
+	return err
+}
+
+// SetWebhook registers the webhook directly, as tgbotapi doesn't support the
+// secret token.
+func (t *telegram) SetWebhook(url string, secret string) error {
+	params := tgbotapi.Params{"url": url}
+	params.AddNonEmpty("secret_token", secret)
+	if err := params.AddInterface("allowed_updates", webhookAllowedUpdates); err != nil {
 		return err
 	}
 
-	msg := tgbotapi.NewMessage(t.cfg.TelegramChatId, msgBody)
-	msg.ParseMode = "markdown"
-	_, err = t.bot.Send(msg)
+	_, err := t.bot.MakeRequest("setWebhook", params)
 	return err
 }
 
-func prepareMessageBody(event CalendarEvent) (string, error) {
+func (t *telegram) DeleteWebhook() error {
+	_, err := t.bot.Request(tgbotapi.DeleteWebhookConfig{})
+	return err
+}
+
+// ListenUpdates long polls Telegram for updates and hands them to the handler
+// one at a time until the context is done.
+func (t *telegram) ListenUpdates(ctx context.Context, handler UpdateHandler) error {
+	updateCfg := tgbotapi.NewUpdate(0)
+	updateCfg.Timeout = 60
+	updates := t.bot.GetUpdatesChan(updateCfg)
+	defer t.bot.StopReceivingUpdates()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			handler(ctx, update)
+		}
+	}
+}
+
+// renderer turns calendar events into Telegram messages.
+type renderer struct {
+	markup       markup
+	people       peopleDirectory
+	hebrewDate   bool
+	relative     bool
+	showHolidays bool
+	diaspora     bool
+	// links adds links for adding the event to Google Calendar and Outlook.
+	links bool
+	// clock tells the current time, against which relative dates are
+	// phrased.
+	clock Clock
+}
+
+func newRenderer(cfg Config, m markup, people peopleDirectory, clock Clock) renderer {
+	return renderer{
+		markup:       m,
+		people:       people,
+		hebrewDate:   cfg.ShowHebrewDate,
+		relative:     cfg.RelativeDates,
+		showHolidays: cfg.ShowHolidays,
+		diaspora:     cfg.HolidaysDiaspora,
+		links:        cfg.CalendarLinks,
+		clock:        clock,
+	}
+}
+
+func (r renderer) prepareMessageBody(event CalendarEvent) (string, error) {
+	m := r.markup
+	heading, err := r.heading(event)
+	if err != nil {
+		return "", err
+	}
+
+	body := heading + "\n\n" + r.when(event)
+
+	if !isBlank(event.Location) {
+		body += fmt.Sprintf("\n%s %s", m.Bold("מיקום:"), m.Escape(strings.TrimSpace(event.Location)))
+	}
+
+	if holidays := r.holidays(event); holidays != "" {
+		body += "\n" + holidays
+	}
+
+	if conflicts := r.conflicts(event); conflicts != "" {
+		body += "\n" + conflicts
+	}
+
+	if event.Creator != "" {
+		body += fmt.Sprintf("\n%s %s", m.Bold("נוצר על ידי:"), r.people.Mention(event.Creator, m))
+	}
+
+	if len(event.Attendees) > 0 {
+		mentions := make([]string, 0, len(event.Attendees))
+		for _, attendee := range event.Attendees {
+			mentions = append(mentions, r.people.Mention(attendee, m))
+		}
+		body += fmt.Sprintf("\n%s %s", m.Bold("משתתפים:"), strings.Join(mentions, m.Escape(", ")))
+	}
+
+	if r.links && event.Status != StatusCanceled {
+		body += fmt.Sprintf("\n%s %s %s %s",
+			m.Bold("הוספה ליומן:"),
+			m.Link("Google", GoogleCalendarLink(event)),
+			m.Escape("|"),
+			m.Link("Outlook", OutlookCalendarLink(event)))
+	}
+
+	return body, nil
+}
+
+// telegramMessageLimit is the maximal length of a Telegram message.
+const telegramMessageLimit = 4096
+
+// prepareDigestBody summarizes the events held back during quiet hours in a
+// single message.
+func (r renderer) prepareDigestBody(events []CalendarEvent) (string, error) {
+	header := r.markup.Bold(fmt.Sprintf("📬 %d עדכונים שהצטברו", len(events)))
+	return r.prepareEventList(header, events)
+}
+
+// prepareCatchUpBody summarizes the events that changed while the bot was
+// away in a single message.
+func (r renderer) prepareCatchUpBody(since time.Time, events []CalendarEvent) (string, error) {
+	m := r.markup
+	header := m.Bold(fmt.Sprintf("💤 בזמן שלא הייתי כאן השתנו %d אירועים", len(events))) +
+		"\n" + m.Escape(fmt.Sprintf("(מאז %s)", r.formatDateTime(since)))
+	return r.prepareEventList(header, events)
+}
+
+// prepareEventList lists the events under the header, dropping the last ones
+// if the message gets too long.
+func (r renderer) prepareEventList(header string, events []CalendarEvent) (string, error) {
+	m := r.markup
+	body := header
+
+	for i, event := range events {
+		heading, err := r.heading(event)
+		if err != nil {
+			return "", err
+		}
+
+		item := fmt.Sprintf("\n\n%s\n%s", heading, m.Escape(r.formatDateTime(event.Start)))
+		if r.relative {
+			item = fmt.Sprintf("\n\n%s\n%s", heading, m.Escape(FormatWhen(event.Start, event.End, event.AllDay, r.clock.Now())))
+		}
+		if holidays := r.holidays(event); holidays != "" {
+			item += "\n" + holidays
+		}
+		if conflicts := r.conflicts(event); conflicts != "" {
+			item += "\n" + conflicts
+		}
+		more := m.Escape(fmt.Sprintf("\n\nועוד %d...", len(events)-i))
+		if utf8.RuneCountInString(body+item+more) > telegramMessageLimit {
+			return body + more, nil
+		}
+		body += item
+	}
+
+	return body, nil
+}
+
+func (r renderer) heading(event CalendarEvent) (string, error) {
+	m := r.markup
+	title := eventTitle(event)
+
 	switch event.Status {
 	case StatusCreated:
-		return fmt.Sprintf(
-			"🗓️ *%s*\n\n*התחלה:* %s\n*סיום:* %s",
-			event.Title,
-			FormatDateTime(event.Start),
-			FormatDateTime(event.End)), nil
+		return "🗓️ " + m.Bold(title), nil
 	case StatusUpdated:
-		return fmt.Sprintf(
-			"️✍🏻 *עדכון: %s*\n\n*התחלה:* %s\n*סיום:* %s",
-			event.Title,
-			FormatDateTime(event.Start),
-			FormatDateTime(event.End)), nil
+		return "️✍🏻 " + m.Bold("עדכון: "+title), nil
 	case StatusCanceled:
-		return fmt.Sprintf(
-			"️🆇 *בוטל: %s*\n\n*התחלה:* %s\n*סיום:* %s",
-			event.Title,
-			FormatDateTime(event.Start),
-			FormatDateTime(event.End)), nil
+		return "️🆇 " + m.Bold("בוטל: "+title), nil
 	default:
 		return "", fmt.Errorf("unexpected status: %d", event.Status)
 	}
 }
 
+// when renders the lines telling when the event takes place.
+func (r renderer) when(event CalendarEvent) string {
+	m := r.markup
+	if !r.relative {
+		return fmt.Sprintf("%s %s\n%s %s",
+			m.Bold("התחלה:"),
+			m.Escape(r.formatDateTime(event.Start)),
+			m.Bold("סיום:"),
+			m.Escape(r.formatDateTime(event.End)))
+	}
+
+	when := fmt.Sprintf("%s %s", m.Bold("מתי:"), m.Escape(FormatWhen(event.Start, event.End, event.AllDay, r.clock.Now())))
+	if r.hebrewDate {
+		hebrewDate := HebrewDateOf(event.Start).String()
+		if calendarDaysBetween(event.Start, event.End) > 0 {
+			hebrewDate += "–" + HebrewDateOf(event.End).String()
+		}
+		when += fmt.Sprintf("\n%s %s", m.Bold("תאריך עברי:"), m.Escape(hebrewDate))
+	}
+
+	return when
+}
+
+func (r renderer) formatDateTime(t time.Time) string {
+	if r.hebrewDate {
+		return FormatDateTimeWithHebrewDate(t)
+	}
+	return FormatDateTime(t)
+}
+
+// holidays flags the Jewish and Israeli holidays on the days of the event.
+func (r renderer) holidays(event CalendarEvent) string {
+	if !r.showHolidays {
+		return ""
+	}
+
+	var names []string
+	for day := dateOf(event.Start); !day.After(event.End); day = day.AddDate(0, 0, 1) {
+		for _, h := range HolidaysOn(HebrewDateOf(day), r.diaspora) {
+			if !slices.Contains(names, h.Name) {
+				names = append(names, h.Name)
+			}
+		}
+		// multi-week events would flag every holiday on the way
+		if len(names) > 3 {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	return "✡️ " + r.markup.Escape(strings.Join(names, ", "))
+}
+
+// conflicts warns about the events overlapping the event, with the times they
+// overlap at.
+func (r renderer) conflicts(event CalendarEvent) string {
+	if len(event.Conflicts) == 0 {
+		return ""
+	}
+
+	items := make([]string, 0, len(event.Conflicts))
+	for _, c := range event.Conflicts {
+		items = append(items, fmt.Sprintf("%s (%s)", eventTitle(c), timeRange(c.Start, c.End.In(c.Start.Location()))))
+	}
+
+	m := r.markup
+	return fmt.Sprintf("%s %s", m.Bold("⚠️ מתנגש עם:"), m.Escape(strings.Join(items, ", ")))
+}
+
+// timeRange writes the hours between start and end, adding the dates when the
+// range spans several days.
+func timeRange(start, end time.Time) string {
+	if calendarDaysBetween(start, end) == 0 {
+		return start.Format("15:04") + "–" + end.Format("15:04")
+	}
+	return start.Format("2.1 15:04") + "–" + end.Format("2.1 15:04")
+}
+
+// eventTitle returns the title to display, falling back to a placeholder for
+// untitled events so the message never contains an empty entity.
+func eventTitle(event CalendarEvent) string {
+	if isBlank(event.Title) {
+		return "(ללא כותרת)"
+	}
+
+	return event.Title
+}
+
 func FormatDateTime(t time.Time) string {
+	return formatDateTime(t, getDayOfWeek(t))
+}
+
+// FormatDateTimeWithHebrewDate is like FormatDateTime, adding the Hebrew date
+// next to the day of week.
+func FormatDateTimeWithHebrewDate(t time.Time) string {
+	return formatDateTime(t, fmt.Sprintf("%s, %s", getDayOfWeek(t), HebrewDateOf(t)))
+}
+
+func formatDateTime(t time.Time, day string) string {
 	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
-		return fmt.Sprintf("%s (%s)", t.Format(time.DateOnly), getDayOfWeek(t))
+		return fmt.Sprintf("%s (%s)", t.Format(time.DateOnly), day)
 	}
 
-	return fmt.Sprintf("%s (%s)", t.Format(time.DateTime), getDayOfWeek(t))
+	return fmt.Sprintf("%s (%s)", t.Format(time.DateTime), day)
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
 
 func getDayOfWeek(t time.Time) string {
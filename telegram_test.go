@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testRendererClock is the time the renderers of the tests see.
+var testRendererClock = NewFakeClock(time.Date(2024, time.June, 3, 10, 0, 0, 0, time.UTC))
+
 type testCase struct {
 	name     string
 	status   EventStatus
@@ -16,6 +20,7 @@ type testCase struct {
 }
 
 func TestPrepareMessageBodyDateTime(t *testing.T) {
+	md := markdownV2Markup{}
 	title := "some title"
 	start := time.Now().Add(24 * time.Hour)
 	end := start.Add(time.Hour)
@@ -24,17 +29,17 @@ func TestPrepareMessageBodyDateTime(t *testing.T) {
 		{
 			name:     "newly created event",
 			status:   StatusCreated,
-			expected: fmt.Sprintf("🗓️ *%s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(start), FormatDateTime(end)),
+			expected: fmt.Sprintf("🗓️ *%s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(start)), md.Escape(FormatDateTime(end))),
 		},
 		{
 			name:     "updated event",
 			status:   StatusUpdated,
-			expected: fmt.Sprintf("️✍🏻 *עדכון: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(start), FormatDateTime(end)),
+			expected: fmt.Sprintf("️✍🏻 *עדכון: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(start)), md.Escape(FormatDateTime(end))),
 		},
 		{
 			name:     "cancelled event",
 			status:   StatusCanceled,
-			expected: fmt.Sprintf("️🆇 *בוטל: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(start), FormatDateTime(end)),
+			expected: fmt.Sprintf("️🆇 *בוטל: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(start)), md.Escape(FormatDateTime(end))),
 		},
 	}
 
@@ -46,7 +51,7 @@ func TestPrepareMessageBodyDateTime(t *testing.T) {
 				End:    end,
 				Status: test.status,
 			}
-			actual, err := prepareMessageBody(event)
+			actual, err := renderer{clock: testRendererClock, markup: md}.prepareMessageBody(event)
 			require.NoError(t, err)
 			assert.Equal(t, test.expected, actual)
 		})
@@ -54,6 +59,7 @@ func TestPrepareMessageBodyDateTime(t *testing.T) {
 }
 
 func TestPrepareMessageBodyDateOnly(t *testing.T) {
+	md := markdownV2Markup{}
 	title := "some title"
 	start := time.Now().Add(24 * time.Hour)
 	end := start.Add(time.Hour)
@@ -65,17 +71,17 @@ func TestPrepareMessageBodyDateOnly(t *testing.T) {
 		{
 			name:     "newly created event",
 			status:   StatusCreated,
-			expected: fmt.Sprintf("🗓️ *%s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(startDay), FormatDateTime(endDay)),
+			expected: fmt.Sprintf("🗓️ *%s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(startDay)), md.Escape(FormatDateTime(endDay))),
 		},
 		{
 			name:     "updated event",
 			status:   StatusUpdated,
-			expected: fmt.Sprintf("️✍🏻 *עדכון: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(startDay), FormatDateTime(endDay)),
+			expected: fmt.Sprintf("️✍🏻 *עדכון: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(startDay)), md.Escape(FormatDateTime(endDay))),
 		},
 		{
 			name:     "cancelled event",
 			status:   StatusCanceled,
-			expected: fmt.Sprintf("️🆇 *בוטל: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, FormatDateTime(startDay), FormatDateTime(endDay)),
+			expected: fmt.Sprintf("️🆇 *בוטל: %s*\n\n*התחלה:* %s\n*סיום:* %s", title, md.Escape(FormatDateTime(startDay)), md.Escape(FormatDateTime(endDay))),
 		},
 	}
 
@@ -87,9 +93,265 @@ func TestPrepareMessageBodyDateOnly(t *testing.T) {
 				End:    endDay,
 				Status: test.status,
 			}
-			actual, err := prepareMessageBody(event)
+			actual, err := renderer{clock: testRendererClock, markup: md}.prepareMessageBody(event)
 			require.NoError(t, err)
 			assert.Equal(t, test.expected, actual)
 		})
 	}
 }
+
+func TestPrepareMessageBodyEscapesTitle(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	event := CalendarEvent{
+		Title:  "*bold* _it_ [link](x) <b>&",
+		Start:  start,
+		End:    end,
+		Status: StatusCreated,
+	}
+
+	tests := []struct {
+		name     string
+		markup   markup
+		expected string
+	}{
+		{
+			name:     "markdown v2",
+			markup:   markdownV2Markup{},
+			expected: "🗓️ *\\*bold\\* \\_it\\_ \\[link\\]\\(x\\) <b\\>&*\n\n*התחלה:* 2024\\-06\\-03 16:00:00 \\(שני\\)\n*סיום:* 2024\\-06\\-03 17:00:00 \\(שני\\)",
+		},
+		{
+			name:     "html",
+			markup:   htmlMarkup{},
+			expected: "🗓️ <b>*bold* _it_ [link](x) &lt;b&gt;&amp;</b>\n\n<b>התחלה:</b> 2024-06-03 16:00:00 (שני)\n<b>סיום:</b> 2024-06-03 17:00:00 (שני)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := renderer{clock: testRendererClock, markup: test.markup}.prepareMessageBody(event)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func FuzzPrepareMessageBody(f *testing.F) {
+	for _, seed := range []string{"", "some title", "*_[]()~`>#+-=|{}.!", "a\\b", "<b>&amp;</b>", "יום הולדת *שמח*", "\xff\x00"} {
+		f.Add(seed, seed)
+	}
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+
+	f.Fuzz(func(t *testing.T, title string, name string) {
+		people := newPeopleDirectory([]Person{
+			{Email: "creator@example.com", Name: name, TelegramId: 42},
+			{Email: "attendee@example.com", Name: name, TelegramUsername: name},
+		})
+		for _, status := range []EventStatus{StatusCreated, StatusUpdated, StatusCanceled} {
+			event := CalendarEvent{
+				Title:     title,
+				Start:     start,
+				End:       start.Add(time.Hour),
+				Creator:   "creator@example.com",
+				Attendees: []string{"attendee@example.com", name},
+				Status:    status,
+			}
+
+			body, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}, people: people}.prepareMessageBody(event)
+			require.NoError(t, err)
+			require.NoError(t, validateMarkdownV2(body), body)
+
+			body, err = renderer{clock: testRendererClock, markup: htmlMarkup{}, people: people}.prepareMessageBody(event)
+			require.NoError(t, err)
+			require.NoError(t, validateHTML(body), body)
+		}
+	})
+}
+
+func TestPrepareMessageBodyPeople(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:     "Parents meeting",
+		Start:     start,
+		End:       start.Add(time.Hour),
+		Creator:   "mom@example.com",
+		Attendees: []string{"dad@example.com", "grandma@example.com", "someone@example.com"},
+		Status:    StatusCreated,
+	}
+	people := newPeopleDirectory([]Person{
+		{Email: "mom@example.com", Name: "Mom", TelegramId: 11},
+		{Email: "dad@example.com", Name: "Dad", TelegramUsername: "dad_1"},
+		{Email: "grandma@example.com", Name: "Grandma"},
+	})
+
+	actual, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}, people: people}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Equal(t, "🗓️ *Parents meeting*\n\n"+
+		"*התחלה:* 2024\\-06\\-03 16:00:00 \\(שני\\)\n"+
+		"*סיום:* 2024\\-06\\-03 17:00:00 \\(שני\\)\n"+
+		"*נוצר על ידי:* [Mom](tg://user?id=11)\n"+
+		"*משתתפים:* @dad\\_1, Grandma, someone@example\\.com", actual)
+
+	actual, err = renderer{clock: testRendererClock, markup: htmlMarkup{}, people: people}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Contains(t, actual, "<b>נוצר על ידי:</b> <a href=\"tg://user?id=11\">Mom</a>")
+}
+
+func TestPrepareDigestBody(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	events := []CalendarEvent{
+		{Title: "Dentist", Start: start, End: start.Add(time.Hour), Status: StatusCreated},
+		{Title: "Football", Start: start.AddDate(0, 0, 1), End: start.AddDate(0, 0, 1), Status: StatusCanceled},
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}}.prepareDigestBody(events)
+	require.NoError(t, err)
+	assert.Equal(t, "*📬 2 עדכונים שהצטברו*\n\n"+
+		"🗓️ *Dentist*\n2024\\-06\\-03 16:00:00 \\(שני\\)\n\n"+
+		"️🆇 *בוטל: Football*\n2024\\-06\\-04 16:00:00 \\(שלישי\\)", actual)
+}
+
+func TestPrepareDigestBodyTruncates(t *testing.T) {
+	start := time.Date(2024, time.June, 3, 16, 0, 0, 0, time.UTC)
+	events := make([]CalendarEvent, 200)
+	for i := range events {
+		events[i] = CalendarEvent{Title: fmt.Sprintf("Event number %d", i), Start: start, End: start, Status: StatusUpdated}
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: htmlMarkup{}}.prepareDigestBody(events)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, utf8.RuneCountInString(actual), telegramMessageLimit)
+	assert.Regexp(t, `ועוד \d+\.\.\.$`, actual)
+	assert.NoError(t, validateHTML(actual))
+}
+
+func TestPrepareMessageBodyHebrewCalendar(t *testing.T) {
+	start := time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:  "Candle lighting",
+		Start:  start,
+		End:    start.Add(time.Hour),
+		Status: StatusCreated,
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: htmlMarkup{}, hebrewDate: true, showHolidays: true}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Equal(t, "🗓️ <b>Candle lighting</b>\n\n"+
+		"<b>התחלה:</b> 2024-12-26 17:30:00 (חמישי, כ״ה בכסלו)\n"+
+		"<b>סיום:</b> 2024-12-26 18:30:00 (חמישי, כ״ה בכסלו)\n"+
+		"✡️ חנוכה", actual)
+
+	event.Start = time.Date(2024, time.May, 12, 0, 0, 0, 0, time.UTC)
+	event.End = time.Date(2024, time.May, 14, 0, 0, 0, 0, time.UTC)
+	actual, err = renderer{clock: testRendererClock, markup: htmlMarkup{}, showHolidays: true}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Contains(t, actual, "\n✡️ יום הזיכרון, יום העצמאות")
+}
+
+func TestPrepareMessageBodyRelative(t *testing.T) {
+	now := time.Date(2024, time.December, 25, 10, 0, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:  "Candle lighting",
+		Start:  time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC),
+		End:    time.Date(2024, time.December, 26, 18, 0, 0, 0, time.UTC),
+		Status: StatusUpdated,
+	}
+	r := renderer{
+		markup:     markdownV2Markup{},
+		relative:   true,
+		hebrewDate: true,
+		clock:      NewFakeClock(now),
+	}
+
+	actual, err := r.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Equal(t, "️✍🏻 *עדכון: Candle lighting*\n\n"+
+		"*מתי:* מחר ב\\-17:30–18:00 \\(חצי שעה\\)\n"+
+		"*תאריך עברי:* כ״ה בכסלו", actual)
+
+	actual, err = r.prepareDigestBody([]CalendarEvent{event, event})
+	require.NoError(t, err)
+	assert.Contains(t, actual, "\n\n️✍🏻 *עדכון: Candle lighting*\nמחר ב\\-17:30–18:00 \\(חצי שעה\\)")
+}
+
+func TestPrepareCatchUpBody(t *testing.T) {
+	since := time.Date(2024, time.December, 19, 8, 0, 0, 0, time.UTC)
+	events := []CalendarEvent{
+		{Title: "Dentist", Start: time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC), Status: StatusCreated},
+		{Title: "Dinner", Start: time.Date(2024, time.December, 27, 19, 0, 0, 0, time.UTC), Status: StatusCanceled},
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}}.prepareCatchUpBody(since, events)
+	require.NoError(t, err)
+	assert.Equal(t, "*💤 בזמן שלא הייתי כאן השתנו 2 אירועים*\n"+
+		"\\(מאז 2024\\-12\\-19 08:00:00 \\(חמישי\\)\\)\n\n"+
+		"🗓️ *Dentist*\n2024\\-12\\-26 17:30:00 \\(חמישי\\)\n\n"+
+		"️🆇 *בוטל: Dinner*\n2024\\-12\\-27 19:00:00 \\(שישי\\)", actual)
+}
+
+func TestPrepareMessageBodyConflicts(t *testing.T) {
+	start := time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:  "Football",
+		Start:  start,
+		End:    start.Add(time.Hour),
+		Status: StatusCreated,
+		Conflicts: []CalendarEvent{
+			{Title: "Swimming", Start: start.Add(30 * time.Minute), End: start.Add(90 * time.Minute)},
+			{Title: "Trip", Start: start.Add(-24 * time.Hour), End: start.Add(24 * time.Hour)},
+		},
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Contains(t, actual,
+		"\n*⚠️ מתנגש עם:* Swimming \\(18:00–19:00\\), Trip \\(25\\.12 17:30–27\\.12 17:30\\)")
+}
+
+func TestPrepareMessageBodyLinks(t *testing.T) {
+	start := time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:  "Football",
+		Start:  start,
+		End:    start.Add(time.Hour),
+		Status: StatusCreated,
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: htmlMarkup{}, links: true}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Contains(t, actual, "\n<b>הוספה ליומן:</b> <a href=\"https://calendar.google.com/calendar/render?action=TEMPLATE&amp;dates=20241226T173000Z%2F20241226T183000Z&amp;text=Football\">Google</a> | <a href=\"https://outlook.live.com/")
+
+	event.Status = StatusCanceled
+	actual, err = renderer{clock: testRendererClock, markup: htmlMarkup{}, links: true}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.NotContains(t, actual, "הוספה ליומן")
+}
+
+func TestPrepareMessageBodyLocation(t *testing.T) {
+	start := time.Date(2024, time.December, 26, 17, 30, 0, 0, time.UTC)
+	event := CalendarEvent{
+		Title:    "Football",
+		Location: " Park (North) ",
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Status:   StatusCreated,
+	}
+
+	actual, err := renderer{clock: testRendererClock, markup: markdownV2Markup{}}.prepareMessageBody(event)
+	require.NoError(t, err)
+	assert.Contains(t, actual, "\n*מיקום:* Park \\(North\\)")
+}
+
+func TestNewVenue(t *testing.T) {
+	event := CalendarEvent{Title: "Football", Location: " geo:32.1,34.8 "}
+
+	venue := newVenue(42, event, Place{Latitude: 32.1, Longitude: 34.8})
+	assert.Equal(t, int64(42), venue.ChatID)
+	assert.Equal(t, "Football", venue.Title)
+	assert.Equal(t, "geo:32.1,34.8", venue.Address)
+	assert.Equal(t, 32.1, venue.Latitude)
+
+	venue = newVenue(42, event, Place{Name: "Park", Address: "Main St. 1", Latitude: 32.1, Longitude: 34.8})
+	assert.Equal(t, "Park", venue.Title)
+	assert.Equal(t, "Main St. 1", venue.Address)
+}
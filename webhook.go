@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookSecretHeader carries the secret token Telegram was given when the
+// webhook was registered.
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// webhookAllowedUpdates are the kinds of updates the dispatcher handles.
+var webhookAllowedUpdates = []string{"message", "callback_query", "poll_answer"}
+
+// webhookSecretPattern is what Telegram accepts as a secret token.
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookShutdownTimeout bounds the wait for updates being handled when the
+// server stops.
+const webhookShutdownTimeout = 10 * time.Second
+
+// WebhookServer receives the updates Telegram posts to the bot, for
+// deployments reachable from the internet, e.g. behind a reverse proxy.
+type WebhookServer struct {
+	cfg    Config
+	telcli Telegram
+}
+
+func NewWebhookServer(cfg Config, telcli Telegram) *WebhookServer {
+	return &WebhookServer{
+		cfg:    cfg,
+		telcli: telcli,
+	}
+}
+
+// ListenUpdates serves the webhook until the context is done. The webhook is
+// registered once the server listens, and deleted when it stops so that the
+// bot can go back to long polling.
+func (s *WebhookServer) ListenUpdates(ctx context.Context, handler UpdateHandler) error {
+	if !webhookSecretPattern.MatchString(s.cfg.WebhookSecret) {
+		return errors.New("webhook secret must be 1-256 letters, digits, '_' or '-'")
+	}
+	webhookURL, err := url.Parse(s.cfg.WebhookURL)
+	if err != nil || webhookURL.Scheme != "https" {
+		return errors.Errorf("invalid webhook url %q, expected an https url", s.cfg.WebhookURL)
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	listener, err := net.Listen("tcp", s.cfg.WebhookListenAddr)
+	if err != nil {
+		return errors.Wrap(err, "error listening for webhook requests")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, s.Handler(ctx, handler))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	if err := s.telcli.SetWebhook(s.cfg.WebhookURL, s.cfg.WebhookSecret); err != nil {
+		server.Close()
+		return errors.Wrap(err, "error registering webhook")
+	}
+	log.WithField("addr", listener.Addr().String()).Info("listening for webhook updates")
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		s.deleteWebhook()
+		return errors.Wrap(err, "error serving webhook")
+	}
+
+	s.deleteWebhook()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+func (s *WebhookServer) deleteWebhook() {
+	if err := s.telcli.DeleteWebhook(); err != nil {
+		log.WithError(err).Error("error deleting webhook")
+	}
+}
+
+// Handler accepts the updates Telegram posts with the right secret, and hands
+// them to the handler one at a time, as long polling does.
+func (s *WebhookServer) Handler(ctx context.Context, handler UpdateHandler) http.Handler {
+	var mu sync.Mutex
+	secret := []byte(s.cfg.WebhookSecret)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), secret) != 1 {
+			log.WithField("remoteAddr", r.RemoteAddr).Warn("rejecting webhook request with a wrong secret")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handler(ctx, update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
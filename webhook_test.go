@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "s3cret_token-1"
+
+// fakeTelegramAPI records the requests made to the Bot API, answering them
+// with the bare minimum tgbotapi expects.
+type fakeTelegramAPI struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []fakeAPICall
+}
+
+type fakeAPICall struct {
+	method string
+	params url.Values
+}
+
+func newFakeTelegramAPI(t *testing.T) *fakeTelegramAPI {
+	api := &fakeTelegramAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		api.mu.Lock()
+		api.calls = append(api.calls, fakeAPICall{method: method, params: r.PostForm})
+		api.mu.Unlock()
+
+		var result string
+		switch method {
+		case "getMe":
+			result = `{"id": 1, "is_bot": true, "first_name": "bot", "username": "calendarbot"}`
+		case "sendMessage":
+			result = fmt.Sprintf(`{"message_id": 1, "date": 0, "chat": {"id": %s}}`, r.PostForm.Get("chat_id"))
+		default:
+			result = "true"
+		}
+		fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+	}))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+// calledWith returns the parameters of the calls made to the method.
+func (api *fakeTelegramAPI) calledWith(method string) []url.Values {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var params []url.Values
+	for _, call := range api.calls {
+		if call.method == method {
+			params = append(params, call.params)
+		}
+	}
+	return params
+}
+
+func newFakeAPITelegram(t *testing.T, api *fakeTelegramAPI) Telegram {
+	telcli := NewTelegram(Config{
+		TelegramToken:       "token",
+		TelegramParseMode:   tgbotapi.ModeMarkdownV2,
+		TelegramAPIEndpoint: api.URL + "/bot%s/%s",
+	}, peopleDirectory{}, addressBook{}, NewFakeClock(time.Now()))
+	require.NoError(t, telcli.Init())
+
+	return telcli
+}
+
+const pingUpdate = `{"update_id": 1, "message": {"message_id": 5, "date": 0, "chat": {"id": 42, "type": "private"},
+	"text": "/ping", "entities": [{"type": "bot_command", "offset": 0, "length": 5}]}}`
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		secret   string
+		body     string
+		expected int
+		handled  bool
+	}{
+		{
+			name:     "valid update",
+			method:   http.MethodPost,
+			secret:   testWebhookSecret,
+			body:     pingUpdate,
+			expected: http.StatusOK,
+			handled:  true,
+		},
+		{
+			name:     "missing secret",
+			method:   http.MethodPost,
+			body:     pingUpdate,
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "wrong secret",
+			method:   http.MethodPost,
+			secret:   "guess",
+			body:     pingUpdate,
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "wrong method",
+			method:   http.MethodGet,
+			secret:   testWebhookSecret,
+			expected: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "malformed update",
+			method:   http.MethodPost,
+			secret:   testWebhookSecret,
+			body:     `{"update_id": `,
+			expected: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var updates []tgbotapi.Update
+			server := NewWebhookServer(Config{WebhookSecret: testWebhookSecret}, &TelegramClientMock{})
+			handler := server.Handler(context.Background(), func(_ context.Context, update tgbotapi.Update) {
+				updates = append(updates, update)
+			})
+
+			req := httptest.NewRequest(test.method, "/hook", strings.NewReader(test.body))
+			if test.secret != "" {
+				req.Header.Set(webhookSecretHeader, test.secret)
+			}
+			rec := httptest.NewRecorder()
+
+			// SUT
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expected, rec.Code)
+			if test.handled {
+				require.Len(t, updates, 1)
+				assert.Equal(t, "ping", updates[0].Message.Command())
+			} else {
+				assert.Empty(t, updates)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatchesToCommands(t *testing.T) {
+	api := newFakeTelegramAPI(t)
+	telcli := newFakeAPITelegram(t, api)
+	dispatcher := NewDispatcher()
+	dispatcher.HandleCommand("ping", func(_ context.Context, msg *tgbotapi.Message) error {
+		return telcli.SendText(msg.Chat.ID, "pong")
+	})
+	server := NewWebhookServer(Config{WebhookSecret: testWebhookSecret}, telcli)
+	hook := httptest.NewServer(server.Handler(context.Background(), dispatcher.HandleUpdate))
+	defer hook.Close()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(pingUpdate))
+	require.NoError(t, err)
+	req.Header.Set(webhookSecretHeader, testWebhookSecret)
+
+	// SUT
+	resp, err := http.DefaultClient.Do(req)
+
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	sent := api.calledWith("sendMessage")
+	require.Len(t, sent, 1)
+	assert.Equal(t, "42", sent[0].Get("chat_id"))
+	assert.Equal(t, "pong", sent[0].Get("text"))
+}
+
+func TestWebhookRegistersAndDeletes(t *testing.T) {
+	api := newFakeTelegramAPI(t)
+	telcli := newFakeAPITelegram(t, api)
+	server := NewWebhookServer(Config{
+		WebhookURL:        "https://bot.example.com/telegram/hook",
+		WebhookSecret:     testWebhookSecret,
+		WebhookListenAddr: "127.0.0.1:0",
+	}, telcli)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// SUT
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenUpdates(ctx, func(context.Context, tgbotapi.Update) {})
+	}()
+
+	require.Eventually(t, func() bool { return len(api.calledWith("setWebhook")) == 1 }, time.Second, 10*time.Millisecond)
+	registered := api.calledWith("setWebhook")[0]
+	assert.Equal(t, "https://bot.example.com/telegram/hook", registered.Get("url"))
+	assert.Equal(t, testWebhookSecret, registered.Get("secret_token"))
+	assert.JSONEq(t, `["message", "callback_query", "poll_answer"]`, registered.Get("allowed_updates"))
+	assert.Empty(t, api.calledWith("deleteWebhook"))
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Len(t, api.calledWith("deleteWebhook"), 1)
+}
+
+func TestWebhookRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "missing secret",
+			cfg:  Config{WebhookURL: "https://bot.example.com/hook", WebhookListenAddr: "127.0.0.1:0"},
+		},
+		{
+			name: "secret with forbidden characters",
+			cfg:  Config{WebhookURL: "https://bot.example.com/hook", WebhookSecret: "not secret!", WebhookListenAddr: "127.0.0.1:0"},
+		},
+		{
+			name: "plain http url",
+			cfg:  Config{WebhookURL: "http://bot.example.com/hook", WebhookSecret: testWebhookSecret, WebhookListenAddr: "127.0.0.1:0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			telCliMock := &TelegramClientMock{}
+			server := NewWebhookServer(test.cfg, telCliMock)
+
+			err := server.ListenUpdates(context.Background(), func(context.Context, tgbotapi.Update) {})
+
+			assert.Error(t, err)
+			telCliMock.AssertNotCalled(t, "SetWebhook", test.cfg.WebhookURL, test.cfg.WebhookSecret)
+		})
+	}
+}
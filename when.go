@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FormatWhen describes when an event takes place relative to now, the way
+// people would say it, e.g. "מחר ב-16:00–17:30 (שעה וחצי)". All day events
+// end on their last day, as parsed from the calendar.
+func FormatWhen(start, end time.Time, allDay bool, now time.Time) string {
+	now = now.In(start.Location())
+	end = end.In(start.Location())
+	sameDay := calendarDaysBetween(start, end) == 0
+
+	switch {
+	case allDay && sameDay:
+		return fmt.Sprintf("%s (כל היום)", relativeDay(start, now))
+	case allDay:
+		days := calendarDaysBetween(start, end) + 1
+		return fmt.Sprintf("%s עד %s (%s)", relativeDay(start, now), absoluteDay(end, now), formatDays(days))
+	case !end.After(start):
+		return fmt.Sprintf("%s ב-%s", relativeDay(start, now), start.Format("15:04"))
+	case sameDay:
+		return fmt.Sprintf("%s ב-%s–%s (%s)",
+			relativeDay(start, now), start.Format("15:04"), end.Format("15:04"), FormatDuration(end.Sub(start)))
+	default:
+		return fmt.Sprintf("%s ב-%s עד %s ב-%s (%s)",
+			relativeDay(start, now), start.Format("15:04"), absoluteDay(end, now), end.Format("15:04"), FormatDuration(end.Sub(start)))
+	}
+}
+
+// relativeDay names the day of t relative to now: "מחר", "ביום חמישי הקרוב",
+// "בעוד 3 שבועות, ביום חמישי 27.6" and so on.
+func relativeDay(t, now time.Time) string {
+	days := calendarDaysBetween(now, t)
+	switch {
+	case days == -1:
+		return "אתמול"
+	case days == 0:
+		return "היום"
+	case days == 1:
+		return "מחר"
+	case days == 2:
+		return "מחרתיים"
+	case days >= 3 && days <= 6:
+		if t.Weekday() == time.Saturday {
+			return "בשבת הקרובה"
+		}
+		return fmt.Sprintf("ביום %s הקרוב", getDayOfWeek(t))
+	case days >= 7 && days <= 13:
+		if t.Weekday() == time.Saturday {
+			return fmt.Sprintf("בשבת הבאה, %s", shortDate(t, now))
+		}
+		return fmt.Sprintf("ביום %s הבא, %s", getDayOfWeek(t), shortDate(t, now))
+	case days >= 14:
+		return fmt.Sprintf("בעוד %s, %s", formatDistance(days), "ב"+absoluteDay(t, now))
+	default:
+		return "ב" + absoluteDay(t, now)
+	}
+}
+
+// absoluteDay names the day of t without relating it to now, e.g.
+// "יום חמישי 27.6".
+func absoluteDay(t, now time.Time) string {
+	if t.Weekday() == time.Saturday {
+		return "שבת " + shortDate(t, now)
+	}
+	return fmt.Sprintf("יום %s %s", getDayOfWeek(t), shortDate(t, now))
+}
+
+// shortDate writes the day and month, adding the year only when it's not the
+// current one.
+func shortDate(t, now time.Time) string {
+	if t.Year() != now.Year() {
+		return t.Format("2.1.2006")
+	}
+	return t.Format("2.1")
+}
+
+// formatDistance writes a number of days from now in the largest unit that
+// fits it.
+func formatDistance(days int) string {
+	switch {
+	case days >= 365:
+		return hebrewCount(days/365, "שנה", "שנתיים", "שנים")
+	case days >= 60:
+		return hebrewCount(days/30, "חודש", "חודשיים", "חודשים")
+	default:
+		return hebrewCount(days/7, "שבוע", "שבועיים", "שבועות")
+	}
+}
+
+// FormatDuration writes a duration in Hebrew, e.g. "שעה וחצי" or
+// "יומיים ו-5 שעות".
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, formatDays(days))
+	}
+	switch {
+	case hours == 0 && days == 0:
+		parts = append(parts, formatMinutes(minutes))
+	case minutes == 30 && days == 0:
+		parts = append(parts, formatHours(hours)+" וחצי")
+	default:
+		if hours > 0 {
+			parts = append(parts, formatHours(hours))
+		}
+		if minutes > 0 && days == 0 {
+			parts = append(parts, hebrewCount(minutes, "דקה", "2 דקות", "דקות"))
+		}
+	}
+
+	return joinHebrew(parts)
+}
+
+func formatDays(days int) string {
+	return hebrewCount(days, "יום", "יומיים", "ימים")
+}
+
+func formatHours(hours int) string {
+	return hebrewCount(hours, "שעה", "שעתיים", "שעות")
+}
+
+func formatMinutes(minutes int) string {
+	switch minutes {
+	case 15:
+		return "רבע שעה"
+	case 30:
+		return "חצי שעה"
+	case 45:
+		return "שלושת רבעי שעה"
+	default:
+		return hebrewCount(minutes, "דקה", "2 דקות", "דקות")
+	}
+}
+
+// hebrewCount writes a count of something, given the words for one, two and
+// many of it.
+func hebrewCount(n int, one, two, many string) string {
+	switch n {
+	case 1:
+		return one
+	case 2:
+		return two
+	default:
+		return fmt.Sprintf("%d %s", n, many)
+	}
+}
+
+// joinHebrew joins the parts with "ו", which takes a hyphen before digits.
+func joinHebrew(parts []string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		switch {
+		case i == 0:
+		case part[0] >= '0' && part[0] <= '9':
+			b.WriteString(" ו-")
+		default:
+			b.WriteString(" ו")
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// calendarDaysBetween counts the days between the dates of from and to, as
+// seen in their own locations, regardless of DST changes.
+func calendarDaysBetween(from, to time.Time) int {
+	return fixedFromTime(to) - fixedFromTime(from)
+}
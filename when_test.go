@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatWhen(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	// Monday
+	now := time.Date(2024, time.June, 3, 10, 0, 0, 0, tz)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, tz)
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		allDay   bool
+		expected string
+	}{
+		{"later today", at(time.June, 3, 16, 0), at(time.June, 3, 17, 30), false, "היום ב-16:00–17:30 (שעה וחצי)"},
+		{"tomorrow", at(time.June, 4, 16, 0), at(time.June, 4, 17, 0), false, "מחר ב-16:00–17:00 (שעה)"},
+		{"day after tomorrow", at(time.June, 5, 8, 15), at(time.June, 5, 8, 30), false, "מחרתיים ב-08:15–08:30 (רבע שעה)"},
+		{"this week", at(time.June, 6, 16, 0), at(time.June, 6, 18, 0), false, "ביום חמישי הקרוב ב-16:00–18:00 (שעתיים)"},
+		{"this shabbat", at(time.June, 8, 11, 0), at(time.June, 8, 13, 30), false, "בשבת הקרובה ב-11:00–13:30 (שעתיים וחצי)"},
+		{"next week", at(time.June, 13, 16, 0), at(time.June, 13, 17, 20), false, "ביום חמישי הבא, 13.6 ב-16:00–17:20 (שעה ו-20 דקות)"},
+		{"in three weeks", at(time.June, 27, 16, 0), at(time.June, 27, 20, 0), false, "בעוד 3 שבועות, ביום חמישי 27.6 ב-16:00–20:00 (4 שעות)"},
+		{"in two months", at(time.August, 8, 9, 0), at(time.August, 8, 9, 45), false, "בעוד חודשיים, ביום חמישי 8.8 ב-09:00–09:45 (שלושת רבעי שעה)"},
+		{"next year", time.Date(2025, time.June, 5, 9, 0, 0, 0, tz), time.Date(2025, time.June, 5, 9, 30, 0, 0, tz), false, "בעוד שנה, ביום חמישי 5.6.2025 ב-09:00–09:30 (חצי שעה)"},
+		{"yesterday", at(time.June, 2, 9, 0), at(time.June, 2, 10, 0), false, "אתמול ב-09:00–10:00 (שעה)"},
+		{"last week", at(time.May, 28, 9, 0), at(time.May, 28, 10, 0), false, "ביום שלישי 28.5 ב-09:00–10:00 (שעה)"},
+		{"no duration", at(time.June, 4, 16, 0), at(time.June, 4, 16, 0), false, "מחר ב-16:00"},
+		{"over midnight", at(time.June, 4, 22, 0), at(time.June, 5, 2, 0), false, "מחר ב-22:00 עד יום רביעי 5.6 ב-02:00 (4 שעות)"},
+		{"multi day", at(time.June, 4, 16, 0), at(time.June, 6, 18, 0), false, "מחר ב-16:00 עד יום חמישי 6.6 ב-18:00 (יומיים ושעתיים)"},
+		{"all day", date(2024, time.June, 6), date(2024, time.June, 6), true, "ביום חמישי הקרוב (כל היום)"},
+		{"all day range", date(2024, time.June, 6), date(2024, time.June, 9), true, "ביום חמישי הקרוב עד יום ראשון 9.6 (4 ימים)"},
+		{"two all days", date(2024, time.June, 4), date(2024, time.June, 5), true, "מחר עד יום רביעי 5.6 (יומיים)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, FormatWhen(test.start, test.end, test.allDay, now))
+		})
+	}
+}
+
+func TestFormatWhenAroundMidnight(t *testing.T) {
+	tz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	start := time.Date(2024, time.June, 4, 0, 30, 0, 0, tz)
+	end := start.Add(time.Hour)
+
+	assert.Equal(t, "מחר ב-00:30–01:30 (שעה)", FormatWhen(start, end, false, time.Date(2024, time.June, 3, 23, 59, 0, 0, tz)))
+	assert.Equal(t, "היום ב-00:30–01:30 (שעה)", FormatWhen(start, end, false, time.Date(2024, time.June, 4, 0, 0, 0, 0, tz)))
+	// the clock is compared in the event's timezone, not in UTC
+	assert.Equal(t, "מחר ב-00:30–01:30 (שעה)", FormatWhen(start, end, false, time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC)))
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{time.Minute, "דקה"},
+		{10 * time.Minute, "10 דקות"},
+		{30 * time.Minute, "חצי שעה"},
+		{time.Hour, "שעה"},
+		{time.Hour + 30*time.Minute, "שעה וחצי"},
+		{2 * time.Hour, "שעתיים"},
+		{3*time.Hour + 5*time.Minute, "3 שעות ו-5 דקות"},
+		{24 * time.Hour, "יום"},
+		{25 * time.Hour, "יום ושעה"},
+		{3*24*time.Hour + 5*time.Hour, "3 ימים ו-5 שעות"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.duration.String(), func(t *testing.T) {
+			assert.Equal(t, test.expected, FormatDuration(test.duration))
+		})
+	}
+}
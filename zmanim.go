@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// GeoLocation is a place on earth, used to compute sunset times locally.
+type GeoLocation struct {
+	Latitude  float64
+	Longitude float64
+	Location  *time.Location
+}
+
+// Sunset returns the time of sunset on the given date at the location, using
+// the NOAA sunrise equation which is accurate to about a minute. It reports
+// false when the sun doesn't set on that day, like near the poles in summer.
+func (g GeoLocation) Sunset(date time.Time) (time.Time, bool) {
+	const julianUnixEpoch = 2440587.5
+	const j2000 = 2451545.0
+
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	julianDate := float64(midnight.Unix())/(24*60*60) + julianUnixEpoch
+
+	n := math.Ceil(julianDate - j2000 + 0.0008)
+	meanSolarTime := n - g.Longitude/360
+	meanAnomaly := math.Mod(357.5291+0.98560028*meanSolarTime, 360)
+	m := radians(meanAnomaly)
+	center := 1.9148*math.Sin(m) + 0.02*math.Sin(2*m) + 0.0003*math.Sin(3*m)
+	eclipticLongitude := radians(math.Mod(meanAnomaly+center+180+102.9372, 360))
+	transit := j2000 + meanSolarTime + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*eclipticLongitude)
+
+	sinDeclination := math.Sin(eclipticLongitude) * math.Sin(radians(23.4397))
+	cosDeclination := math.Cos(math.Asin(sinDeclination))
+	latitude := radians(g.Latitude)
+	cosHourAngle := (math.Sin(radians(-0.833)) - math.Sin(latitude)*sinDeclination) /
+		(math.Cos(latitude) * cosDeclination)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, false
+	}
+
+	set := transit + degrees(math.Acos(cosHourAngle))/360
+	seconds := (set - julianUnixEpoch) * 24 * 60 * 60
+	return time.Unix(int64(math.Round(seconds)), 0).In(g.Location), true
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
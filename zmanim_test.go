@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSunset(t *testing.T) {
+	jerusalemTz, err := time.LoadLocation("Asia/Jerusalem")
+	require.NoError(t, err)
+	newYorkTz, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	jerusalem := GeoLocation{Latitude: 31.778, Longitude: 35.235, Location: jerusalemTz}
+	newYork := GeoLocation{Latitude: 40.713, Longitude: -74.006, Location: newYorkTz}
+
+	tests := []struct {
+		name     string
+		location GeoLocation
+		date     time.Time
+		expected time.Time
+	}{
+		{"jerusalem summer", jerusalem, time.Date(2024, time.June, 14, 0, 0, 0, 0, jerusalemTz), time.Date(2024, time.June, 14, 19, 47, 0, 0, jerusalemTz)},
+		{"jerusalem winter", jerusalem, time.Date(2024, time.December, 20, 0, 0, 0, 0, jerusalemTz), time.Date(2024, time.December, 20, 16, 39, 0, 0, jerusalemTz)},
+		{"new york", newYork, time.Date(2024, time.March, 15, 0, 0, 0, 0, newYorkTz), time.Date(2024, time.March, 15, 19, 3, 0, 0, newYorkTz)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, ok := test.location.Sunset(test.date)
+			require.True(t, ok)
+			assert.WithinDuration(t, test.expected, actual, 2*time.Minute)
+			assert.Equal(t, test.location.Location, actual.Location())
+		})
+	}
+}
+
+func TestSunsetPolarDay(t *testing.T) {
+	svalbard := GeoLocation{Latitude: 78.22, Longitude: 15.65, Location: time.UTC}
+
+	_, ok := svalbard.Sunset(time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}